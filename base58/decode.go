@@ -10,10 +10,12 @@ func Decode(input []byte) []byte {
 	result := big.NewInt(0)
 	zeroBytes := 0
 
-	for b := range input {
-		if b == 0x00 {
-			zeroBytes++
+	// Leading zero bytes are encoded as the first character of the alphabet
+	for _, b := range input {
+		if b != base58Alphabet[0] {
+			break
 		}
+		zeroBytes++
 	}
 
 	payload := input[zeroBytes:]
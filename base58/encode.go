@@ -20,7 +20,7 @@ func Encode(input []byte) []byte {
 	}
 
 	ReverseBytes(result)
-	for b := range input {
+	for _, b := range input {
 		if b == 0x00 {
 			result = append([]byte{base58Alphabet[0]}, result...)
 		} else {
@@ -0,0 +1,218 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"log"
+	"sort"
+)
+
+const addrIndexBucket = "addrindex"
+
+var addrIndexBucketName = []byte(addrIndexBucket)
+
+// AddressOutput is an output paid to an address on the main chain
+type AddressOutput struct {
+	TxID    []byte
+	Vout    int
+	Value   int
+	Height  int
+	SpentBy []byte
+}
+
+// Serialize serializes the address output
+func (out AddressOutput) Serialize() []byte {
+	return GobEncode(out)
+}
+
+// DeserializeAddressOutput deserializes an address output
+func DeserializeAddressOutput(data []byte) AddressOutput {
+	var out AddressOutput
+
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	err := decoder.Decode(&out)
+	logPanicErr(err)
+
+	return out
+}
+
+// addrIndexKey keys outputs by pubkey hash, so that the outputs of an address share a prefix
+func addrIndexKey(pubKeyHash, txID []byte, vout int) []byte {
+	key := append(append([]byte{}, pubKeyHash...), txID...)
+	index := make([]byte, 4)
+	binary.BigEndian.PutUint32(index, uint32(vout))
+
+	return append(key, index...)
+}
+
+// indexAddresses adds the outputs of the block and marks the outputs its inputs spend
+func indexAddresses(addrIndex StoreBucket, block *Block) error {
+	for _, tx := range block.Transactions {
+		if tx.IsGovernance() {
+			continue
+		}
+
+		if !tx.IsCoinbase() {
+			for _, in := range tx.Vin {
+				key := addrIndexKey(HashPubKey(in.PubKey), in.TxID, in.Vout)
+				data := addrIndex.Get(key)
+				if data == nil {
+					continue
+				}
+
+				out := DeserializeAddressOutput(data)
+				out.SpentBy = tx.ID
+				err := addrIndex.Put(key, out.Serialize())
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		for i, txOut := range tx.Vout {
+			out := AddressOutput{tx.ID, i, txOut.Value, block.Height, nil}
+			err := addrIndex.Put(addrIndexKey(txOut.PubKeyHash, tx.ID, i), out.Serialize())
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// unindexAddresses reverts indexAddresses for a block leaving the main chain
+func unindexAddresses(addrIndex StoreBucket, block *Block) error {
+	for i := len(block.Transactions) - 1; i >= 0; i-- {
+		tx := block.Transactions[i]
+		if tx.IsGovernance() {
+			continue
+		}
+
+		for j, txOut := range tx.Vout {
+			err := addrIndex.Delete(addrIndexKey(txOut.PubKeyHash, tx.ID, j))
+			if err != nil {
+				return err
+			}
+		}
+
+		if tx.IsCoinbase() {
+			continue
+		}
+
+		for _, in := range tx.Vin {
+			key := addrIndexKey(HashPubKey(in.PubKey), in.TxID, in.Vout)
+			data := addrIndex.Get(key)
+			if data == nil {
+				continue
+			}
+
+			out := DeserializeAddressOutput(data)
+			out.SpentBy = nil
+			err := addrIndex.Put(key, out.Serialize())
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// EnableAddrIndex creates the address index from the main chain if it does not exist.
+// Once created the index is maintained as blocks are connected and disconnected.
+func (bc *Blockchain) EnableAddrIndex() {
+	err := bc.store.Update(func(tx StoreTx) error {
+		if tx.Bucket(addrIndexBucketName) != nil {
+			return nil
+		}
+
+		if prunedHeight(tx.Bucket(blocksBucketName)) >= 0 {
+			return errors.New("The address index can not be built on a pruned chain")
+		}
+
+		addrIndex, err := tx.CreateBucket(addrIndexBucketName)
+		if err != nil {
+			return err
+		}
+
+		// Spends are only found once the outputs are indexed, so blocks go in height order
+		b := tx.Bucket(blocksBucketName)
+		c := tx.Bucket(heightIndexBucketName).Cursor()
+		for k, hash := c.First(); k != nil; k, hash = c.Next() {
+			block, err := DeserializeBlock(b.Get(hash))
+			if err != nil {
+				return err
+			}
+
+			err = indexAddresses(addrIndex, block)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+// HasAddrIndex returns whether the address index is maintained
+func (bc *Blockchain) HasAddrIndex() bool {
+	hasIndex := false
+
+	err := bc.store.View(func(tx StoreTx) error {
+		hasIndex = tx.Bucket(addrIndexBucketName) != nil
+		return nil
+	})
+	logPanicErr(err)
+
+	return hasIndex
+}
+
+// GetAddressHistory returns every main chain output paid to the pubkey hash in height order
+func (bc *Blockchain) GetAddressHistory(pubKeyHash []byte) []AddressOutput {
+	var history []AddressOutput
+
+	err := bc.store.View(func(tx StoreTx) error {
+		addrIndex := tx.Bucket(addrIndexBucketName)
+		if addrIndex == nil {
+			log.Panic("ERROR: Address index is not enabled")
+		}
+
+		c := addrIndex.Cursor()
+		for k, v := c.Seek(pubKeyHash); k != nil && bytes.HasPrefix(k, pubKeyHash); k, v = c.Next() {
+			// Skip longer pubkey hashes sharing the prefix
+			if len(k) != len(pubKeyHash)+32+4 {
+				continue
+			}
+			history = append(history, DeserializeAddressOutput(v))
+		}
+
+		return nil
+	})
+	logPanicErr(err)
+
+	sort.SliceStable(history, func(i, j int) bool {
+		return history[i].Height < history[j].Height
+	})
+
+	return history
+}
+
+// GetAddressUTXOs returns the unspent main chain outputs paid to the pubkey hash
+func (bc *Blockchain) GetAddressUTXOs(pubKeyHash []byte) []AddressOutput {
+	var utxos []AddressOutput
+
+	for _, out := range bc.GetAddressHistory(pubKeyHash) {
+		if out.SpentBy == nil {
+			utxos = append(utxos, out)
+		}
+	}
+
+	return utxos
+}
@@ -0,0 +1,85 @@
+package blockchain
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestAddrIndexReorganization(t *testing.T) {
+	bc, wallet := newTestBlockchain(t)
+	bc.EnableAddrIndex()
+	mineBlocks(bc, wallet, 1)
+	genesis, _ := bc.GetBlockByHeight(0)
+	fork, _ := bc.GetBlockByHeight(1)
+	funds := genesis.Transactions[0]
+
+	other := NewWallet()
+	walletHash := HashPubKey(wallet.PublicKey)
+	otherHash := HashPubKey(other.PublicKey)
+	otherAddress := string(other.GetAddress(bc.Params))
+
+	// The main chain pays the other wallet from the genesis coinbase
+	pay := Transaction{nil, []TXInput{{funds.ID, 0, wallet.PublicKey, nil}}, []TXOutput{
+		*NewTXOutput(4, otherAddress),
+		*NewTXOutput(6, string(wallet.GetAddress(bc.Params))),
+	}}
+	pay.ID = pay.Hash()
+	bc.SignTransaction(&pay, wallet.PrivateKey)
+	bc.AddBlock(newTestBlock(bc, wallet, &pay))
+
+	// A longer branch pays the other wallet its coinbase instead
+	reward := NewCoinbaseTX(otherAddress, "", bc.Params.BlockSubsidy(2))
+	side2, err := NewBlock([]*Transaction{reward}, fork.Hash, 2, bc.Engine())
+	logPanicErr(err)
+	side3, err := NewBlock([]*Transaction{newTestCoinbase(bc, wallet)}, side2.Hash, 3, bc.Engine())
+	logPanicErr(err)
+
+	tests := []struct {
+		name    string
+		blocks  []*Block
+		other   []AddressOutput
+		spentBy []byte
+	}{
+		{"main chain", nil, []AddressOutput{{pay.ID, 0, 4, 2, nil}}, pay.ID},
+		{"longer branch", []*Block{side2, side3}, []AddressOutput{{reward.ID, 0, reward.Vout[0].Value, 2, nil}}, nil},
+	}
+
+	for _, test := range tests {
+		for _, block := range test.blocks {
+			bc.AddBlock(block)
+		}
+
+		if history := bc.GetAddressHistory(otherHash); !reflect.DeepEqual(history, test.other) {
+			t.Errorf("%s: history of the other wallet is %v, expected %v", test.name, history, test.other)
+		}
+
+		history := bc.GetAddressHistory(walletHash)
+		if len(history) == 0 || !bytes.Equal(history[0].TxID, funds.ID) {
+			t.Fatalf("%s: history of the wallet does not start with the genesis coinbase", test.name)
+		}
+		if !bytes.Equal(history[0].SpentBy, test.spentBy) {
+			t.Errorf("%s: genesis coinbase is spent by %x, expected %x", test.name, history[0].SpentBy, test.spentBy)
+		}
+		for _, out := range history {
+			if bytes.Equal(out.TxID, pay.ID) && test.spentBy == nil {
+				t.Errorf("%s: change of the disconnected payment is in the history", test.name)
+			}
+		}
+	}
+
+	// The maintained index matches one built from the main chain
+	maintained := [][]AddressOutput{bc.GetAddressHistory(walletHash), bc.GetAddressHistory(otherHash)}
+	err = bc.store.Update(func(tx StoreTx) error {
+		return tx.DeleteBucket(addrIndexBucketName)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc.EnableAddrIndex()
+
+	rebuilt := [][]AddressOutput{bc.GetAddressHistory(walletHash), bc.GetAddressHistory(otherHash)}
+	if !reflect.DeepEqual(rebuilt, maintained) {
+		t.Errorf("Rebuilt index %v differs from the maintained one %v", rebuilt, maintained)
+	}
+}
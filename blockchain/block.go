@@ -3,6 +3,7 @@ package blockchain
 import (
 	"bytes"
 	"encoding/gob"
+	"log"
 	"time"
 )
 
@@ -14,6 +15,13 @@ type Block struct {
 	Nonce         int
 	Transactions  []*Transaction
 	Height        int
+
+	// Signer and Signature seal the block under proof of authority
+	Signer    []byte
+	Signature []byte
+
+	// Pruned is set on the stored header of a block whose body was pruned
+	Pruned bool
 }
 
 // Serialize serializes block data to bytes
@@ -21,8 +29,11 @@ func (b *Block) Serialize() []byte {
 	var result bytes.Buffer
 	encoder := gob.NewEncoder(&result)
 
-	// Todo - handle error
-	encoder.Encode(b)
+	err := encoder.Encode(b)
+	if err != nil {
+		log.Panic(err)
+	}
+
 	return result.Bytes()
 }
 
@@ -31,25 +42,29 @@ func (b *Block) HashTransactions() []byte {
 	var transactions [][]byte
 
 	for _, tx := range b.Transactions {
-		transactions = append(transactions, tx.Serialize())
+		transactions = append(transactions, tx.hashData())
 	}
 	mTree := NewMerkleTree(transactions)
 	return mTree.Root.Data
 }
 
-// DeserializeBlock converts serialized block bytes to block
-func DeserializeBlock(b []byte) *Block {
+// DeserializeBlock converts serialized block bytes to block. Blocks received
+// from peers are deserialized too, so malformed data is returned as an error.
+func DeserializeBlock(b []byte) (*Block, error) {
 	var block Block
 
 	decoder := gob.NewDecoder(bytes.NewReader(b))
-	// Todo - handle error
-	decoder.Decode(&block)
+	err := decoder.Decode(&block)
+	if err != nil {
+		return nil, err
+	}
 
-	return &block
+	return &block, nil
 }
 
-// NewBlock creates and returns Block
-func NewBlock(transactions []*Transaction, prevBlockHash []byte, height int) *Block {
+// NewBlock creates and returns Block sealed by the consensus engine. It returns the error
+// of the engine when the block can not be sealed, like a validator that is not in turn.
+func NewBlock(transactions []*Transaction, prevBlockHash []byte, height int, engine Engine) (*Block, error) {
 	block := &Block{
 		Transactions:  transactions,
 		Timestamp:     time.Now().Unix(),
@@ -57,16 +72,25 @@ func NewBlock(transactions []*Transaction, prevBlockHash []byte, height int) *Bl
 		Height:        height,
 	}
 
-	pow := NewProofOfWork(block)
-	nonce, hash := pow.Run()
-
-	block.Hash = hash[:]
-	block.Nonce = nonce
+	err := engine.Seal(block)
+	if err != nil {
+		return nil, err
+	}
 
-	return block
+	return block, nil
 }
 
 // NewGenesisBlock creates and returns the genesis block
-func NewGenesisBlock(coninbase *Transaction) *Block {
-	return NewBlock([]*Transaction{coninbase}, []byte{}, 0)
+func NewGenesisBlock(coninbase *Transaction, params *ChainParams) *Block {
+	block := &Block{
+		Transactions:  []*Transaction{coninbase},
+		Timestamp:     params.GenesisTimestamp,
+		PrevBlockHash: []byte{},
+		Height:        0,
+	}
+
+	// The genesis block is mined under proof of work on every network
+	NewPoWEngine(params).Seal(block)
+
+	return block
 }
@@ -0,0 +1,152 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	// blockRecordHeaderLen is the size of the network magic and block length starting every record
+	blockRecordHeaderLen = 8
+
+	// maxBlockRecordLen caps the size of a block read from a block file
+	maxBlockRecordLen = 32 << 20
+)
+
+// writeBlockRecord writes a serialized block framed by the network magic and its length
+func writeBlockRecord(w io.Writer, magic uint32, blockData []byte) error {
+	header := make([]byte, blockRecordHeaderLen)
+	binary.BigEndian.PutUint32(header[:4], magic)
+	binary.BigEndian.PutUint32(header[4:], uint32(len(blockData)))
+
+	_, err := w.Write(header)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(blockData)
+	return err
+}
+
+// readBlockRecord reads the next framed block, io.EOF at the end of the file
+func readBlockRecord(r io.Reader, magic uint32) (*Block, error) {
+	header := make([]byte, blockRecordHeaderLen)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return nil, err
+	}
+
+	if binary.BigEndian.Uint32(header[:4]) != magic {
+		return nil, errors.New("Block file is not of this network")
+	}
+
+	length := binary.BigEndian.Uint32(header[4:])
+	if length > maxBlockRecordLen {
+		return nil, fmt.Errorf("Block of %d bytes is over the limit", length)
+	}
+
+	blockData := make([]byte, length)
+	_, err = io.ReadFull(r, blockData)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return DeserializeBlock(blockData)
+}
+
+// ExportChain writes the main chain blocks from height from to height to in height order,
+// to the tip when to is negative. It returns the number of blocks written.
+func (bc *Blockchain) ExportChain(w io.Writer, from, to int) (int, error) {
+	tipHeight := bc.GetBestHeight()
+	if to < 0 || to > tipHeight {
+		to = tipHeight
+	}
+	if from < 0 || from > to {
+		return 0, fmt.Errorf("Height range %d to %d is not in the chain", from, to)
+	}
+
+	for height := from; height <= to; height++ {
+		block, err := bc.GetBlockByHeight(height)
+		if err != nil {
+			return height - from, err
+		}
+		if block.IsPruned() {
+			return height - from, fmt.Errorf("Block at height %d is pruned", height)
+		}
+
+		err = writeBlockRecord(w, bc.Params.NetworkMagic, block.Serialize())
+		if err != nil {
+			return height - from, err
+		}
+
+		if (height-from+1)%reindexProgressInterval == 0 || height == to {
+			fmt.Printf("Exported %d of %d blocks\n", height-from+1, to-from+1)
+		}
+	}
+
+	return to - from + 1, nil
+}
+
+// ImportChain validates and connects the blocks of a block file. Blocks already on the main
+// chain are skipped, so an interrupted import resumes where it stopped when it runs again.
+// It returns the numbers of imported and skipped blocks.
+func (bc *Blockchain) ImportChain(r io.Reader) (int, int, error) {
+	imported, skipped := 0, 0
+
+	for {
+		block, err := readBlockRecord(r, bc.Params.NetworkMagic)
+		if err == io.EOF {
+			return imported, skipped, nil
+		}
+		if err != nil {
+			return imported, skipped, err
+		}
+
+		if hash, err := bc.GetBlockHash(block.Height); err == nil && bytes.Compare(hash, block.Hash) == 0 {
+			skipped++
+			continue
+		}
+
+		err = bc.ValidateBlock(block)
+		if err == nil {
+			err = bc.addBlock(block)
+		}
+		if err != nil {
+			return imported, skipped, fmt.Errorf("Block %x at height %d: %s", block.Hash, block.Height, err)
+		}
+
+		imported++
+		if imported%reindexProgressInterval == 0 {
+			fmt.Printf("Imported %d blocks, at height %d\n", imported, block.Height)
+		}
+	}
+}
+
+// ImportGenesis creates a blockchain in the empty store from the genesis block starting the block file
+func ImportGenesis(store ChainStore, r io.Reader, params *ChainParams) (*Blockchain, error) {
+	genesis, err := readBlockRecord(r, params.NetworkMagic)
+	if err != nil {
+		return nil, err
+	}
+
+	if genesis.Height != 0 || len(genesis.PrevBlockHash) != 0 {
+		return nil, errors.New("Block file does not start at the genesis block")
+	}
+	if params.GenesisBlock != "" && bytes.Compare(genesis.Hash, params.genesis("").Hash) != 0 {
+		return nil, errors.New("Block file genesis block does not match the network " + params.Name)
+	}
+	if params.Consensus != ConsensusPoA {
+		err = NewPoWEngine(params).VerifySeal(genesis)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return createBlockchainWithGenesis(store, genesis, params), nil
+}
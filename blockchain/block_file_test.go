@@ -0,0 +1,117 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// exportRecords returns the block file records of the main chain blocks, one per height
+func exportRecords(t *testing.T, bc *Blockchain) [][]byte {
+	t.Helper()
+
+	var records [][]byte
+	for height := 0; height <= bc.GetBestHeight(); height++ {
+		var buff bytes.Buffer
+		_, err := bc.ExportChain(&buff, height, height)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, buff.Bytes())
+	}
+
+	return records
+}
+
+func newTestExportChain(t *testing.T) *Blockchain {
+	t.Helper()
+
+	bc, wallet := newTestBlockchain(t)
+	mineBlocks(bc, wallet, 1)
+	genesis, _ := bc.GetBlockByHeight(0)
+	bc.AddBlock(newTestBlock(bc, wallet, spendTX(bc, wallet, genesis.Transactions[0].ID, []int{0}, 4, 6)))
+	mineBlocks(bc, wallet, 1)
+
+	return bc
+}
+
+func TestExportImportChain(t *testing.T) {
+	source := newTestExportChain(t)
+
+	var file bytes.Buffer
+	count, err := source.ExportChain(&file, 0, -1)
+	if err != nil || count != 4 {
+		t.Fatalf("Exported %d blocks: %v", count, err)
+	}
+	data := file.Bytes()
+
+	r := bytes.NewReader(data)
+	bc, err := ImportGenesis(NewMemoryStore(), r, source.Params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported, skipped, err := bc.ImportChain(r)
+	if err != nil || imported != 3 || skipped != 0 {
+		t.Fatalf("Imported %d, skipped %d blocks: %v", imported, skipped, err)
+	}
+
+	if bytes.Compare(bc.tipHash(), source.tipHash()) != 0 {
+		t.Error("Imported chain has another tip")
+	}
+	if !bytes.Equal(bc.GetUTXOSetInfo().Hash, source.GetUTXOSetInfo().Hash) {
+		t.Error("Imported chain has another UTXO set")
+	}
+
+	// Blocks already in the chain are skipped when the file is imported again
+	imported, skipped, err = bc.ImportChain(bytes.NewReader(data))
+	if err != nil || imported != 0 || skipped != 4 {
+		t.Errorf("Imported %d, skipped %d blocks again: %v", imported, skipped, err)
+	}
+}
+
+func TestImportChainCorrupted(t *testing.T) {
+	source := newTestExportChain(t)
+	records := exportRecords(t, source)
+	modified := func(change func(record []byte)) []byte {
+		record := append([]byte{}, records[2]...)
+		change(record)
+		return record
+	}
+
+	tests := []struct {
+		name   string
+		record []byte
+	}{
+		{"truncated", records[2][:len(records[2])-1]},
+		{"other network", modified(func(record []byte) { record[0] ^= 0xff })},
+		{"over the limit", modified(func(record []byte) {
+			binary.BigEndian.PutUint32(record[4:], maxBlockRecordLen+1)
+		})},
+		{"corrupted block", modified(func(record []byte) { record[len(record)/2] ^= 0xff })},
+		{"missing block", records[3]},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file := bytes.Join([][]byte{records[0], records[1], test.record, records[3]}, nil)
+
+			r := bytes.NewReader(file)
+			bc, err := ImportGenesis(NewMemoryStore(), r, source.Params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			imported, _, err := bc.ImportChain(r)
+			if err == nil {
+				t.Fatal("Corrupted file is imported")
+			}
+
+			// Blocks before the corruption stay imported
+			if imported != 1 || bc.GetBestHeight() != 1 {
+				t.Errorf("Imported %d blocks to height %d, expected 1", imported, bc.GetBestHeight())
+			}
+			if err := bc.VerifyChain(0, VerifyUTXOSet); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
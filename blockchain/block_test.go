@@ -0,0 +1,38 @@
+package blockchain
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDeserializeBlock(t *testing.T) {
+	bc, _ := newTestBlockchain(t)
+	genesis, err := bc.GetBlockByHeight(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := genesis.Serialize()
+
+	tests := []struct {
+		name  string
+		data  []byte
+		valid bool
+	}{
+		{"block", data, true},
+		{"empty", nil, false},
+		{"truncated", data[:len(data)/2], false},
+		{"garbage", bytes.Repeat([]byte{0xff}, 64), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			block, err := DeserializeBlock(test.data)
+			if (err == nil) != test.valid {
+				t.Fatalf("Error %v, expected valid: %t", err, test.valid)
+			}
+			if test.valid && !bytes.Equal(block.Hash, genesis.Hash) {
+				t.Error("Deserialized block differs")
+			}
+		})
+	}
+}
@@ -8,15 +8,13 @@ import (
 	"fmt"
 	"log"
 	"os"
-
-	"github.com/boltdb/bolt"
+	"sync"
 )
 
-const (
-	blocksBucket        = "blocks"
-	genesisCoinbaseData = "The Times 03/Jan/2009 Chancellor on brink of second bailout for banks"
-	dbFile              = "blockchain_%s.db"
-)
+const blocksBucket = "blocks"
+
+// errStaleBlock is returned for a mined block whose parent is no longer the tip
+var errStaleBlock = errors.New("Tip moved while the block was mined")
 
 var (
 	lastHashKey      = []byte("l")
@@ -25,89 +23,106 @@ var (
 
 // Blockchain keeps sequence of blocks
 type Blockchain struct {
-	DB *bolt.DB
+	Params *ChainParams
+
+	store     ChainStore
+	utxoCache *UTXOCache
 
-	tip []byte
+	// pruneTarget is the size in bytes block bodies are pruned to, 0 keeps every block
+	pruneTarget int
+
+	// tipLock guards tip, which is read by the peers, the RPC server and the miner
+	tipLock sync.RWMutex
+	tip     []byte
+	signer  *Wallet
+
+	// chainLock serializes the store transactions that move the tip or flush the UTXO cache
+	chainLock sync.Mutex
+
+	// validatorSets caches the proof of authority validator set after a block by its hash
+	validatorSetsLock sync.Mutex
+	validatorSets     map[string]*validatorSet
 }
 
-// MineBlock mines a block with transactions
-func (bc *Blockchain) MineBlock(transactions []*Transaction) *Block {
+// MineBlock mines a block with transactions on top of the tip. The block is dropped
+// with errStaleBlock when the tip moves while it is sealed.
+func (bc *Blockchain) MineBlock(transactions []*Transaction) (*Block, error) {
 	var lastHash []byte
 	var lastHeight int
 
+	spent := make(map[string]bool)
 	for _, tx := range transactions {
-		if !bc.VerifyTransaction(tx) {
-			log.Panic("ERROR: Invalid transaction")
+		if tx.IsCoinbase() {
+			continue
+		}
+
+		err := bc.checkTransaction(tx, spent)
+		if err != nil {
+			return nil, err
 		}
 	}
 
-	err := bc.DB.View(func(tx *bolt.Tx) error {
+	err := bc.store.View(func(tx StoreTx) error {
 		b := tx.Bucket([]byte(blocksBucket))
 		lastHash = b.Get(lastHashKey)
 
 		blockData := b.Get(lastHash)
-		block := DeserializeBlock(blockData)
+		block, err := DeserializeBlock(blockData)
+		if err != nil {
+			return err
+		}
 
 		lastHeight = block.Height
 
 		return nil
 	})
+	if err != nil {
+		return nil, err
+	}
 
+	bc.commitUTXOSet(transactions)
+	newBlock, err := NewBlock(transactions, lastHash, lastHeight+1, bc.Engine())
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
 
-	newBlock := NewBlock(transactions, lastHash, lastHeight+1)
+	err = bc.addMinedBlock(newBlock)
+	if err != nil {
+		return nil, err
+	}
+
+	return newBlock, nil
+}
 
-	err = bc.DB.Update(func(tx *bolt.Tx) error {
+// addMinedBlock stores a mined block as the new tip, errStaleBlock when its parent is no longer the tip
+func (bc *Blockchain) addMinedBlock(block *Block) error {
+	return bc.updateChain(func(tx StoreTx) (*tipUpdate, error) {
 		b := tx.Bucket([]byte(blocksBucket))
-		err = b.Put(lastHashKey, newBlock.Hash)
-		if err != nil {
-			log.Panic(err)
+		if bytes.Compare(b.Get(lastHashKey), block.PrevBlockHash) != 0 {
+			return nil, errStaleBlock
 		}
 
-		err = b.Put(newBlock.Hash, newBlock.Serialize())
+		err := b.Put(block.Hash, block.Serialize())
 		if err != nil {
-			log.Panic(err)
+			return nil, err
 		}
 
-		bc.tip = newBlock.Hash
-
-		return err
+		return bc.setTip(tx, block)
 	})
-
-	if err != nil {
-		log.Panic(err)
-	}
-
-	return newBlock
 }
 
 // Iterator initializes a new blockchain iterator
 func (bc *Blockchain) Iterator() *Iterator {
-	bci := &Iterator{bc.tip, bc.DB}
+	bci := &Iterator{bc.tipHash(), bc.store}
 
 	return bci
 }
 
 // FindTransaction finds a transaction by its id in the blockchain
 func (bc *Blockchain) FindTransaction(ID []byte) (Transaction, error) {
-	bci := bc.Iterator()
+	tx, _, err := bc.GetTransaction(ID)
 
-	for {
-		block := bci.Next()
-
-		for _, tx := range block.Transactions {
-			if bytes.Compare(tx.ID, ID) == 0 {
-				return *tx, nil
-			}
-		}
-
-		if len(block.PrevBlockHash) == 0 {
-			break
-		}
-	}
-	return Transaction{}, errors.New("Transaction is not found")
+	return tx, err
 }
 
 // SignTransaction signs a transaction with wallet private key
@@ -125,6 +140,19 @@ func (bc *Blockchain) VerifyTransaction(tx *Transaction) bool {
 		return true
 	}
 
+	if tx.IsGovernance() {
+		if bc.Params.Consensus != ConsensusPoA {
+			return false
+		}
+
+		set, err := bc.validatorSetAt(bc.tipHash())
+		if err != nil {
+			return false
+		}
+
+		return tx.verifyGovernance(set)
+	}
+
 	prevTxs := bc.getPreviousTransactions(tx)
 
 	return tx.Verify(prevTxs)
@@ -140,6 +168,11 @@ func (bc *Blockchain) FindUnspentTransactions(pubKeyHash []byte) []Transaction {
 		block := bci.Next()
 
 		for _, tx := range block.Transactions {
+			// Governance transactions hold no value
+			if tx.IsGovernance() {
+				continue
+			}
+
 			txID := hex.EncodeToString(tx.ID)
 
 		Outputs:
@@ -187,6 +220,11 @@ func (bc *Blockchain) FindUTXO() map[string]TXOutputs {
 		block := bci.Next()
 
 		for _, tx := range block.Transactions {
+			// Governance transactions hold no value
+			if tx.IsGovernance() {
+				continue
+			}
+
 			txID := hex.EncodeToString(tx.ID)
 
 		Outputs:
@@ -220,16 +258,36 @@ func (bc *Blockchain) FindUTXO() map[string]TXOutputs {
 	return utxos
 }
 
+// tipHash returns the hash of the tip of the main chain
+func (bc *Blockchain) tipHash() []byte {
+	bc.tipLock.RLock()
+	defer bc.tipLock.RUnlock()
+
+	return bc.tip
+}
+
+// setTipHash moves the tip of the main chain, once the store transaction moving it commits
+func (bc *Blockchain) setTipHash(hash []byte) {
+	bc.tipLock.Lock()
+	defer bc.tipLock.Unlock()
+
+	bc.tip = hash
+}
+
 // GetBestHeight returns the height of blockchain
 func (bc *Blockchain) GetBestHeight() int {
 	var lastBlock Block
 
-	err := bc.DB.View(func(tx *bolt.Tx) error {
+	err := bc.store.View(func(tx StoreTx) error {
 		b := tx.Bucket(blocksBucketName)
 
 		lastHash := b.Get(lastHashKey)
 		blockData := b.Get(lastHash)
-		lastBlock = *DeserializeBlock(blockData)
+		block, err := DeserializeBlock(blockData)
+		if err != nil {
+			return err
+		}
+		lastBlock = *block
 
 		return nil
 	})
@@ -263,7 +321,7 @@ func (bc *Blockchain) GetBlock(blockHash []byte) (Block, error) {
 	var block Block
 
 	// Get block from db
-	err := bc.DB.View(func(tx *bolt.Tx) error {
+	err := bc.store.View(func(tx StoreTx) error {
 		b := tx.Bucket(blocksBucketName)
 
 		blockData := b.Get(blockHash)
@@ -272,7 +330,11 @@ func (bc *Blockchain) GetBlock(blockHash []byte) (Block, error) {
 			return errors.New("Block is not found")
 		}
 
-		block = *DeserializeBlock(blockData)
+		stored, err := DeserializeBlock(blockData)
+		if err != nil {
+			return err
+		}
+		block = *stored
 
 		return nil
 	})
@@ -285,37 +347,267 @@ func (bc *Blockchain) GetBlock(blockHash []byte) (Block, error) {
 
 // AddBlock saves the block into the blockchain
 func (bc *Blockchain) AddBlock(block *Block) {
-	err := bc.DB.Update(func(tx *bolt.Tx) error {
+	err := bc.addBlock(block)
+	// A block that can not become the tip is rolled back with the partial reorganization
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// addBlock stores the block and makes it the tip when it extends the main chain
+func (bc *Blockchain) addBlock(block *Block) error {
+	return bc.updateChain(func(tx StoreTx) (*tipUpdate, error) {
 		b := tx.Bucket(blocksBucketName)
 		blockInDb := b.Get(block.Hash)
 
 		if blockInDb != nil {
-			return nil
+			stored, err := DeserializeBlock(blockInDb)
+			if err != nil {
+				return nil, err
+			}
+			if stored.IsPruned() {
+				return nil, bc.storeSnapshotBody(b, stored, block)
+			}
+			// Blocks stored before their ancestors connect when they arrive again
+		} else {
+			blockData := block.Serialize()
+			err := b.Put(block.Hash, blockData)
+			if err != nil {
+				log.Panic(err)
+			}
 		}
 
-		blockData := block.Serialize()
-		err := b.Put(block.Hash, blockData)
+		lastHash := b.Get(lastHashKey)
+		lastBlockData := b.Get(lastHash)
+		lastBlock, err := DeserializeBlock(lastBlockData)
 		if err != nil {
-			log.Panic(err)
+			return nil, err
 		}
 
-		lastHash := b.Get(lastHashKey)
-		lastBlockData := b.Get(lastHash)
-		lastBlock := DeserializeBlock(lastBlockData)
+		// Blocks whose ancestors are not stored yet stay off the main chain
+		if block.Height > lastBlock.Height && b.Get(block.PrevBlockHash) != nil {
+			return bc.setTip(tx, block)
+		}
+
+		return nil, nil
+	})
+}
+
+// ValidateBlock checks that the block is a valid extension of the current tip
+func (bc *Blockchain) ValidateBlock(block *Block) error {
+	lastBlock, err := bc.GetBlock(bc.tipHash())
+	if err != nil {
+		return err
+	}
+
+	err = checkBlockBody(block)
+	if err != nil {
+		return err
+	}
+
+	if bytes.Compare(block.PrevBlockHash, lastBlock.Hash) != 0 {
+		return errors.New("Block does not extend the tip")
+	}
+
+	if block.Height != lastBlock.Height+1 {
+		return fmt.Errorf("Block height %d, expected %d", block.Height, lastBlock.Height+1)
+	}
+
+	err = bc.Engine().VerifySeal(block)
+	if err != nil {
+		return err
+	}
+
+	return bc.store.View(func(tx StoreTx) error {
+		state, err := bc.utxoSetState(tx)
+		if err != nil {
+			return err
+		}
+
+		return bc.checkBlockTransactions(tx, &hashedUTXOStore{bc.utxoStore(tx, false), state}, block)
+	})
+}
+
+// CheckBlock checks a block received from a peer before it is stored. A block extending
+// the tip is validated against the chainstate, a block of another branch by its link to
+// its parent, its seal and its transaction IDs. Its transactions are checked once its
+// branch connects.
+func (bc *Blockchain) CheckBlock(block *Block) error {
+	err := checkBlockBody(block)
+	if err != nil {
+		return err
+	}
+
+	if bc.isInvalidBlock(block.Hash) || bc.isInvalidBlock(block.PrevBlockHash) {
+		return fmt.Errorf("Block %x is on an invalid branch", block.Hash)
+	}
+
+	if bytes.Compare(block.PrevBlockHash, bc.tipHash()) == 0 {
+		return bc.ValidateBlock(block)
+	}
+
+	parent, err := bc.GetBlock(block.PrevBlockHash)
+	if err != nil {
+		return fmt.Errorf("Block %x has unknown parent %x", block.Hash, block.PrevBlockHash)
+	}
+
+	if block.Height != parent.Height+1 {
+		return fmt.Errorf("Block height %d, expected %d", block.Height, parent.Height+1)
+	}
 
-		if block.Height > lastBlock.Height {
-			err = b.Put(lastHashKey, block.Hash)
+	if reason := bc.verifyBlockSeal(block); reason != "" {
+		return errors.New(reason)
+	}
+
+	return nil
+}
+
+// checkBlockBody checks that the block carries its transactions, which its seal commits to
+func checkBlockBody(block *Block) error {
+	if block.IsPruned() {
+		return errors.New("Block is marked pruned")
+	}
+
+	if len(block.Transactions) == 0 {
+		return errors.New("Block has no transactions")
+	}
+
+	return nil
+}
+
+// checkBlockTransactions checks the transactions of a block against the unspent outputs
+// before it: a single coinbase committing to the UTXO set and paying no more than the
+// subsidy, then every other transaction with checkTransactionIn.
+func (bc *Blockchain) checkBlockTransactions(tx StoreTx, utxos *hashedUTXOStore, block *Block) error {
+	coinbases := 0
+	spent := make(map[string]bool)
+	for _, t := range block.Transactions {
+		if t.IsCoinbase() {
+			if bytes.Compare(t.ID, t.unsignedHash()) != 0 {
+				return fmt.Errorf("Transaction %x has invalid ID", t.ID)
+			}
+
+			coinbases++
+
+			err := bc.verifyUTXOCommitment(t, utxos.state)
 			if err != nil {
-				log.Panic(err)
+				return err
 			}
-			bc.tip = block.Hash
+
+			value := 0
+			for _, out := range t.Vout {
+				value += out.Value
+			}
+			reward := bc.Params.BlockSubsidy(block.Height)
+			if value > reward {
+				return fmt.Errorf("Coinbase pays %d, more than subsidy %d", value, reward)
+			}
+			continue
 		}
 
-		return nil
+		err := bc.checkTransactionIn(tx, utxos, block.PrevBlockHash, t, spent)
+		if err != nil {
+			return err
+		}
+	}
+
+	if coinbases != 1 {
+		return fmt.Errorf("Block has %d coinbase transactions, expected 1", coinbases)
+	}
+
+	return nil
+}
+
+// checkTransaction checks a transaction for a block extending the tip, see checkTransactionIn
+func (bc *Blockchain) checkTransaction(t *Transaction, spent map[string]bool) error {
+	return bc.store.View(func(tx StoreTx) error {
+		return bc.checkTransactionIn(tx, bc.utxoStore(tx, false), bc.tipHash(), t, spent)
 	})
-	if err != nil {
-		log.Panic(err)
+}
+
+// checkTransactionIn checks a transaction of a block on top of the parent, whose unspent
+// outputs are utxos: its ID, that it spends unspent outputs no transaction before it in
+// the block spent, that it pays no more than its inputs and that the owners of the outputs
+// signed it. The outputs it spends are added to spent.
+func (bc *Blockchain) checkTransactionIn(tx StoreTx, utxos UTXOStore, parent []byte, t *Transaction, spent map[string]bool) error {
+	if bytes.Compare(t.ID, t.unsignedHash()) != 0 {
+		return fmt.Errorf("Transaction %x has invalid ID", t.ID)
+	}
+
+	if t.IsGovernance() {
+		if bc.Params.Consensus != ConsensusPoA {
+			return fmt.Errorf("Governance transaction %x is only valid under proof of authority", t.ID)
+		}
+
+		set, err := bc.validatorSetIn(tx, parent)
+		if err != nil {
+			return err
+		}
+
+		// Governance transactions spend a shared governance outpoint, a block applies one of
+		// them since the next one needs the nonce after it
+		key := string(outpointKey(nil, governanceVout))
+		if spent[key] || !t.verifyGovernance(set) {
+			return fmt.Errorf("Governance transaction %x is not valid", t.ID)
+		}
+
+		spent[key] = true
+		return nil
+	}
+
+	inputs := 0
+	spends := make(map[string]bool)
+	prevTxs := make(map[string]Transaction)
+	for _, in := range t.Vin {
+		key := string(outpointKey(in.TxID, in.Vout))
+		if spent[key] || spends[key] {
+			return fmt.Errorf("Transaction %x spends output %x:%d spent in the block", t.ID, in.TxID, in.Vout)
+		}
+
+		entry, ok := utxos.Get(in.TxID, in.Vout)
+		if !ok {
+			return fmt.Errorf("Transaction %x spends unknown or spent output %x:%d", t.ID, in.TxID, in.Vout)
+		}
+
+		if !in.UseKey(entry.PubKeyHash) {
+			return fmt.Errorf("Transaction %x spends output %x:%d with another key", t.ID, in.TxID, in.Vout)
+		}
+
+		// Signatures only cover the locking script of the spent output, which the entry holds
+		txID := hex.EncodeToString(in.TxID)
+		prevTx := prevTxs[txID]
+		prevTx.ID = in.TxID
+		for len(prevTx.Vout) <= in.Vout {
+			prevTx.Vout = append(prevTx.Vout, TXOutput{})
+		}
+		prevTx.Vout[in.Vout] = entry.Output()
+		prevTxs[txID] = prevTx
+
+		spends[key] = true
+		inputs += entry.Value
+	}
+
+	outputs := 0
+	for _, out := range t.Vout {
+		if out.Value < 0 {
+			return fmt.Errorf("Transaction %x has a negative output", t.ID)
+		}
+		outputs += out.Value
+	}
+
+	if outputs > inputs {
+		return fmt.Errorf("Transaction %x pays %d, more than its inputs %d", t.ID, outputs, inputs)
+	}
+
+	if !t.Verify(prevTxs) {
+		return fmt.Errorf("Transaction %x has invalid signature", t.ID)
 	}
+
+	for key := range spends {
+		spent[key] = true
+	}
+
+	return nil
 }
 
 func dbExists(dbFile string) bool {
@@ -326,74 +618,139 @@ func dbExists(dbFile string) bool {
 	return true
 }
 
-// NewBlockchain creates and returns a blockchain
-func NewBlockchain(nodeID string) *Blockchain {
+// NewBlockchain opens and returns the blockchain of the node
+func NewBlockchain(nodeID string, params *ChainParams) *Blockchain {
 	// Use unique db for different ndoes
-	dbFile := fmt.Sprintf(dbFile, nodeID)
+	dbFile := params.dbFile(nodeID)
 	if dbExists(dbFile) == false {
 		fmt.Println("No existing blockchain found. Create one first.")
 		os.Exit(1)
 	}
 
-	var tip []byte
-	db, err := bolt.Open(dbFile, 0600, nil)
+	store, err := OpenBoltStore(dbFile)
 	if err != nil {
 		log.Panic(err)
 	}
 
-	err = db.Update(func(tx *bolt.Tx) error {
+	return NewBlockchainWithStore(store, params)
+}
+
+// NewBlockchainWithStore returns the blockchain kept in the store
+func NewBlockchainWithStore(store ChainStore, params *ChainParams) *Blockchain {
+	var tip []byte
+
+	err := store.Update(func(tx StoreTx) error {
 		b := tx.Bucket([]byte(blocksBucket))
-		tip = b.Get([]byte("l"))
+		if b == nil {
+			return errors.New("No existing blockchain found in the store")
+		}
 
-		return nil
+		// Copy the tip, store memory is only valid within the transaction
+		tip = append([]byte{}, b.Get(lastHashKey)...)
+
+		return openMetadata(tx, params)
 	})
+	if err != nil {
+		log.Panic(err)
+	}
 
 	bc := Blockchain{
-		DB:  db,
-		tip: tip,
+		Params:    params,
+		store:     store,
+		utxoCache: NewUTXOCache(DefaultUTXOCacheSize),
+		tip:       tip,
 	}
+
+	// The chainstate is behind the tip when a node stopped with unflushed UTXO changes,
+	// it catches up from its marker. Databases predating the marker are reindexed.
+	if best := bc.chainstateBestBlock(); bytes.Compare(best, tip) != 0 && !(UTXOSet{&bc}).catchUp(best) {
+		log.Println("Chainstate does not match the tip, reindexing")
+		UTXOSet{&bc}.Reindex()
+	}
+
 	return &bc
 }
 
-// CreateBlockchain creates and returns a blockchain
-func CreateBlockchain(address, nodeID string) *Blockchain {
-	dbFile := fmt.Sprintf(dbFile, nodeID)
-	if dbExists(dbFile) == false {
-		fmt.Println("No existing blockchain found. Create one first.")
+// CreateBlockchain creates and returns the blockchain of the node
+func CreateBlockchain(address, nodeID string, params *ChainParams) *Blockchain {
+	dbFile := params.dbFile(nodeID)
+	if dbExists(dbFile) {
+		fmt.Println("Blockchain already exists.")
 		os.Exit(1)
 	}
 
-	var tip []byte
-	db, err := bolt.Open(dbFile, 0600, nil)
+	store, err := OpenBoltStore(dbFile)
+	if err != nil {
+		log.Panic(err)
+	}
 
-	err = db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(blocksBucket))
+	return CreateBlockchainWithStore(store, address, params)
+}
 
-		if b == nil {
-			coinbaseTX := NewCoinbaseTX(address, genesisCoinbaseData)
-			genesis := NewGenesisBlock(coinbaseTX)
-			b, err = tx.CreateBucket([]byte(blocksBucket))
-			err = b.Put(genesis.Hash, genesis.Serialize())
-			err = b.Put(lastHashKey, genesis.Hash)
-			tip = genesis.Hash
-		} else {
-			tip = b.Get(lastHashKey)
-		}
-		return err
-	})
+// CreateBlockchainWithStore creates a blockchain in the empty store
+func CreateBlockchainWithStore(store ChainStore, address string, params *ChainParams) *Blockchain {
+	return createBlockchainWithGenesis(store, params.genesis(address), params)
+}
 
+// createBlockchainWithGenesis creates a blockchain starting at the genesis block in the empty store
+func createBlockchainWithGenesis(store ChainStore, genesis *Block, params *ChainParams) *Blockchain {
 	bc := Blockchain{
-		DB:  db,
-		tip: tip,
+		Params:    params,
+		store:     store,
+		utxoCache: NewUTXOCache(DefaultUTXOCacheSize),
 	}
+
+	err := bc.updateChain(func(tx StoreTx) (*tipUpdate, error) {
+		err := writeMetadata(tx, params)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = tx.CreateBucket(heightIndexBucketName)
+		if err != nil {
+			return nil, err
+		}
+
+		err = createChainstate(tx)
+		if err != nil {
+			return nil, err
+		}
+
+		b, err := tx.CreateBucket([]byte(blocksBucket))
+		if err != nil {
+			return nil, err
+		}
+
+		err = b.Put(genesis.Hash, genesis.Serialize())
+		if err != nil {
+			return nil, err
+		}
+
+		return bc.setTip(tx, genesis)
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
 	return &bc
 }
 
+// Close flushes the UTXO cache and closes the store of the blockchain
+func (bc *Blockchain) Close() {
+	bc.FlushUTXOCache()
+
+	err := bc.store.Close()
+	logPanicErr(err)
+}
+
 func (bc *Blockchain) getPreviousTransactions(tx *Transaction) map[string]Transaction {
 	prevTxs := make(map[string]Transaction)
 
 	for _, in := range tx.Vin {
 		prevTx, err := bc.FindTransaction(in.TxID)
+		if err != nil {
+			prevTx, err = bc.prunedTransaction(prevTxs[hex.EncodeToString(in.TxID)], in.TxID, in.Vout)
+		}
 		if err != nil {
 			log.Panic(err)
 		}
@@ -0,0 +1,334 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// invalidBlocksBucket holds the blocks that failed validation as their branch connected
+const invalidBlocksBucket = "invalid"
+
+var invalidBlocksBucketName = []byte(invalidBlocksBucket)
+
+// invalidBlockError is returned when a block fails validation as its branch connects.
+// branch holds the block and the blocks on top of it that were to connect.
+type invalidBlockError struct {
+	branch [][]byte
+	err    error
+}
+
+func (e *invalidBlockError) Error() string {
+	return fmt.Sprintf("Block %x is invalid: %s", e.branch[0], e.err)
+}
+
+// invalidBranch returns the error for the attached block at i and the blocks after it
+func invalidBranch(attach []*Block, i int, err error) *invalidBlockError {
+	var branch [][]byte
+	for ; i >= 0; i-- {
+		branch = append(branch, attach[i].Hash)
+	}
+
+	return &invalidBlockError{branch, err}
+}
+
+// tipUpdate is the in-memory state of a new tip: the tip hash and, with the UTXO cache,
+// the changes of the connected blocks. It is applied once the store transaction commits.
+// A reorganization writes to the chainstate and resets the cache instead.
+type tipUpdate struct {
+	tip        []byte
+	view       *cachedUTXOStore
+	state      *utxoSetState
+	resetCache bool
+}
+
+// updateChain runs fn in a store transaction and applies the tip update it returns once
+// the transaction commits, so a failed transaction leaves the blockchain as it was.
+// Chain updates are serialized, no transaction reads the cache before it is updated.
+func (bc *Blockchain) updateChain(fn func(tx StoreTx) (*tipUpdate, error)) error {
+	bc.chainLock.Lock()
+	defer bc.chainLock.Unlock()
+
+	var update *tipUpdate
+	err := bc.store.Update(func(tx StoreTx) error {
+		var err error
+		update, err = fn(tx)
+		return err
+	})
+	if invalid, ok := err.(*invalidBlockError); ok {
+		bc.markInvalidBlocks(invalid.branch)
+	}
+	if err != nil || update == nil {
+		return err
+	}
+
+	bc.setTipHash(update.tip)
+	if update.resetCache {
+		bc.utxoCache.reset()
+	}
+	if update.view == nil {
+		return nil
+	}
+
+	cache := update.view.cache
+	cache.commit(update.view, update.tip, update.state)
+	if cache.flushNeeded() {
+		err = bc.flushUTXOCache(cache)
+		if err != nil {
+			return err
+		}
+		log.Println(cache.Stats())
+	}
+
+	return nil
+}
+
+// setTip makes the stored block the tip of the main chain. Blocks of the old
+// branch down to the fork point are disconnected, then the blocks of the new
+// branch are connected in height order. The returned update is applied by updateChain.
+func (bc *Blockchain) setTip(tx StoreTx, newTip *Block) (*tipUpdate, error) {
+	var detach []*Block
+	var attach []*Block
+
+	b := tx.Bucket(blocksBucketName)
+
+	var oldBlock *Block
+	var err error
+	if lastHash := b.Get(lastHashKey); lastHash != nil {
+		oldBlock, err = DeserializeBlock(b.Get(lastHash))
+		if err != nil {
+			return nil, err
+		}
+	}
+	hasTip := oldBlock != nil
+	newBlock := newTip
+
+	for err == nil && oldBlock != nil && oldBlock.Height > newBlock.Height {
+		detach = append(detach, oldBlock)
+		oldBlock, err = parentBlock(b, oldBlock)
+	}
+
+	for err == nil && newBlock != nil && (oldBlock == nil || newBlock.Height > oldBlock.Height) {
+		attach = append(attach, newBlock)
+		newBlock, err = parentBlock(b, newBlock)
+	}
+
+	for err == nil && oldBlock != nil && newBlock != nil && bytes.Compare(oldBlock.Hash, newBlock.Hash) != 0 {
+		detach = append(detach, oldBlock)
+		attach = append(attach, newBlock)
+		oldBlock, err = parentBlock(b, oldBlock)
+		if err == nil {
+			newBlock, err = parentBlock(b, newBlock)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if hasTip && oldBlock == nil && newBlock == nil {
+		return nil, errors.New("Block has no common ancestor with the main chain")
+	}
+
+	if hasTip && (oldBlock == nil || newBlock == nil) {
+		return nil, fmt.Errorf("Block %x is missing ancestors", newTip.Hash)
+	}
+
+	// The best block marker must stay on the main chain for a node stopping with unflushed
+	// changes to catch up, and the undo data of disconnected blocks is deleted. A reorganization
+	// writes the unflushed changes of the cache and its own changes to the chainstate.
+	reorg := len(detach) != 0 && bc.utxoCache != nil
+	if reorg {
+		err = bc.utxoCache.write(tx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	state, err := bc.utxoSetState(tx)
+	if err != nil {
+		return nil, err
+	}
+	utxos := &hashedUTXOStore{bc.utxoStore(tx, true), state}
+	if reorg {
+		utxos.UTXOStore = chainstate(tx)
+	}
+
+	for _, block := range detach {
+		err := bc.disconnectBlock(tx, utxos, block)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Blocks of other branches were only checked for their seal when they were stored. Each
+	// one is validated against the chainstate it connects to, a failure aborts the reorganization.
+	for i := len(attach) - 1; i >= 0; i-- {
+		block := attach[i]
+		if len(block.PrevBlockHash) != 0 {
+			if invalid := tx.Bucket(invalidBlocksBucketName); invalid != nil && invalid.Get(block.Hash) != nil {
+				return nil, invalidBranch(attach, i, errors.New("Block was found invalid before"))
+			}
+
+			err := checkBlockBody(block)
+			if err == nil {
+				err = bc.checkBlockTransactions(tx, utxos, block)
+			}
+			if err != nil {
+				return nil, invalidBranch(attach, i, err)
+			}
+		}
+
+		err := bc.connectBlock(tx, utxos, block)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = b.Put(lastHashKey, newTip.Hash)
+	if err != nil {
+		return nil, err
+	}
+	update := &tipUpdate{tip: newTip.Hash, resetCache: reorg}
+
+	// Without the cache and on reorganizations the chainstate is written with the
+	// block, otherwise the marker moves once the changes are flushed
+	view, cached := utxos.UTXOStore.(*cachedUTXOStore)
+	if !cached {
+		info := tx.Bucket(chainstateInfoBucketName)
+		err = info.Put(bestBlockKey, newTip.Hash)
+		if err != nil {
+			return nil, err
+		}
+
+		err = info.Put(utxoSetStateKey, state.Serialize())
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		update.view = view
+		update.state = state
+	}
+
+	return update, bc.pruneBlocks(tx)
+}
+
+// connectBlock updates the chainstate and the indexes for a block joining the main chain
+func (bc *Blockchain) connectBlock(tx StoreTx, utxos UTXOStore, block *Block) error {
+	err := connectBlockUTXOs(tx, utxos, block)
+	if err != nil {
+		return err
+	}
+
+	heightIndex := tx.Bucket(heightIndexBucketName)
+	if heightIndex != nil {
+		err = heightIndex.Put(heightKey(block.Height), block.Hash)
+		if err != nil {
+			return err
+		}
+	}
+
+	addrIndex := tx.Bucket(addrIndexBucketName)
+	if addrIndex != nil {
+		err = indexAddresses(addrIndex, block)
+		if err != nil {
+			return err
+		}
+	}
+
+	txIndex := tx.Bucket(txIndexBucketName)
+	if txIndex != nil {
+		err = indexTransactions(txIndex, block)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// disconnectBlock reverts the chainstate and the indexes of a block leaving the main chain
+func (bc *Blockchain) disconnectBlock(tx StoreTx, utxos UTXOStore, block *Block) error {
+	err := disconnectBlockUTXOs(tx, utxos, block)
+	if err != nil {
+		return err
+	}
+
+	heightIndex := tx.Bucket(heightIndexBucketName)
+	if heightIndex != nil {
+		err = heightIndex.Delete(heightKey(block.Height))
+		if err != nil {
+			return err
+		}
+	}
+
+	addrIndex := tx.Bucket(addrIndexBucketName)
+	if addrIndex != nil {
+		err = unindexAddresses(addrIndex, block)
+		if err != nil {
+			return err
+		}
+	}
+
+	txIndex := tx.Bucket(txIndexBucketName)
+	if txIndex != nil {
+		err = unindexTransactions(txIndex, block)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parentBlock returns the stored parent of the block, nil for the genesis block or a missing parent
+func parentBlock(b StoreBucket, block *Block) (*Block, error) {
+	if len(block.PrevBlockHash) == 0 {
+		return nil, nil
+	}
+
+	blockData := b.Get(block.PrevBlockHash)
+	if blockData == nil {
+		return nil, nil
+	}
+
+	return DeserializeBlock(blockData)
+}
+
+// markInvalidBlocks records blocks that failed validation, so their branch is not connected again
+func (bc *Blockchain) markInvalidBlocks(hashes [][]byte) {
+	err := bc.store.Update(func(tx StoreTx) error {
+		invalid := tx.Bucket(invalidBlocksBucketName)
+		if invalid == nil {
+			var err error
+			invalid, err = tx.CreateBucket(invalidBlocksBucketName)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, hash := range hashes {
+			err := invalid.Put(hash, []byte{1})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	logPanicErr(err)
+}
+
+// isInvalidBlock returns whether the block with the hash failed validation
+func (bc *Blockchain) isInvalidBlock(hash []byte) bool {
+	found := false
+
+	err := bc.store.View(func(tx StoreTx) error {
+		if invalid := tx.Bucket(invalidBlocksBucketName); invalid != nil {
+			found = invalid.Get(hash) != nil
+		}
+		return nil
+	})
+	logPanicErr(err)
+
+	return found
+}
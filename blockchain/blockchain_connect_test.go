@@ -0,0 +1,274 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestUpdateChainRollback(t *testing.T) {
+	tests := []struct {
+		name      string
+		cacheSize int
+	}{
+		{"cache", DefaultUTXOCacheSize},
+		{"no cache", 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bc, wallet := newTestBlockchain(t)
+			bc.SetUTXOCacheSize(test.cacheSize)
+			mineBlocks(bc, wallet, 2)
+
+			tip := bc.tipHash()
+			info := bc.GetUTXOSetInfo()
+			block := newTestBlock(bc, wallet)
+
+			// A transaction failing after the tip moved, like pruning or deleting blocks
+			err := bc.updateChain(func(tx StoreTx) (*tipUpdate, error) {
+				err := tx.Bucket(blocksBucketName).Put(block.Hash, block.Serialize())
+				if err != nil {
+					return nil, err
+				}
+
+				update, err := bc.setTip(tx, block)
+				if err != nil {
+					return nil, err
+				}
+
+				return update, errors.New("failed after the tip moved")
+			})
+			if err == nil {
+				t.Fatal("Chain update did not fail")
+			}
+
+			if bytes.Compare(bc.tipHash(), tip) != 0 || bc.GetBestHeight() != 2 {
+				t.Error("Tip moved")
+			}
+			if _, ok := (UTXOSet{bc}).GetUTXO(block.Transactions[0].ID, 0); ok {
+				t.Error("Coinbase of the failed block is unspent")
+			}
+			if !bytes.Equal(bc.GetUTXOSetInfo().Hash, info.Hash) {
+				t.Error("UTXO set hash changed")
+			}
+
+			// The chain goes on from the old tip
+			bc.AddBlock(block)
+			if bytes.Compare(bc.tipHash(), block.Hash) != 0 {
+				t.Fatal("Block does not connect after the failed update")
+			}
+			if _, ok := (UTXOSet{bc}).GetUTXO(block.Transactions[0].ID, 0); !ok {
+				t.Error("Coinbase of the block is not unspent")
+			}
+		})
+	}
+}
+
+func TestReorganization(t *testing.T) {
+	tests := []struct {
+		name      string
+		cacheSize int
+	}{
+		{"cache", DefaultUTXOCacheSize},
+		{"flush every block", 1},
+		{"no cache", 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bc, wallet := newTestBlockchain(t)
+			bc.SetUTXOCacheSize(test.cacheSize)
+			mineBlocks(bc, wallet, 1)
+
+			fork, _ := bc.GetBlockByHeight(1)
+			coinbase := fork.Transactions[0]
+			value := coinbase.Vout[0].Value
+
+			extend := func(parent *Block, txs ...*Transaction) *Block {
+				txs = append([]*Transaction{newTestCoinbase(bc, wallet)}, txs...)
+				block, err := NewBlock(txs, parent.Hash, parent.Height+1, bc.Engine())
+				logPanicErr(err)
+
+				bc.AddBlock(block)
+				return block
+			}
+
+			// Both branches spend the coinbase of the fork block, paying different values
+			mainSpend := spendTX(bc, wallet, coinbase.ID, []int{0}, value)
+			sideSpend := spendTX(bc, wallet, coinbase.ID, []int{0}, value-1)
+
+			main2 := extend(&fork)
+			main3 := extend(main2, mainSpend)
+			side2 := extend(&fork)
+			side3 := extend(side2, sideSpend)
+			if bytes.Compare(bc.tipHash(), main3.Hash) != 0 {
+				t.Fatal("Branch of the same height became the tip")
+			}
+
+			checkBranch := func(tip *Block, connected, disconnected []*Block, spend, conflict *Transaction) {
+				t.Helper()
+
+				if bytes.Compare(bc.tipHash(), tip.Hash) != 0 || bc.GetBestHeight() != tip.Height {
+					t.Fatalf("Tip is at height %d, expected %d", bc.GetBestHeight(), tip.Height)
+				}
+
+				utxos := UTXOSet{bc}
+				if _, ok := utxos.GetUTXO(spend.ID, 0); !ok {
+					t.Error("Spend of the branch is not unspent")
+				}
+				if _, ok := utxos.GetUTXO(conflict.ID, 0); ok {
+					t.Error("Spend of the other branch is unspent")
+				}
+				if _, ok := utxos.GetUTXO(coinbase.ID, 0); ok {
+					t.Error("Coinbase of the fork block is unspent")
+				}
+
+				err := bc.store.View(func(tx StoreTx) error {
+					undo := tx.Bucket(undoBucketName)
+					for _, block := range connected {
+						hash, _ := bc.GetBlockHash(block.Height)
+						if bytes.Compare(hash, block.Hash) != 0 {
+							t.Errorf("Height %d is not indexed to the branch", block.Height)
+						}
+						if _, ok := utxos.GetUTXO(block.Transactions[0].ID, 0); !ok {
+							t.Errorf("Coinbase at height %d is not unspent", block.Height)
+						}
+						if undo.Get(block.Hash) == nil {
+							t.Errorf("Block at height %d has no undo data", block.Height)
+						}
+					}
+					for _, block := range disconnected {
+						if _, ok := utxos.GetUTXO(block.Transactions[0].ID, 0); ok {
+							t.Errorf("Coinbase of the disconnected block at height %d is unspent", block.Height)
+						}
+						if undo.Get(block.Hash) != nil {
+							t.Errorf("Disconnected block at height %d has undo data", block.Height)
+						}
+					}
+					return nil
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				// The incremental chainstate matches one replayed from the blocks
+				info := bc.GetUTXOSetInfo()
+				utxos.Reindex()
+				if reindexed := bc.GetUTXOSetInfo(); !bytes.Equal(info.Hash, reindexed.Hash) || info.Outputs != reindexed.Outputs {
+					t.Errorf("UTXO set has %d outputs hashing to %x, reindexed %d outputs to %x",
+						info.Outputs, info.Hash, reindexed.Outputs, reindexed.Hash)
+				}
+			}
+
+			side4 := extend(side3)
+			checkBranch(side4, []*Block{side2, side3, side4}, []*Block{main2, main3}, sideSpend, mainSpend)
+
+			main4 := extend(main3)
+			main5 := extend(main4)
+			checkBranch(main5, []*Block{main2, main3, main4, main5}, []*Block{side2, side3, side4}, mainSpend, sideSpend)
+		})
+	}
+}
+
+// TestReorganizationUnflushed stops a node after a reorganization of blocks whose changes
+// were flushed, the chainstate catches up on start from a main chain block
+func TestReorganizationUnflushed(t *testing.T) {
+	bc, wallet := newTestBlockchain(t)
+	mineBlocks(bc, wallet, 2)
+	bc.FlushUTXOCache()
+	fork, _ := bc.GetBlockByHeight(0)
+
+	parent := &fork
+	for height := 1; height <= 3; height++ {
+		block, err := NewBlock([]*Transaction{newTestCoinbase(bc, wallet)}, parent.Hash, height, bc.Engine())
+		logPanicErr(err)
+		bc.AddBlock(block)
+		parent = block
+	}
+	if bytes.Compare(bc.tipHash(), parent.Hash) != 0 {
+		t.Fatal("Longer branch is not the tip")
+	}
+	mineBlocks(bc, wallet, 1)
+
+	if best := bc.chainstateBestBlock(); !bc.IsMainChain(best) {
+		t.Fatalf("Chainstate is at %x, a disconnected block", best)
+	}
+
+	reopened := NewBlockchainWithStore(bc.store, bc.Params)
+	if !bytes.Equal(reopened.GetUTXOSetInfo().Hash, bc.GetUTXOSetInfo().Hash) {
+		t.Error("UTXO set differs after the restart")
+	}
+	if bytes.Compare(reopened.chainstateBestBlock(), bc.tipHash()) != 0 {
+		t.Error("Chainstate did not catch up to the tip")
+	}
+}
+
+func TestReorganizationValidation(t *testing.T) {
+	bc, wallet := newTestBlockchain(t)
+	mineBlocks(bc, wallet, 2)
+	tip := bc.tipHash()
+	info := bc.GetUTXOSetInfo()
+
+	fork, _ := bc.GetBlockByHeight(1)
+	coinbase := fork.Transactions[0]
+	value := coinbase.Vout[0].Value
+	address := string(wallet.GetAddress(bc.Params))
+
+	forged := spendTX(bc, wallet, coinbase.ID, []int{0}, value)
+	forged.Vin[0].Signature[0] ^= 0xff
+
+	unknown := *spendTX(bc, wallet, coinbase.ID, []int{0}, value)
+	unknown.Vin = []TXInput{{[]byte("unknown"), 0, wallet.PublicKey, nil}}
+	unknown.ID = unknown.unsignedHash()
+
+	tests := []struct {
+		name     string
+		coinbase *Transaction
+		txs      []*Transaction
+	}{
+		{"inflated coinbase", NewCoinbaseTX(address, "", bc.Params.BlockSubsidy(2)+1), nil},
+		{"spend signed by another key", nil, []*Transaction{spendTX(bc, NewWallet(), coinbase.ID, []int{0}, value)}},
+		{"forged signature", nil, []*Transaction{forged}},
+		{"overspend", nil, []*Transaction{spendTX(bc, wallet, coinbase.ID, []int{0}, value+1)}},
+		{"unknown output", nil, []*Transaction{&unknown}},
+		{"double spend", nil, []*Transaction{
+			spendTX(bc, wallet, coinbase.ID, []int{0}, value),
+			spendTX(bc, wallet, coinbase.ID, []int{0}, value-1),
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.coinbase == nil {
+				test.coinbase = newTestCoinbase(bc, wallet)
+			}
+			invalid, err := NewBlock(append([]*Transaction{test.coinbase}, test.txs...), fork.Hash, 2, bc.Engine())
+			logPanicErr(err)
+			if bc.CheckBlock(invalid) != nil {
+				t.Fatal("Block of another branch is not stored")
+			}
+			bc.AddBlock(invalid)
+
+			// The block on top makes the branch the heaviest
+			next, err := NewBlock([]*Transaction{newTestCoinbase(bc, wallet)}, invalid.Hash, 3, bc.Engine())
+			logPanicErr(err)
+			if bc.addBlock(next) == nil {
+				t.Fatal("Invalid branch connected")
+			}
+
+			if bytes.Compare(bc.tipHash(), tip) != 0 || !bytes.Equal(bc.GetUTXOSetInfo().Hash, info.Hash) {
+				t.Fatal("Chain moved to the invalid branch")
+			}
+			if !bc.isInvalidBlock(invalid.Hash) || !bc.isInvalidBlock(next.Hash) {
+				t.Error("Branch is not marked invalid")
+			}
+
+			child, err := NewBlock([]*Transaction{newTestCoinbase(bc, wallet)}, next.Hash, 4, bc.Engine())
+			logPanicErr(err)
+			if bc.CheckBlock(child) == nil {
+				t.Error("Block on the invalid branch is valid")
+			}
+		})
+	}
+}
@@ -1,25 +1,24 @@
 package blockchain
 
-import (
-	"github.com/boltdb/bolt"
-)
-
 // Iterator is for iterating though blockchain
 type Iterator struct {
 	currentHash []byte
-	db          *bolt.DB
+	store       ChainStore
 }
 
 // Next returns the next block in blockchain
 func (i *Iterator) Next() *Block {
 	var block *Block
 
-	i.db.View(func(tx *bolt.Tx) error {
+	err := i.store.View(func(tx StoreTx) error {
 		b := tx.Bucket([]byte(blocksBucket))
 		encodedBlock := b.Get(i.currentHash)
-		block = DeserializeBlock(encodedBlock)
-		return nil
+
+		var err error
+		block, err = DeserializeBlock(encodedBlock)
+		return err
 	})
+	logPanicErr(err)
 
 	i.currentHash = block.PrevBlockHash
 
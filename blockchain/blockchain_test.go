@@ -0,0 +1,253 @@
+package blockchain
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// newTestBlockchain creates a regtest blockchain in memory whose genesis block pays to the returned wallet
+func newTestBlockchain(t *testing.T) (*Blockchain, *Wallet) {
+	t.Helper()
+
+	params := RegTestParams
+	wallet := NewWallet()
+
+	return CreateBlockchainWithStore(NewMemoryStore(), string(wallet.GetAddress(&params)), &params), wallet
+}
+
+// mineBlocks mines n blocks holding only a coinbase paying to the wallet
+func mineBlocks(bc *Blockchain, wallet *Wallet, n int) {
+	for i := 0; i < n; i++ {
+		_, err := bc.MineBlock([]*Transaction{newTestCoinbase(bc, wallet)})
+		logPanicErr(err)
+	}
+}
+
+func newTestCoinbase(bc *Blockchain, wallet *Wallet) *Transaction {
+	height := bc.GetBestHeight() + 1
+	return NewCoinbaseTX(string(wallet.GetAddress(bc.Params)), "", bc.Params.BlockSubsidy(height))
+}
+
+// newTestBlock seals a block of the transactions and a coinbase paying to the wallet on top of the tip
+func newTestBlock(bc *Blockchain, wallet *Wallet, txs ...*Transaction) *Block {
+	coinbase := newTestCoinbase(bc, wallet)
+	block, err := NewBlock(append([]*Transaction{coinbase}, txs...), bc.tipHash(), bc.GetBestHeight()+1, bc.Engine())
+	logPanicErr(err)
+
+	return block
+}
+
+// spendTX returns a transaction of the wallet spending the outputs of the transaction with the ID,
+// paying the values back to the wallet
+func spendTX(bc *Blockchain, wallet *Wallet, txID []byte, vouts []int, values ...int) *Transaction {
+	tx := Transaction{}
+	for _, vout := range vouts {
+		tx.Vin = append(tx.Vin, TXInput{txID, vout, wallet.PublicKey, nil})
+	}
+	for _, value := range values {
+		tx.Vout = append(tx.Vout, *NewTXOutput(value, string(wallet.GetAddress(bc.Params))))
+	}
+
+	tx.ID = tx.Hash()
+	bc.SignTransaction(&tx, wallet.PrivateKey)
+
+	return &tx
+}
+
+func TestValidateBlock(t *testing.T) {
+	bc, wallet := newTestBlockchain(t)
+	mineBlocks(bc, wallet, 2)
+	genesis, _ := bc.GetBlockByHeight(0)
+	first, _ := bc.GetBlockByHeight(1)
+	funds := genesis.Transactions[0].ID
+	other := first.Transactions[0].ID
+
+	tests := []struct {
+		name string
+		txs  func() []*Transaction
+		err  string
+	}{
+		{"spend", func() []*Transaction {
+			return []*Transaction{spendTX(bc, wallet, funds, []int{0}, 4, 6)}
+		}, ""},
+		{"fee", func() []*Transaction {
+			return []*Transaction{spendTX(bc, wallet, funds, []int{0}, 9)}
+		}, ""},
+		{"two spends", func() []*Transaction {
+			return []*Transaction{spendTX(bc, wallet, funds, []int{0}, 10), spendTX(bc, wallet, other, []int{0}, 10)}
+		}, ""},
+		{"more than inputs", func() []*Transaction {
+			return []*Transaction{spendTX(bc, wallet, funds, []int{0}, 10, 1)}
+		}, "more than its inputs"},
+		{"negative output", func() []*Transaction {
+			return []*Transaction{spendTX(bc, wallet, funds, []int{0}, 20, -10)}
+		}, "negative output"},
+		{"double spend in block", func() []*Transaction {
+			return []*Transaction{spendTX(bc, wallet, funds, []int{0}, 10), spendTX(bc, wallet, funds, []int{0}, 5)}
+		}, "spent in the block"},
+		{"double spend in transaction", func() []*Transaction {
+			return []*Transaction{spendTX(bc, wallet, funds, []int{0, 0}, 20)}
+		}, "spent in the block"},
+		{"unknown output", func() []*Transaction {
+			tx := spendTX(bc, wallet, funds, []int{0}, 10)
+			tx.Vin[0].Vout = 1
+			tx.ID = tx.unsignedHash()
+			return []*Transaction{tx}
+		}, "unknown or spent output"},
+		{"invalid ID", func() []*Transaction {
+			tx := spendTX(bc, wallet, funds, []int{0}, 10)
+			tx.Vout[0].Value = 5
+			return []*Transaction{tx}
+		}, "invalid ID"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := bc.ValidateBlock(newTestBlock(bc, wallet, test.txs()...))
+			if test.err == "" && err != nil {
+				t.Fatal(err)
+			}
+			if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Fatalf("Error %v, expected %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestValidateBlockSpentOutput(t *testing.T) {
+	bc, wallet := newTestBlockchain(t)
+	genesis, _ := bc.GetBlockByHeight(0)
+
+	spend := spendTX(bc, wallet, genesis.Transactions[0].ID, []int{0}, 10)
+	block := newTestBlock(bc, wallet, spend)
+	if err := bc.ValidateBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	bc.AddBlock(block)
+
+	again := spendTX(bc, wallet, genesis.Transactions[0].ID, []int{0}, 10)
+	if err := bc.ValidateBlock(newTestBlock(bc, wallet, again)); err == nil {
+		t.Error("Block spending a spent output is valid")
+	}
+}
+
+func TestCheckBlock(t *testing.T) {
+	bc, wallet := newTestBlockchain(t)
+	mineBlocks(bc, wallet, 3)
+	first, _ := bc.GetBlockByHeight(1)
+	genesis, _ := bc.GetBlockByHeight(0)
+
+	// sealBlock seals a block of a coinbase on top of the parent, changed before sealing
+	sealBlock := func(parent []byte, height int, change func(*Transaction)) *Block {
+		coinbase := newTestCoinbase(bc, wallet)
+		change(coinbase)
+		block, err := NewBlock([]*Transaction{coinbase}, parent, height, bc.Engine())
+		logPanicErr(err)
+
+		return block
+	}
+	unchanged := func(*Transaction) {}
+
+	tests := []struct {
+		name  string
+		block func() *Block
+		valid bool
+	}{
+		{"extends tip", func() *Block {
+			return newTestBlock(bc, wallet)
+		}, true},
+		{"side branch", func() *Block {
+			return sealBlock(first.Hash, 2, unchanged)
+		}, true},
+		{"spends in side branch", func() *Block {
+			// Side branch blocks are not checked against the chainstate of the tip
+			spend := spendTX(bc, wallet, genesis.Transactions[0].ID, []int{0}, 10)
+			block, err := NewBlock([]*Transaction{newTestCoinbase(bc, wallet), spend}, first.Hash, 2, bc.Engine())
+			logPanicErr(err)
+			return block
+		}, true},
+		{"double spend on tip", func() *Block {
+			spend := spendTX(bc, wallet, genesis.Transactions[0].ID, []int{0}, 10)
+			return newTestBlock(bc, wallet, spend, spend)
+		}, false},
+		{"side branch height", func() *Block {
+			return sealBlock(first.Hash, 5, unchanged)
+		}, false},
+		{"unknown parent", func() *Block {
+			return sealBlock([]byte("unknown"), 2, unchanged)
+		}, false},
+		{"invalid seal", func() *Block {
+			block := sealBlock(first.Hash, 2, unchanged)
+			block.Nonce++
+			return block
+		}, false},
+		{"invalid transaction ID", func() *Block {
+			block := sealBlock(first.Hash, 2, unchanged)
+			block.Transactions[0].Vout[0].Value++
+			return block
+		}, false},
+		{"two coinbases", func() *Block {
+			block, err := NewBlock([]*Transaction{newTestCoinbase(bc, wallet), newTestCoinbase(bc, wallet)}, first.Hash, 2, bc.Engine())
+			logPanicErr(err)
+			return block
+		}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := bc.CheckBlock(test.block())
+			if test.valid && err != nil {
+				t.Fatal(err)
+			}
+			if !test.valid && err == nil {
+				t.Fatal("Block is valid")
+			}
+		})
+	}
+}
+
+// TestTipConcurrency reads the tip while blocks are mined, run with -race to check it is guarded
+func TestTipConcurrency(t *testing.T) {
+	bc, wallet := newTestBlockchain(t)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		mineBlocks(bc, wallet, 5)
+	}()
+
+	for mining := true; mining; {
+		select {
+		case <-done:
+			mining = false
+		default:
+		}
+
+		bc.Iterator().Next()
+		bc.GetUTXOSetInfo()
+	}
+
+	if bc.GetBestHeight() != 5 || bytes.Compare(bc.Iterator().Next().Hash, bc.tipHash()) != 0 {
+		t.Error("Tip is not the last mined block")
+	}
+}
+
+func TestAddMinedBlockStale(t *testing.T) {
+	bc, wallet := newTestBlockchain(t)
+	mineBlocks(bc, wallet, 1)
+
+	stale := newTestBlock(bc, wallet)
+	mineBlocks(bc, wallet, 1)
+	tip := bc.tipHash()
+
+	if err := bc.addMinedBlock(stale); err != errStaleBlock {
+		t.Fatalf("addMinedBlock returned %v", err)
+	}
+	if bytes.Compare(bc.tipHash(), tip) != 0 || bc.GetBestHeight() != 2 {
+		t.Error("Stale block moved the tip")
+	}
+	if _, err := bc.GetBlock(stale.Hash); err == nil {
+		t.Error("Stale block is stored")
+	}
+}
@@ -0,0 +1,253 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+)
+
+// ChainParams defines the rules and settings of a network
+type ChainParams struct {
+	Name string `json:"name"`
+
+	// GenesisBlock is a hex encoded serialized genesis block. When it is empty the
+	// genesis block is mined from GenesisCoinbaseData and GenesisTimestamp.
+	GenesisBlock        string `json:"genesis_block,omitempty"`
+	GenesisCoinbaseData string `json:"genesis_coinbase_data"`
+	GenesisTimestamp    int64  `json:"genesis_timestamp"`
+
+	// Consensus engine, pow or poa, and the initial validator addresses of poa
+	Consensus  string   `json:"consensus"`
+	Validators []string `json:"validators,omitempty"`
+
+	// Proof of work algorithm and limits
+	PowAlgorithm string `json:"pow_algorithm"`
+	TargetBits   int    `json:"target_bits"`
+	MaxNonce     int    `json:"max_nonce"`
+
+	// Reward schedule, the subsidy halves every HalvingInterval blocks
+	Subsidy         int `json:"subsidy"`
+	HalvingInterval int `json:"halving_interval"`
+
+	// MineOnDemand allows mining blocks immediately with generate
+	MineOnDemand bool `json:"mine_on_demand"`
+
+	// UTXOCommitment requires coinbases to commit to the UTXO set hash at their parent
+	UTXOCommitment bool `json:"utxo_commitment,omitempty"`
+
+	AddressVersion byte `json:"address_version"`
+
+	NetworkMagic uint32   `json:"network_magic"`
+	DefaultPort  string   `json:"default_port"`
+	Seeds        []string `json:"seeds"`
+
+	// DBFile and WalletFile are formatted with the node id
+	DBFile     string `json:"db_file"`
+	WalletFile string `json:"wallet_file"`
+
+	// AssumeUTXO lists the UTXO snapshots a fresh node may be loaded from
+	AssumeUTXO []UTXOSnapshotParams `json:"assume_utxo,omitempty"`
+}
+
+// UTXOSnapshotParams identifies a trusted UTXO snapshot by its block and content hash
+type UTXOSnapshotParams struct {
+	Height      int    `json:"height"`
+	BlockHash   string `json:"block_hash"`
+	ContentHash string `json:"content_hash"`
+}
+
+var (
+	// MainNetParams are the parameters of the main network
+	MainNetParams = ChainParams{
+		Name:                "main",
+		GenesisCoinbaseData: "The Times 03/Jan/2009 Chancellor on brink of second bailout for banks",
+		GenesisTimestamp:    1231006505,
+		Consensus:           ConsensusPoW,
+		PowAlgorithm:        PowSHA256,
+		TargetBits:          16,
+		MaxNonce:            math.MaxInt32,
+		Subsidy:             10,
+		HalvingInterval:     210000,
+		AddressVersion:      0x00,
+		NetworkMagic:        0xf9beb4d9,
+		DefaultPort:         "3000",
+		Seeds:               []string{"localhost:3000"},
+		DBFile:              "blockchain_%s.db",
+		WalletFile:          "wallet_%s.dat",
+	}
+
+	// TestNetParams are the parameters of the public test network
+	TestNetParams = ChainParams{
+		Name:                "testnet",
+		GenesisCoinbaseData: "Test network genesis block",
+		GenesisTimestamp:    1296688602,
+		Consensus:           ConsensusPoW,
+		PowAlgorithm:        PowSHA256,
+		TargetBits:          12,
+		MaxNonce:            math.MaxInt32,
+		Subsidy:             10,
+		HalvingInterval:     210000,
+		AddressVersion:      0x6f,
+		NetworkMagic:        0x0b110907,
+		DefaultPort:         "4000",
+		Seeds:               []string{"localhost:4000"},
+		DBFile:              "blockchain_testnet_%s.db",
+		WalletFile:          "wallet_testnet_%s.dat",
+	}
+
+	// RegTestParams are the parameters of the regression test network
+	RegTestParams = ChainParams{
+		Name:                "regtest",
+		GenesisCoinbaseData: "Regression test network genesis block",
+		GenesisTimestamp:    1296688602,
+		Consensus:           ConsensusPoW,
+		PowAlgorithm:        PowSHA256,
+		TargetBits:          1,
+		MaxNonce:            math.MaxInt32,
+		Subsidy:             10,
+		HalvingInterval:     150,
+		MineOnDemand:        true,
+		AddressVersion:      0x6f,
+		NetworkMagic:        0xfabfb5da,
+		DefaultPort:         "5000",
+		Seeds:               []string{"localhost:5000"},
+		DBFile:              "blockchain_regtest_%s.db",
+		WalletFile:          "wallet_regtest_%s.dat",
+	}
+)
+
+// GetChainParams returns the parameters of a known network by name,
+// otherwise name is read as the path of a chain params JSON file
+func GetChainParams(name string) (*ChainParams, error) {
+	switch name {
+	case "", MainNetParams.Name:
+		return &MainNetParams, nil
+	case TestNetParams.Name:
+		return &TestNetParams, nil
+	case RegTestParams.Name:
+		return &RegTestParams, nil
+	}
+
+	if _, err := os.Stat(name); os.IsNotExist(err) {
+		return nil, fmt.Errorf("Unknown network %s", name)
+	}
+
+	return LoadChainParams(name)
+}
+
+// LoadChainParams reads custom chain params from a JSON file
+func LoadChainParams(file string) (*ChainParams, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	params := ChainParams{Consensus: ConsensusPoW, PowAlgorithm: PowSHA256, MaxNonce: math.MaxInt32}
+	err = json.Unmarshal(content, &params)
+	if err != nil {
+		return nil, err
+	}
+
+	if params.Name == "" || params.DBFile == "" || params.WalletFile == "" {
+		return nil, fmt.Errorf("Chain params %s need a name, db_file and wallet_file", file)
+	}
+
+	return &params, nil
+}
+
+// SaveToFile writes the chain params as JSON
+func (p *ChainParams) SaveToFile(file string) error {
+	content, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(file, append(content, '\n'), 0644)
+}
+
+// BlockSubsidy returns the coinbase reward of a block at the height
+func (p *ChainParams) BlockSubsidy(height int) int {
+	if p.HalvingInterval <= 0 {
+		return p.Subsidy
+	}
+
+	halvings := uint(height / p.HalvingInterval)
+	if halvings >= 63 {
+		return 0
+	}
+
+	return p.Subsidy >> halvings
+}
+
+// genesis returns the genesis block of the network. Without a fixed
+// genesis block in the params a new one paying to the address is mined.
+func (p *ChainParams) genesis(address string) *Block {
+	if p.GenesisBlock != "" {
+		data, err := hex.DecodeString(p.GenesisBlock)
+		logPanicErr(err)
+
+		genesis, err := DeserializeBlock(data)
+		logPanicErr(err)
+
+		return genesis
+	}
+
+	coinbaseTX := NewCoinbaseTX(address, p.GenesisCoinbaseData, p.BlockSubsidy(0))
+	return NewGenesisBlock(coinbaseTX, p)
+}
+
+// Hash identifies the network by the params its blocks are validated with. Seeds,
+// snapshots and file names are left out, they can change without forking the chain,
+// and so is MaxNonce, which only bounds the nonce search of miners.
+func (p *ChainParams) Hash() []byte {
+	consensus := p.consensusParams()
+	consensus.MaxNonce = 0
+
+	return hashJSON(consensus)
+}
+
+func (p *ChainParams) consensusParams() ChainParams {
+	consensus := *p
+	consensus.Seeds = nil
+	consensus.DefaultPort = ""
+	consensus.AssumeUTXO = nil
+	consensus.DBFile = ""
+	consensus.WalletFile = ""
+
+	return consensus
+}
+
+// hashJSON hashes the JSON encoding of the params. JSON is used since gob
+// encodings depend on the types encoded before in the process.
+func hashJSON(params ChainParams) []byte {
+	data, err := json.Marshal(params)
+	logPanicErr(err)
+
+	hash := sha256.Sum256(data)
+	return hash[:]
+}
+
+// assumeUTXO returns whether the snapshot of the block with the content hash is trusted
+func (p *ChainParams) assumeUTXO(height int, blockHash, contentHash []byte) bool {
+	for _, snapshot := range p.AssumeUTXO {
+		if snapshot.Height == height &&
+			snapshot.BlockHash == hex.EncodeToString(blockHash) &&
+			snapshot.ContentHash == hex.EncodeToString(contentHash) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *ChainParams) dbFile(nodeID string) string {
+	return fmt.Sprintf(p.DBFile, nodeID)
+}
+
+func (p *ChainParams) walletFile(nodeID string) string {
+	return fmt.Sprintf(p.WalletFile, nodeID)
+}
@@ -0,0 +1,34 @@
+package blockchain
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestChainParamsHash(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(*ChainParams)
+		same   bool
+	}{
+		{"seeds", func(p *ChainParams) { p.Seeds = []string{"localhost:6000"} }, true},
+		{"files", func(p *ChainParams) { p.DBFile, p.WalletFile = "db_%s", "wallet_%s" }, true},
+		{"max nonce", func(p *ChainParams) { p.MaxNonce = 1000 }, true},
+		{"snapshots", func(p *ChainParams) { p.AssumeUTXO = []UTXOSnapshotParams{{Height: 1}} }, true},
+		{"target bits", func(p *ChainParams) { p.TargetBits++ }, false},
+		{"subsidy", func(p *ChainParams) { p.Subsidy++ }, false},
+		{"magic", func(p *ChainParams) { p.NetworkMagic++ }, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			params := RegTestParams
+			test.change(&params)
+
+			same := bytes.Equal(params.Hash(), RegTestParams.Hash())
+			if same != test.same {
+				t.Errorf("Hash is the same: %t, expected %t", same, test.same)
+			}
+		})
+	}
+}
@@ -0,0 +1,399 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// Levels of VerifyChain, every level includes the checks of the levels below it
+const (
+	// VerifyLinks checks that blocks are stored, indexed by height and link to their parent
+	VerifyLinks = iota
+
+	// VerifySeals checks the proof of work or authority, which commits to the merkle root, and the transaction IDs
+	VerifySeals
+
+	// VerifySignatures checks the transaction signatures
+	VerifySignatures
+
+	// VerifyUndo checks that the undo data of blocks matches the outputs they spend
+	VerifyUndo
+
+	// VerifyUTXOSet checks that the UTXO set matches a replay of the whole chain
+	VerifyUTXOSet
+)
+
+// ChainVerifyError is an inconsistency VerifyChain found in a main chain block
+type ChainVerifyError struct {
+	Hash   []byte
+	Height int
+	Reason string
+
+	// Chainstate is set when the blocks are consistent but the UTXO set at the tip is not
+	Chainstate bool
+}
+
+func (e *ChainVerifyError) Error() string {
+	return fmt.Sprintf("Block %x at height %d: %s", e.Hash, e.Height, e.Reason)
+}
+
+// VerifyChain checks the last depth blocks of the main chain, every block when depth is 0,
+// up to the level. It returns the first inconsistency in height order as a *ChainVerifyError.
+// Bodies of pruned blocks are not checked beyond their links.
+func (bc *Blockchain) VerifyChain(depth, level int) error {
+	tipHeight := bc.GetBestHeight()
+
+	start := 0
+	if depth > 0 && tipHeight-depth+1 > 0 {
+		start = tipHeight - depth + 1
+	}
+
+	var prevHash []byte
+	if start > 0 {
+		hash, err := bc.GetBlockHash(start - 1)
+		if err != nil {
+			return &ChainVerifyError{nil, start - 1, err.Error(), false}
+		}
+		prevHash = hash
+	}
+
+	for height := start; height <= tipHeight; height++ {
+		block, err := bc.verifyBlockLinks(height, prevHash)
+		if err != nil {
+			return err
+		}
+		if height == tipHeight && bytes.Compare(block.Hash, bc.tipHash()) != 0 {
+			return &ChainVerifyError{block.Hash, height, "Block is not the tip", false}
+		}
+		prevHash = block.Hash
+
+		if level < VerifySeals || block.IsPruned() {
+			continue
+		}
+
+		reason := bc.verifyBlockSeal(block)
+		if reason == "" && level >= VerifySignatures {
+			reason = bc.verifyBlockSignatures(block)
+		}
+		if reason == "" && level >= VerifyUndo && height > 0 {
+			reason = bc.verifyBlockUndo(block)
+		}
+		if reason != "" {
+			return &ChainVerifyError{block.Hash, height, reason, false}
+		}
+	}
+
+	if level >= VerifyUTXOSet {
+		return bc.verifyUTXOSet(tipHeight)
+	}
+
+	return nil
+}
+
+// verifyBlockLinks returns the main chain block at the height, checking it links to the previous one
+func (bc *Blockchain) verifyBlockLinks(height int, prevHash []byte) (*Block, error) {
+	hash, err := bc.GetBlockHash(height)
+	if err != nil {
+		return nil, &ChainVerifyError{nil, height, "Height is missing from the height index", false}
+	}
+
+	block, err := bc.GetBlock(hash)
+	if err != nil {
+		return nil, &ChainVerifyError{hash, height, "Block is not stored", false}
+	}
+
+	switch {
+	case bytes.Compare(block.Hash, hash) != 0:
+		return nil, &ChainVerifyError{hash, height, fmt.Sprintf("Block is stored with hash %x", block.Hash), false}
+	case block.Height != height:
+		return nil, &ChainVerifyError{hash, height, fmt.Sprintf("Block has height %d", block.Height), false}
+	case height == 0 && len(block.PrevBlockHash) != 0:
+		return nil, &ChainVerifyError{hash, height, "Genesis block has a parent", false}
+	case height > 0 && bytes.Compare(block.PrevBlockHash, prevHash) != 0:
+		return nil, &ChainVerifyError{hash, height, fmt.Sprintf("Block links to %x instead of %x", block.PrevBlockHash, prevHash), false}
+	}
+
+	return &block, nil
+}
+
+func (bc *Blockchain) verifyBlockSeal(block *Block) string {
+	err := bc.Engine().VerifySeal(block)
+	if err != nil {
+		return err.Error()
+	}
+
+	coinbases := 0
+	for _, tx := range block.Transactions {
+		if bytes.Compare(tx.ID, tx.unsignedHash()) != 0 {
+			return fmt.Sprintf("Transaction %x has invalid ID", tx.ID)
+		}
+		if tx.IsCoinbase() {
+			coinbases++
+		}
+	}
+
+	if coinbases != 1 {
+		return fmt.Sprintf("Block has %d coinbase transactions, expected 1", coinbases)
+	}
+
+	return ""
+}
+
+func (bc *Blockchain) verifyBlockSignatures(block *Block) string {
+	for _, tx := range block.Transactions {
+		if tx.IsCoinbase() {
+			continue
+		}
+
+		if tx.IsGovernance() {
+			set, err := bc.validatorSetAt(block.PrevBlockHash)
+			if err != nil || !tx.verifyGovernance(set) {
+				return fmt.Sprintf("Governance transaction %x is not valid", tx.ID)
+			}
+			continue
+		}
+
+		prevTxs := make(map[string]Transaction)
+		for _, in := range tx.Vin {
+			prevTx, err := bc.FindTransaction(in.TxID)
+			if err != nil {
+				// Transactions of pruned blocks can not be checked
+				if bc.PrunedHeight() >= 0 {
+					prevTxs = nil
+					break
+				}
+				return fmt.Sprintf("Transaction %x spends unknown transaction %x", tx.ID, in.TxID)
+			}
+			if in.Vout < 0 || in.Vout >= len(prevTx.Vout) {
+				return fmt.Sprintf("Transaction %x spends unknown output %x:%d", tx.ID, in.TxID, in.Vout)
+			}
+			prevTxs[hex.EncodeToString(prevTx.ID)] = prevTx
+		}
+
+		if prevTxs != nil && !tx.Verify(prevTxs) {
+			return fmt.Sprintf("Transaction %x has invalid signature", tx.ID)
+		}
+	}
+
+	return ""
+}
+
+func (bc *Blockchain) verifyBlockUndo(block *Block) string {
+	var undoData []byte
+
+	err := bc.store.View(func(tx StoreTx) error {
+		undoData = tx.Bucket(undoBucketName).Get(block.Hash)
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	if undoData == nil {
+		// Blocks below a loaded snapshot get their bodies back without undo data
+		if block.Height <= bc.PrunedHeight() {
+			return ""
+		}
+		return "Block has no undo data"
+	}
+
+	undo := deserializeUTXOUndo(undoData)
+	next := 0
+	for _, tx := range block.Transactions {
+		if tx.IsCoinbase() || tx.IsGovernance() {
+			continue
+		}
+
+		for _, in := range tx.Vin {
+			if next >= len(undo.Spent) {
+				return "Undo data is missing spent outputs"
+			}
+
+			spent := undo.Spent[next]
+			if bytes.Compare(spent.TxID, in.TxID) != 0 || spent.Vout != in.Vout {
+				return fmt.Sprintf("Undo data has output %x:%d instead of %x:%d", spent.TxID, spent.Vout, in.TxID, in.Vout)
+			}
+			next++
+		}
+	}
+
+	if next != len(undo.Spent) {
+		return "Undo data has outputs the block does not spend"
+	}
+
+	return ""
+}
+
+// verifyUTXOSet replays the main chain and compares the result with the UTXO set and its state
+func (bc *Blockchain) verifyUTXOSet(tipHeight int) error {
+	if bc.PrunedHeight() >= 0 {
+		return errors.New("The chain is pruned, the UTXO set can not be replayed")
+	}
+
+	replay := NewMemoryStore()
+	err := replay.Update(createChainstate)
+	if err != nil {
+		return err
+	}
+	replayed := newUTXOSetState()
+
+	for height := 0; height <= tipHeight; height++ {
+		block, err := bc.GetBlockByHeight(height)
+		if err != nil {
+			return &ChainVerifyError{nil, height, err.Error(), false}
+		}
+
+		err = replay.Update(func(tx StoreTx) error {
+			_, err := applyBlockUTXOs(&hashedUTXOStore{chainstate(tx), replayed}, &block)
+			return err
+		})
+		if err != nil {
+			return &ChainVerifyError{block.Hash, height, err.Error(), false}
+		}
+	}
+
+	stored := newUTXOSetState()
+	err = bc.store.View(func(tx StoreTx) error {
+		return bc.utxoStore(tx, false).ForEach(func(txID []byte, vout int, entry UTXOEntry) error {
+			stored.add(txID, vout, entry)
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	info := bc.GetUTXOSetInfo()
+	replayedHash := replayed.hash.Digest()
+
+	if bytes.Compare(stored.hash.Digest(), replayedHash) != 0 || stored.outputs != replayed.outputs {
+		return &ChainVerifyError{bc.tipHash(), tipHeight, "UTXO set does not match a replay of the chain", true}
+	}
+	if bytes.Compare(info.Hash, replayedHash) != 0 || info.Outputs != replayed.outputs || info.Amount != replayed.amount {
+		return &ChainVerifyError{bc.tipHash(), tipHeight, "UTXO set state does not match a replay of the chain", true}
+	}
+
+	return nil
+}
+
+// RepairChain rewinds the main chain to the last good block before the inconsistency.
+// Inconsistencies of the UTXO set are repaired by reindexing it.
+func (bc *Blockchain) RepairChain(verifyErr *ChainVerifyError) error {
+	if verifyErr.Chainstate {
+		UTXOSet{bc}.Reindex()
+		return nil
+	}
+
+	if verifyErr.Height == 0 {
+		return errors.New("The genesis block is inconsistent, the chain can not be repaired")
+	}
+
+	good, err := bc.GetBlockByHeight(verifyErr.Height - 1)
+	if err != nil {
+		return err
+	}
+
+	return bc.RewindTo(good.Hash)
+}
+
+// RewindTo makes the main chain block the tip and deletes the main chain blocks above it.
+// Blocks are disconnected with their undo data, when that fails the chainstate is reindexed.
+func (bc *Blockchain) RewindTo(hash []byte) error {
+	target, err := bc.GetBlock(hash)
+	if err != nil {
+		return err
+	}
+
+	mainHash, err := bc.GetBlockHash(target.Height)
+	if err != nil || bytes.Compare(mainHash, hash) != 0 {
+		return fmt.Errorf("Block %x is not on the main chain", hash)
+	}
+
+	tipHeight := bc.GetBestHeight()
+	var above [][]byte
+	for height := target.Height + 1; height <= tipHeight; height++ {
+		blockHash, err := bc.GetBlockHash(height)
+		if err == nil {
+			above = append(above, blockHash)
+		}
+	}
+
+	err = bc.updateChain(func(tx StoreTx) (*tipUpdate, error) {
+		update, err := bc.setTip(tx, &target)
+		if err != nil {
+			return nil, err
+		}
+
+		return update, deleteBlocks(tx, above)
+	})
+	if err == nil {
+		return nil
+	}
+
+	log.Printf("Disconnecting blocks failed: %s, reindexing\n", err)
+
+	// Indexes of the deleted blocks are dropped and built again
+	txIndex, addrIndex := bc.HasTxIndex(), bc.HasAddrIndex()
+
+	err = bc.updateChain(func(tx StoreTx) (*tipUpdate, error) {
+		heights := tx.Bucket(heightIndexBucketName)
+		for height := target.Height + 1; height <= tipHeight; height++ {
+			err := heights.Delete(heightKey(height))
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		for _, name := range [][]byte{txIndexBucketName, addrIndexBucketName} {
+			if tx.Bucket(name) != nil {
+				err := tx.DeleteBucket(name)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		err := deleteBlocks(tx, above)
+		if err != nil {
+			return nil, err
+		}
+
+		return &tipUpdate{tip: target.Hash}, tx.Bucket(blocksBucketName).Put(lastHashKey, target.Hash)
+	})
+	if err != nil {
+		return err
+	}
+
+	UTXOSet{bc}.Reindex()
+	if txIndex {
+		bc.EnableTxIndex()
+	}
+	if addrIndex {
+		bc.EnableAddrIndex()
+	}
+
+	return nil
+}
+
+// deleteBlocks deletes the blocks and their undo data
+func deleteBlocks(tx StoreTx, hashes [][]byte) error {
+	b := tx.Bucket(blocksBucketName)
+	undo := tx.Bucket(undoBucketName)
+
+	for _, hash := range hashes {
+		err := b.Delete(hash)
+		if err != nil {
+			return err
+		}
+
+		err = undo.Delete(hash)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
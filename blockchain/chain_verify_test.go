@@ -0,0 +1,120 @@
+package blockchain
+
+import "testing"
+
+func TestVerifyChain(t *testing.T) {
+	putBlock := func(tx StoreTx, block *Block) error {
+		return tx.Bucket(blocksBucketName).Put(block.Hash, block.Serialize())
+	}
+
+	tests := []struct {
+		name       string
+		corrupt    func(bc *Blockchain, wallet *Wallet, block *Block) error
+		level      int
+		height     int
+		chainstate bool
+	}{
+		{"missing height", func(bc *Blockchain, wallet *Wallet, block *Block) error {
+			return bc.store.Update(func(tx StoreTx) error {
+				return tx.Bucket(heightIndexBucketName).Delete(heightKey(2))
+			})
+		}, VerifyLinks, 2, false},
+		{"wrong height", func(bc *Blockchain, wallet *Wallet, block *Block) error {
+			block.Height = 5
+			return bc.store.Update(func(tx StoreTx) error { return putBlock(tx, block) })
+		}, VerifyLinks, 2, false},
+		{"invalid seal", func(bc *Blockchain, wallet *Wallet, block *Block) error {
+			block.Nonce++
+			return bc.store.Update(func(tx StoreTx) error { return putBlock(tx, block) })
+		}, VerifySeals, 2, false},
+		{"invalid signature", func(bc *Blockchain, wallet *Wallet, block *Block) error {
+			// A sealed tip spending the output again with a forged signature
+			spend := *block.Transactions[1]
+			spend.Vin = append([]TXInput{}, spend.Vin...)
+			spend.Vin[0].Signature = append([]byte{}, spend.Vin[0].Signature...)
+			spend.Vin[0].Signature[0] ^= 0xff
+
+			tip, _ := bc.GetBlockByHeight(3)
+			forged, err := NewBlock([]*Transaction{newTestCoinbase(bc, wallet), &spend}, tip.PrevBlockHash, 3, bc.Engine())
+			if err != nil {
+				return err
+			}
+
+			err = bc.store.Update(func(tx StoreTx) error {
+				err := putBlock(tx, forged)
+				if err == nil {
+					err = tx.Bucket(heightIndexBucketName).Put(heightKey(3), forged.Hash)
+				}
+				if err == nil {
+					err = tx.Bucket(blocksBucketName).Put(lastHashKey, forged.Hash)
+				}
+				return err
+			})
+			bc.setTipHash(forged.Hash)
+			return err
+		}, VerifySignatures, 3, false},
+		{"missing undo data", func(bc *Blockchain, wallet *Wallet, block *Block) error {
+			return bc.store.Update(func(tx StoreTx) error {
+				return tx.Bucket(undoBucketName).Delete(block.Hash)
+			})
+		}, VerifyUndo, 2, false},
+		{"missing output", func(bc *Blockchain, wallet *Wallet, block *Block) error {
+			return bc.store.Update(func(tx StoreTx) error {
+				return chainstate(tx).Delete(block.Transactions[0].ID, 0)
+			})
+		}, VerifyUTXOSet, 3, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bc, wallet := newTestBlockchain(t)
+			bc.SetUTXOCacheSize(0)
+			mineBlocks(bc, wallet, 1)
+			genesis, _ := bc.GetBlockByHeight(0)
+			bc.AddBlock(newTestBlock(bc, wallet, spendTX(bc, wallet, genesis.Transactions[0].ID, []int{0}, 10)))
+			mineBlocks(bc, wallet, 1)
+
+			if err := bc.VerifyChain(0, VerifyUTXOSet); err != nil {
+				t.Fatalf("Chain is inconsistent before the corruption: %s", err)
+			}
+
+			block, _ := bc.GetBlockByHeight(2)
+			err := test.corrupt(bc, wallet, &block)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if test.level > VerifyLinks {
+				if err := bc.VerifyChain(0, test.level-1); err != nil {
+					t.Errorf("Level %d found %s", test.level-1, err)
+				}
+			}
+
+			err = bc.VerifyChain(0, test.level)
+			verifyErr, ok := err.(*ChainVerifyError)
+			if !ok {
+				t.Fatalf("Level %d returned %v", test.level, err)
+			}
+			if verifyErr.Height != test.height || verifyErr.Chainstate != test.chainstate {
+				t.Errorf("Inconsistency at height %d, chainstate %t, expected %d, %t", verifyErr.Height, verifyErr.Chainstate, test.height, test.chainstate)
+			}
+
+			// Blocks are rewound to the one below the inconsistency, the chainstate is reindexed
+			err = bc.RepairChain(verifyErr)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			repairedHeight := test.height - 1
+			if test.chainstate {
+				repairedHeight = test.height
+			}
+			if bc.GetBestHeight() != repairedHeight {
+				t.Errorf("Repaired chain is at height %d, expected %d", bc.GetBestHeight(), repairedHeight)
+			}
+			if err := bc.VerifyChain(0, VerifyUTXOSet); err != nil {
+				t.Errorf("Repaired chain is inconsistent: %s", err)
+			}
+		})
+	}
+}
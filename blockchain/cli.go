@@ -6,21 +6,54 @@ import (
 	"log"
 
 	"os"
+	"strconv"
+	"strings"
 )
 
 // CLI responsible for processing command line arguments
-type CLI struct{}
+type CLI struct {
+	params *ChainParams
+}
+
+// addressList collects the values of a flag given more than once
+type addressList []string
+
+func (l *addressList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *addressList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
 
 func (cli *CLI) printUsage() {
 	fmt.Println("Usage:")
-	fmt.Println("  createblockchain -address ADDRESS - Create a blockchain and send genesis block reward to ADDRESS")
+	fmt.Println("  createblockchain -address ADDRESS -txindex -addrindex - Create a blockchain and send genesis block reward to ADDRESS, not needed when the network has a fixed genesis block. -txindex and -addrindex maintain the transaction and address indexes")
+	fmt.Println("  creategenesis -name NAME -alloc FILE -message MSG -timestamp TS -pow ALGORITHM -bits BITS -port PORT -addrversion VERSION -seed ADDR -out FILE - Mine a genesis block paying the address to amount JSON allocations and write the chain params of the new network. Allocations use the addresses of the current network, -seed can be given more than once")
 	fmt.Println("  createwallet - Generates a new key-pair and saves it into the wallet file")
-	fmt.Println("  getbalance -address ADDRESS - Get balance of ADDRESS")
+	fmt.Println("  dumputxo FILE - Write the UTXO set at the tip with the main chain headers and print its content hash")
+	fmt.Println("  exportchain FILE -from H -to H - Write the main chain blocks from height H to height H, the tip by default, to FILE in height order")
+	fmt.Println("  generate N -address ADDRESS - Mine N blocks immediately and send rewards to ADDRESS, defaults to the first wallet address. Regtest only")
+	fmt.Println("  getaddresshistory -address ADDRESS - List every output paid to ADDRESS and the transaction spending it. Needs the address index")
+	fmt.Println("  getaddressutxos -address ADDRESS - List the unspent outputs of ADDRESS. Needs the address index")
+	fmt.Println("  getbalance -address ADDRESS - Get balance of ADDRESS, using the address index when it is enabled")
+	fmt.Println("  getpeerinfo -rpc ADDR - Print the connected peers of the node with RPC at ADDR, their versions, heights and traffic")
+	fmt.Println("  getblock -height N | -hash HASH -json - Print the main chain block at height N or the block with HASH, as JSON when -json is set")
+	fmt.Println("  gettransaction TXID - Print the transaction with TXID, the block it is in and its confirmations")
+	fmt.Println("  gettxoutsetinfo - Print the number of unspent outputs, their total amount and the rolling UTXO set hash")
+	fmt.Println("  governance -from VALIDATOR -add ADDRESS | -remove ADDRESS -mine - Add or remove a proof of authority validator, signed by the VALIDATOR key. Mine on the same node, when -mine is set.")
+	fmt.Println("  importchain FILE - Validate and connect the blocks of a file written by exportchain. Blocks already in the chain are skipped, so an interrupted import resumes")
 	fmt.Println("  listaddresses - Lists all addresses from the wallet file")
+	fmt.Println("  loadutxo FILE - Create the blockchain of a fresh node from a UTXO snapshot listed in the chain params, validating from its tip on")
+	fmt.Println("  mine -address ADDRESS -rpc ADDR - Mine blocks using templates from the node RPC at ADDR and send rewards to ADDRESS")
 	fmt.Println("  printchain - Print all the blocks of the blockchain")
-	fmt.Println("  reindexutxo - Rebuilds the UTXO set")
+	fmt.Println("  reindexutxo - Rebuilds the UTXO set and its undo data by replaying the chain, to recover a damaged chainstate")
 	fmt.Println("  send -from FROM -to TO -amount AMOUNT -mine - Send AMOUNT of coins from FROM address to TO. Mine on the same node, when -mine is set.")
-	fmt.Println("  startnode -miner ADDRESS - Start a node with ID specified in NODE_ID env. var. -miner enables mining")
+	fmt.Println("  startnode -miner ADDRESS -rpc ADDR -txindex -addrindex -dbcache MB -prune=MB -verifysnapshot -maxinbound N -maxoutbound N -listen ADDR -externalip IP -connect ADDR -addnode ADDR - Start a node with ID specified in NODE_ID env. var. -miner enables mining, or sets the validator key under proof of authority, -rpc sets the RPC listen address, -txindex and -addrindex build and maintain the transaction and address indexes, -dbcache sets the UTXO cache size in MB, -prune deletes old block bodies down to MB and stops serving them to peers, -verifysnapshot downloads and replays the history below a loaded UTXO snapshot in the background, -maxinbound and -maxoutbound limit the peer connections, -listen sets the peer listen address, -externalip the address announced to peers, -connect dials only the given peers instead of the seeds, -addnode dials peers besides the seeds. -connect and -addnode may be repeated")
+	fmt.Println("  verifychain -depth N -level 0..4 -repair - Check the last N blocks, every block when N is 0: 0 hash links and heights, 1 seals and merkle roots, 2 signatures, 3 undo data, 4 the UTXO set against a replay. Print the first inconsistency, and rewind to the last good block when -repair is set")
+	fmt.Println()
+	fmt.Println("Set NETWORK env. var. to main, testnet, regtest or a chain params JSON file to select the network.")
 }
 
 func (cli *CLI) validateArgs() {
@@ -34,37 +67,128 @@ func (cli *CLI) validateArgs() {
 func (cli *CLI) Run() {
 	cli.validateArgs()
 
-	// set NODE_ID in env first with "export NODE_ID=3000"
+	params, err := GetChainParams(os.Getenv("NETWORK"))
+	if err != nil {
+		log.Panic(err)
+	}
+	cli.params = params
+
+	// set NODE_ID in env with "export NODE_ID=3000", defaults to the network port
 	nodeID := os.Getenv("NODE_ID")
 	if nodeID == "" {
-		fmt.Printf("NODE_ID env. var is not set!")
-		os.Exit(1)
+		nodeID = params.DefaultPort
 	}
 
+	getAddressHistoryCmd := flag.NewFlagSet("getaddresshistory", flag.ExitOnError)
+	getAddressUTXOsCmd := flag.NewFlagSet("getaddressutxos", flag.ExitOnError)
 	getBalanceCmd := flag.NewFlagSet("getbalance", flag.ExitOnError)
+	getBlockCmd := flag.NewFlagSet("getblock", flag.ExitOnError)
+	getTransactionCmd := flag.NewFlagSet("gettransaction", flag.ExitOnError)
+	getTxOutSetInfoCmd := flag.NewFlagSet("gettxoutsetinfo", flag.ExitOnError)
 	createBlockchainCmd := flag.NewFlagSet("createblockchain", flag.ExitOnError)
 	createWalletCmd := flag.NewFlagSet("createwallet", flag.ExitOnError)
+	createGenesisCmd := flag.NewFlagSet("creategenesis", flag.ExitOnError)
 	listAddressesCmd := flag.NewFlagSet("listaddresses", flag.ExitOnError)
 	printChainCmd := flag.NewFlagSet("printchain", flag.ExitOnError)
 	reindexUTXOCmd := flag.NewFlagSet("reindexutxo", flag.ExitOnError)
 	sendCmd := flag.NewFlagSet("send", flag.ExitOnError)
 	startNodeCmd := flag.NewFlagSet("startnode", flag.ExitOnError)
 	getBlockchainHeightCmd := flag.NewFlagSet("height", flag.ExitOnError)
+	mineCmd := flag.NewFlagSet("mine", flag.ExitOnError)
+	generateCmd := flag.NewFlagSet("generate", flag.ExitOnError)
+	governanceCmd := flag.NewFlagSet("governance", flag.ExitOnError)
+	dumpUTXOCmd := flag.NewFlagSet("dumputxo", flag.ExitOnError)
+	loadUTXOCmd := flag.NewFlagSet("loadutxo", flag.ExitOnError)
+	verifyChainCmd := flag.NewFlagSet("verifychain", flag.ExitOnError)
+	getPeerInfoCmd := flag.NewFlagSet("getpeerinfo", flag.ExitOnError)
+	exportChainCmd := flag.NewFlagSet("exportchain", flag.ExitOnError)
+	importChainCmd := flag.NewFlagSet("importchain", flag.ExitOnError)
 
+	getAddressHistoryAddress := getAddressHistoryCmd.String("address", "", "The address to list outputs for")
+	getAddressUTXOsAddress := getAddressUTXOsCmd.String("address", "", "The address to list unspent outputs for")
 	getBalanceAddress := getBalanceCmd.String("address", "", "The address to get balance for")
+	getBlockHeight := getBlockCmd.Int("height", -1, "Height of the main chain block")
+	getBlockHash := getBlockCmd.String("hash", "", "Hash of the block")
+	getBlockJSON := getBlockCmd.Bool("json", false, "Print the block as JSON")
 	createBlockchainAddress := createBlockchainCmd.String("address", "", "The address to send genesis block reward to")
+	createBlockchainTxIndex := createBlockchainCmd.Bool("txindex", false, "Maintain the transaction index")
+	createBlockchainAddrIndex := createBlockchainCmd.Bool("addrindex", false, "Maintain the address index")
+	createGenesisName := createGenesisCmd.String("name", "", "Name of the new network")
+	createGenesisAlloc := createGenesisCmd.String("alloc", "", "JSON file mapping addresses to premined amounts")
+	createGenesisMessage := createGenesisCmd.String("message", cli.params.GenesisCoinbaseData, "Coinbase message of the genesis block")
+	createGenesisTimestamp := createGenesisCmd.Int64("timestamp", cli.params.GenesisTimestamp, "Unix timestamp of the genesis block")
+	createGenesisPow := createGenesisCmd.String("pow", cli.params.PowAlgorithm, "Proof of work algorithm, sha256 or scrypt")
+	createGenesisBits := createGenesisCmd.Int("bits", cli.params.TargetBits, "Proof of work difficulty bits")
+	createGenesisOut := createGenesisCmd.String("out", "", "Chain params file to write, defaults to NAME.json")
+	createGenesisPort := createGenesisCmd.String("port", "", "Default port of the new network")
+	createGenesisAddrVersion := createGenesisCmd.Int("addrversion", -1, "Address version byte of the new network")
+	var createGenesisSeeds addressList
+	createGenesisCmd.Var(&createGenesisSeeds, "seed", "Seed node ADDR of the new network, can be given more than once")
 	sendFrom := sendCmd.String("from", "", "Source wallet address")
 	sendTo := sendCmd.String("to", "", "Destination wallet address")
 	sendAmount := sendCmd.Int("amount", 0, "Amount to send")
 	sendMine := sendCmd.Bool("mine", false, "Mine immediately on the same node")
 	startNodeMiner := startNodeCmd.String("miner", "", "Enable mining mode and send reward to ADDRESS")
+	startNodeRPC := startNodeCmd.String("rpc", "", "RPC listen address, defaults to localhost:NODE_ID+10000")
+	startNodeTxIndex := startNodeCmd.Bool("txindex", false, "Build and maintain the transaction index")
+	startNodeAddrIndex := startNodeCmd.Bool("addrindex", false, "Build and maintain the address index")
+	startNodeDBCache := startNodeCmd.Int("dbcache", DefaultUTXOCacheSize>>20, "UTXO cache size in MB, 0 disables the cache")
+	startNodePrune := startNodeCmd.Int("prune", 0, "Prune block bodies down to MB, 0 keeps every block")
+	startNodeVerifySnapshot := startNodeCmd.Bool("verifysnapshot", false, "Download and replay the history below a loaded UTXO snapshot")
+	startNodeMaxInbound := startNodeCmd.Int("maxinbound", DefaultMaxInbound, "Maximum number of peers connecting to the node")
+	startNodeMaxOutbound := startNodeCmd.Int("maxoutbound", DefaultMaxOutbound, "Maximum number of peers the node connects to")
+	startNodeListen := startNodeCmd.String("listen", "", "Address to accept peers on, defaults to localhost:NODE_ID")
+	startNodeExternalIP := startNodeCmd.String("externalip", "", "Host, or host:port, peers reach the node at")
+	var startNodeConnect, startNodeAddNode addressList
+	startNodeCmd.Var(&startNodeConnect, "connect", "Connect only to ADDR, can be given more than once")
+	startNodeCmd.Var(&startNodeAddNode, "addnode", "Keep a connection to ADDR besides the seeds, can be given more than once")
+	verifyChainDepth := verifyChainCmd.Int("depth", 6, "Number of blocks below the tip to check, 0 checks every block")
+	verifyChainLevel := verifyChainCmd.Int("level", VerifyUndo, "How thorough the checks are, 0 to 4")
+	verifyChainRepair := verifyChainCmd.Bool("repair", false, "Rewind the chain to the last good block")
+	exportChainFrom := exportChainCmd.Int("from", 0, "Height of the first block to write")
+	exportChainTo := exportChainCmd.Int("to", -1, "Height of the last block to write, defaults to the tip")
+	exportChainFile := ""
+	mineAddress := mineCmd.String("address", "", "The address to send mining rewards to")
+	mineRPC := mineCmd.String("rpc", "", "RPC address of the node, defaults to localhost:NODE_ID+10000")
+	getPeerInfoRPC := getPeerInfoCmd.String("rpc", "", "RPC address of the node, defaults to localhost:NODE_ID+10000")
+	generateAddress := generateCmd.String("address", "", "The address to send mining rewards to")
+	generateBlocks := ""
+	governanceFrom := governanceCmd.String("from", "", "Validator address signing the transaction")
+	governanceAdd := governanceCmd.String("add", "", "Address of the validator to add")
+	governanceRemove := governanceCmd.String("remove", "", "Address of the validator to remove")
+	governanceMine := governanceCmd.Bool("mine", false, "Mine immediately on the same node")
 
 	switch os.Args[1] {
+	case "getaddresshistory":
+		err := getAddressHistoryCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "getaddressutxos":
+		err := getAddressUTXOsCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
 	case "getbalance":
 		err := getBalanceCmd.Parse(os.Args[2:])
 		if err != nil {
 			log.Panic(err)
 		}
+	case "getblock":
+		err := getBlockCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "gettransaction":
+		err := getTransactionCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "gettxoutsetinfo":
+		err := getTxOutSetInfoCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
 	case "createblockchain":
 		err := createBlockchainCmd.Parse(os.Args[2:])
 		if err != nil {
@@ -75,6 +199,11 @@ func (cli *CLI) Run() {
 		if err != nil {
 			log.Panic(err)
 		}
+	case "creategenesis":
+		err := createGenesisCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
 	case "listaddresses":
 		err := listAddressesCmd.Parse(os.Args[2:])
 		if err != nil {
@@ -105,11 +234,88 @@ func (cli *CLI) Run() {
 		if err != nil {
 			log.Panic(err)
 		}
+	case "mine":
+		err := mineCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "governance":
+		err := governanceCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "dumputxo":
+		err := dumpUTXOCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "loadutxo":
+		err := loadUTXOCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "verifychain":
+		err := verifyChainCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "exportchain":
+		err := exportChainCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+		// Flags may follow the file name
+		exportChainFile = exportChainCmd.Arg(0)
+		if exportChainCmd.NArg() > 1 {
+			err = exportChainCmd.Parse(exportChainCmd.Args()[1:])
+			if err != nil {
+				log.Panic(err)
+			}
+		}
+	case "importchain":
+		err := importChainCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "getpeerinfo":
+		err := getPeerInfoCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "generate":
+		err := generateCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+		// Flags may follow the block count
+		generateBlocks = generateCmd.Arg(0)
+		if generateCmd.NArg() > 1 {
+			err = generateCmd.Parse(generateCmd.Args()[1:])
+			if err != nil {
+				log.Panic(err)
+			}
+		}
 	default:
 		cli.printUsage()
 		os.Exit(1)
 	}
 
+	if getAddressHistoryCmd.Parsed() {
+		if *getAddressHistoryAddress == "" {
+			getAddressHistoryCmd.Usage()
+			os.Exit(1)
+		}
+		cli.getAddressHistory(*getAddressHistoryAddress, nodeID)
+	}
+
+	if getAddressUTXOsCmd.Parsed() {
+		if *getAddressUTXOsAddress == "" {
+			getAddressUTXOsCmd.Usage()
+			os.Exit(1)
+		}
+		cli.getAddressUTXOs(*getAddressUTXOsAddress, nodeID)
+	}
+
 	if getBalanceCmd.Parsed() {
 		if *getBalanceAddress == "" {
 			getBalanceCmd.Usage()
@@ -118,18 +324,51 @@ func (cli *CLI) Run() {
 		cli.getBalance(*getBalanceAddress, nodeID)
 	}
 
+	if getBlockCmd.Parsed() {
+		if (*getBlockHeight < 0) == (*getBlockHash == "") {
+			getBlockCmd.Usage()
+			os.Exit(1)
+		}
+		cli.getBlock(*getBlockHeight, *getBlockHash, *getBlockJSON, nodeID)
+	}
+
+	if getTransactionCmd.Parsed() {
+		if getTransactionCmd.NArg() != 1 {
+			getTransactionCmd.Usage()
+			os.Exit(1)
+		}
+		cli.getTransaction(getTransactionCmd.Arg(0), nodeID)
+	}
+
+	if getTxOutSetInfoCmd.Parsed() {
+		cli.getTxOutSetInfo(nodeID)
+	}
+
 	if createBlockchainCmd.Parsed() {
-		if *createBlockchainAddress == "" {
+		if *createBlockchainAddress == "" && cli.params.GenesisBlock == "" {
 			createBlockchainCmd.Usage()
 			os.Exit(1)
 		}
-		cli.createBlockchain(*createBlockchainAddress, nodeID)
+		cli.createBlockchain(*createBlockchainAddress, nodeID, *createBlockchainTxIndex, *createBlockchainAddrIndex)
 	}
 
 	if createWalletCmd.Parsed() {
 		cli.createWallet(nodeID)
 	}
 
+	if createGenesisCmd.Parsed() {
+		if *createGenesisName == "" || *createGenesisAlloc == "" || *createGenesisMessage == "" || *createGenesisBits <= 0 ||
+			*createGenesisPort == "" || *createGenesisAddrVersion < 0 || *createGenesisAddrVersion > 0xff {
+			createGenesisCmd.Usage()
+			os.Exit(1)
+		}
+		if *createGenesisOut == "" {
+			*createGenesisOut = *createGenesisName + ".json"
+		}
+		cli.createGenesis(*createGenesisName, *createGenesisAlloc, *createGenesisMessage, *createGenesisTimestamp, *createGenesisPow, *createGenesisBits,
+			*createGenesisPort, byte(*createGenesisAddrVersion), createGenesisSeeds, *createGenesisOut)
+	}
+
 	if listAddressesCmd.Parsed() {
 		cli.listAddress(nodeID)
 	}
@@ -152,15 +391,101 @@ func (cli *CLI) Run() {
 	}
 
 	if startNodeCmd.Parsed() {
-		nodeID := os.Getenv("NODE_ID")
-		if nodeID == "" {
-			startNodeCmd.Usage()
-			os.Exit(1)
-		}
-		cli.startNode(nodeID, *startNodeMiner)
+		cli.startNode(ServerConfig{
+			NodeID:         nodeID,
+			MinerAddress:   *startNodeMiner,
+			RPCAddress:     *startNodeRPC,
+			TxIndex:        *startNodeTxIndex,
+			AddrIndex:      *startNodeAddrIndex,
+			UTXOCacheSize:  *startNodeDBCache << 20,
+			PruneSize:      *startNodePrune << 20,
+			VerifySnapshot: *startNodeVerifySnapshot,
+			MaxInbound:     *startNodeMaxInbound,
+			MaxOutbound:    *startNodeMaxOutbound,
+			ListenAddress:  *startNodeListen,
+			ExternalIP:     *startNodeExternalIP,
+			Connect:        startNodeConnect,
+			AddNode:        startNodeAddNode,
+		})
 	}
 
 	if getBlockchainHeightCmd.Parsed() {
 		cli.getBlockchainHeight(nodeID)
 	}
+
+	if generateCmd.Parsed() {
+		blocks, err := strconv.Atoi(generateBlocks)
+		if err != nil || blocks <= 0 {
+			generateCmd.Usage()
+			os.Exit(1)
+		}
+		cli.generate(blocks, *generateAddress, nodeID)
+	}
+
+	if governanceCmd.Parsed() {
+		if *governanceFrom == "" || (*governanceAdd == "") == (*governanceRemove == "") {
+			governanceCmd.Usage()
+			os.Exit(1)
+		}
+
+		if *governanceAdd != "" {
+			cli.governance(*governanceFrom, *governanceAdd, GovernanceAddValidator, nodeID, *governanceMine)
+		} else {
+			cli.governance(*governanceFrom, *governanceRemove, GovernanceRemoveValidator, nodeID, *governanceMine)
+		}
+	}
+
+	if mineCmd.Parsed() {
+		if *mineAddress == "" {
+			mineCmd.Usage()
+			os.Exit(1)
+		}
+		if *mineRPC == "" {
+			*mineRPC = defaultRPCAddress(nodeID)
+		}
+		cli.mine(*mineAddress, *mineRPC)
+	}
+
+	if getPeerInfoCmd.Parsed() {
+		if *getPeerInfoRPC == "" {
+			*getPeerInfoRPC = defaultRPCAddress(nodeID)
+		}
+		cli.getPeerInfo(*getPeerInfoRPC)
+	}
+
+	if dumpUTXOCmd.Parsed() {
+		if dumpUTXOCmd.NArg() != 1 {
+			dumpUTXOCmd.Usage()
+			os.Exit(1)
+		}
+		cli.dumpUTXO(dumpUTXOCmd.Arg(0), nodeID)
+	}
+
+	if loadUTXOCmd.Parsed() {
+		if loadUTXOCmd.NArg() != 1 {
+			loadUTXOCmd.Usage()
+			os.Exit(1)
+		}
+		cli.loadUTXO(loadUTXOCmd.Arg(0), nodeID)
+	}
+
+	if verifyChainCmd.Parsed() {
+		cli.verifyChain(*verifyChainDepth, *verifyChainLevel, *verifyChainRepair, nodeID)
+	}
+
+	if exportChainCmd.Parsed() {
+		if exportChainFile == "" {
+			exportChainCmd.Usage()
+			os.Exit(1)
+		}
+		cli.exportChain(exportChainFile, *exportChainFrom, *exportChainTo, nodeID)
+	}
+
+	if importChainCmd.Parsed() {
+		if importChainCmd.NArg() != 1 {
+			importChainCmd.Usage()
+			os.Exit(1)
+		}
+		cli.importChain(importChainCmd.Arg(0), nodeID)
+	}
 }
@@ -5,14 +5,20 @@ import (
 	"log"
 )
 
-func (cli *CLI) createBlockchain(address string, nodeID string) {
-	if !ValidateAddress(address) {
+func (cli *CLI) createBlockchain(address string, nodeID string, txIndex, addrIndex bool) {
+	if address != "" && !ValidateAddress(address, cli.params) {
 		log.Panic("Invalid wallet address")
 	}
 
-	bc := CreateBlockchain(address, nodeID)
-	us := UTXOSet{bc}
-	us.Reindex()
-	defer bc.DB.Close()
+	bc := CreateBlockchain(address, nodeID, cli.params)
+	defer bc.Close()
+
+	if txIndex {
+		bc.EnableTxIndex()
+	}
+	if addrIndex {
+		bc.EnableAddrIndex()
+	}
+
 	fmt.Println("Done!")
 }
@@ -0,0 +1,62 @@
+package blockchain
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+)
+
+func (cli *CLI) createGenesis(name, allocFile, message string, timestamp int64, pow string, bits int,
+	port string, addressVersion byte, seeds []string, outFile string) {
+	content, err := ioutil.ReadFile(allocFile)
+	logPanicErr(err)
+
+	// The allocation file maps addresses of the current network to amounts, their keys
+	// have addresses of the new address version on the new network
+	var allocations map[string]int
+	err = json.Unmarshal(content, &allocations)
+	logPanicErr(err)
+
+	if len(allocations) == 0 {
+		log.Panic("ERROR: Allocation file has no outputs")
+	}
+
+	for address, amount := range allocations {
+		if !ValidateAddress(address, cli.params) {
+			log.Panic("ERROR: Invalid allocation address " + address)
+		}
+		if amount <= 0 {
+			log.Panic("ERROR: Invalid allocation amount for " + address)
+		}
+	}
+
+	params := *cli.params
+	params.Name = name
+	params.GenesisCoinbaseData = message
+	params.GenesisTimestamp = timestamp
+	params.PowAlgorithm = pow
+	params.TargetBits = bits
+	params.DBFile = fmt.Sprintf("blockchain_%s_%%s.db", name)
+	params.WalletFile = fmt.Sprintf("wallet_%s_%%s.dat", name)
+
+	// Nodes, addresses and snapshots of the base network are not part of the new one
+	params.DefaultPort = port
+	params.AddressVersion = addressVersion
+	params.Seeds = seeds
+	params.AssumeUTXO = nil
+
+	coinbase := NewAllocationCoinbaseTX(allocations, message)
+	genesis := NewGenesisBlock(coinbase, &params)
+
+	params.GenesisBlock = hex.EncodeToString(genesis.Serialize())
+	params.NetworkMagic = binary.BigEndian.Uint32(genesis.Hash[len(genesis.Hash)-4:])
+
+	err = params.SaveToFile(outFile)
+	logPanicErr(err)
+
+	fmt.Printf("Genesis block: %x\n", genesis.Hash)
+	fmt.Printf("Chain params written to %s\n", outFile)
+}
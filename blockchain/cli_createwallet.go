@@ -5,7 +5,7 @@ import (
 )
 
 func (cli *CLI) createWallet(nodeID string) {
-	wallets, _ := NewWallets(nodeID)
+	wallets, _ := NewWallets(nodeID, cli.params)
 	address := wallets.CreateWallet()
 	wallets.SaveToFile(nodeID)
 
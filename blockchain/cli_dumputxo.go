@@ -0,0 +1,24 @@
+package blockchain
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+func (cli *CLI) dumpUTXO(file, nodeID string) {
+	bc := NewBlockchain(nodeID, cli.params)
+	defer bc.Close()
+
+	f, err := os.Create(file)
+	logPanicErr(err)
+	defer f.Close()
+
+	header, err := bc.DumpUTXOSnapshot(f)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Wrote %d unspent outputs at block %x, height %d\n", header.Outputs, header.BlockHash, header.Height)
+	fmt.Printf("Content hash: %x\n", header.ContentHash)
+}
@@ -0,0 +1,23 @@
+package blockchain
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+func (cli *CLI) exportChain(file string, from, to int, nodeID string) {
+	bc := NewBlockchain(nodeID, cli.params)
+	defer bc.Close()
+
+	f, err := os.Create(file)
+	logPanicErr(err)
+	defer f.Close()
+
+	count, err := bc.ExportChain(f, from, to)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Wrote %d blocks to %s\n", count, file)
+}
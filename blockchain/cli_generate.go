@@ -0,0 +1,60 @@
+package blockchain
+
+import (
+	"fmt"
+	"log"
+	"net/rpc"
+	"sort"
+)
+
+func (cli *CLI) generate(blocks int, address, nodeID string) {
+	if !cli.params.MineOnDemand {
+		log.Panic("ERROR: generate is not available on " + cli.params.Name)
+	}
+
+	if address == "" {
+		wallets, err := NewWallets(nodeID, cli.params)
+		addresses := wallets.GetAddresses()
+		if err != nil || len(addresses) == 0 {
+			log.Panic("ERROR: No wallet address found, create one or pass -address")
+		}
+
+		sort.Strings(addresses)
+		address = addresses[0]
+	}
+
+	if !ValidateAddress(address, cli.params) {
+		log.Panic("ERROR: Address is not valid")
+	}
+
+	// Let a running node mine so the blocks include its mempool
+	client, err := rpc.Dial(protocol, defaultRPCAddress(nodeID))
+	if err == nil {
+		defer client.Close()
+
+		var reply GenerateReply
+		err = client.Call("Node.Generate", &GenerateArgs{blocks, address}, &reply)
+		logPanicErr(err)
+
+		for _, hash := range reply.Hashes {
+			fmt.Printf("%x\n", hash)
+		}
+		return
+	}
+
+	bc := NewBlockchain(nodeID, cli.params)
+	defer bc.Close()
+
+	wallets, err := NewWallets(nodeID, cli.params)
+	if err == nil && wallets.Wallets[address] != nil {
+		bc.SetSigner(wallets.Wallets[address])
+	}
+
+	for i := 0; i < blocks; i++ {
+		cbTx := NewCoinbaseTX(address, "", cli.params.BlockSubsidy(bc.GetBestHeight()+1))
+		newBlock, err := bc.MineBlock([]*Transaction{cbTx})
+		logPanicErr(err)
+
+		fmt.Printf("%x\n", newBlock.Hash)
+	}
+}
@@ -0,0 +1,44 @@
+package blockchain
+
+import (
+	"fmt"
+	"log"
+)
+
+func (cli *CLI) getAddressHistory(address string, nodeID string) {
+	if !ValidateAddress(address, cli.params) {
+		log.Panic("Invalid wallet address")
+	}
+
+	bc := NewBlockchain(nodeID, cli.params)
+	defer bc.Close()
+
+	if !bc.HasAddrIndex() {
+		log.Panic("ERROR: Address index is not enabled, start the node with -addrindex")
+	}
+
+	for _, out := range bc.GetAddressHistory(GetPubKeyHash([]byte(address))) {
+		fmt.Printf("Height %d: %x:%d, value %d", out.Height, out.TxID, out.Vout, out.Value)
+		if out.SpentBy != nil {
+			fmt.Printf(", spent by %x", out.SpentBy)
+		}
+		fmt.Println()
+	}
+}
+
+func (cli *CLI) getAddressUTXOs(address string, nodeID string) {
+	if !ValidateAddress(address, cli.params) {
+		log.Panic("Invalid wallet address")
+	}
+
+	bc := NewBlockchain(nodeID, cli.params)
+	defer bc.Close()
+
+	if !bc.HasAddrIndex() {
+		log.Panic("ERROR: Address index is not enabled, start the node with -addrindex")
+	}
+
+	for _, out := range bc.GetAddressUTXOs(GetPubKeyHash([]byte(address))) {
+		fmt.Printf("Height %d: %x:%d, value %d\n", out.Height, out.TxID, out.Vout, out.Value)
+	}
+}
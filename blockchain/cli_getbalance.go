@@ -6,20 +6,27 @@ import (
 )
 
 func (cli *CLI) getBalance(address string, nodeID string) {
-	if !ValidateAddress(address) {
+	if !ValidateAddress(address, cli.params) {
 		log.Panic("Invalid wallet address")
 	}
 
-	bc := NewBlockchain(nodeID)
+	bc := NewBlockchain(nodeID, cli.params)
 	utxoSet := UTXOSet{bc}
-	defer bc.DB.Close()
+	defer bc.Close()
 
 	balance := 0
 	pubKeyHash := GetPubKeyHash([]byte(address))
 
-	utxos := utxoSet.FindUTXO(pubKeyHash)
-	for _, utxo := range utxos {
-		balance += utxo.Value
+	// The address index avoids scanning the whole UTXO set
+	if bc.HasAddrIndex() {
+		for _, out := range bc.GetAddressUTXOs(pubKeyHash) {
+			balance += out.Value
+		}
+	} else {
+		utxos := utxoSet.FindUTXO(pubKeyHash)
+		for _, utxo := range utxos {
+			balance += utxo.Value
+		}
 	}
 
 	fmt.Printf("Balance of '%s': %d\n", address, balance)
@@ -0,0 +1,103 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+)
+
+// blockJSON is the JSON form of a block printed by getblock
+type blockJSON struct {
+	Hash          string            `json:"hash"`
+	PrevBlockHash string            `json:"prev_block_hash"`
+	Height        int               `json:"height"`
+	Timestamp     int64             `json:"timestamp"`
+	Nonce         int               `json:"nonce"`
+	Signer        string            `json:"signer,omitempty"`
+	Confirmations int               `json:"confirmations"`
+	Transactions  []transactionJSON `json:"transactions"`
+}
+
+type transactionJSON struct {
+	ID   string       `json:"id"`
+	Vin  []inputJSON  `json:"vin"`
+	Vout []outputJSON `json:"vout"`
+}
+
+type outputJSON struct {
+	Value      int    `json:"value"`
+	PubKeyHash string `json:"pubkey_hash"`
+}
+
+type inputJSON struct {
+	TxID   string `json:"txid"`
+	Vout   int    `json:"vout"`
+	PubKey string `json:"pubkey"`
+}
+
+func newBlockJSON(block *Block, confirmations int) blockJSON {
+	result := blockJSON{
+		Hash:          hex.EncodeToString(block.Hash),
+		PrevBlockHash: hex.EncodeToString(block.PrevBlockHash),
+		Height:        block.Height,
+		Timestamp:     block.Timestamp,
+		Nonce:         block.Nonce,
+		Signer:        hex.EncodeToString(block.Signer),
+		Confirmations: confirmations,
+		Transactions:  []transactionJSON{},
+	}
+
+	for _, tx := range block.Transactions {
+		txJSON := transactionJSON{ID: hex.EncodeToString(tx.ID)}
+		for _, in := range tx.Vin {
+			txJSON.Vin = append(txJSON.Vin, inputJSON{hex.EncodeToString(in.TxID), in.Vout, hex.EncodeToString(in.PubKey)})
+		}
+		for _, out := range tx.Vout {
+			txJSON.Vout = append(txJSON.Vout, outputJSON{out.Value, hex.EncodeToString(out.PubKeyHash)})
+		}
+		result.Transactions = append(result.Transactions, txJSON)
+	}
+
+	return result
+}
+
+func (cli *CLI) getBlock(height int, hash string, asJSON bool, nodeID string) {
+	bc := NewBlockchain(nodeID, cli.params)
+	defer bc.Close()
+
+	var block Block
+	var err error
+	if hash != "" {
+		blockHash, decodeErr := hex.DecodeString(hash)
+		if decodeErr != nil {
+			log.Panic("ERROR: Invalid block hash")
+		}
+		block, err = bc.GetBlock(blockHash)
+	} else {
+		block, err = bc.GetBlockByHeight(height)
+	}
+	if err != nil {
+		log.Panic(err)
+	}
+
+	confirmations := bc.confirmations(&block)
+
+	if asJSON {
+		data, err := json.MarshalIndent(newBlockJSON(&block, confirmations), "", "  ")
+		logPanicErr(err)
+		fmt.Println(string(data))
+		return
+	}
+
+	fmt.Printf("============ Block %x ============\n", block.Hash)
+	fmt.Printf("Height: %d\n", block.Height)
+	fmt.Printf("Prev. block: %x\n", block.PrevBlockHash)
+	fmt.Printf("Timestamp: %d\n", block.Timestamp)
+	fmt.Printf("Confirmations: %d\n", confirmations)
+	fmt.Printf("Seal: %s\n\n", strconv.FormatBool(bc.Engine().VerifySeal(&block) == nil))
+	for _, tx := range block.Transactions {
+		fmt.Println(tx)
+	}
+}
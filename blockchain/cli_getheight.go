@@ -3,6 +3,6 @@ package blockchain
 import "fmt"
 
 func (cli *CLI) getBlockchainHeight(nodeID string) {
-	bc := NewBlockchain(nodeID)
+	bc := NewBlockchain(nodeID, cli.params)
 	fmt.Println("Blockchain height:", bc.GetBestHeight())
 }
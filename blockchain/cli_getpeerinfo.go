@@ -0,0 +1,33 @@
+package blockchain
+
+import (
+	"fmt"
+	"net/rpc"
+	"time"
+)
+
+func (cli *CLI) getPeerInfo(rpcAddress string) {
+	client, err := rpc.Dial(protocol, rpcAddress)
+	logPanicErr(err)
+	defer client.Close()
+
+	var reply GetPeerInfoReply
+	err = client.Call("Node.GetPeerInfo", &GetPeerInfoArgs{}, &reply)
+	logPanicErr(err)
+
+	for _, info := range reply.Peers {
+		direction := "outbound"
+		if info.Inbound {
+			direction = "inbound"
+		}
+
+		fmt.Printf("============ Peer %s ============\n", info.Addr)
+		fmt.Printf("Listen address: %s\n", info.ListenAddr)
+		fmt.Printf("Direction: %s\n", direction)
+		fmt.Printf("Version: %d %s, services %d\n", info.Version, info.UserAgent, info.Services)
+		fmt.Printf("Best height: %d\n", info.BestHeight)
+		fmt.Printf("Last seen: %s\n", info.LastSeen.Format(time.RFC3339))
+		fmt.Printf("Bytes in: %d, out: %d\n", info.BytesIn, info.BytesOut)
+		fmt.Println()
+	}
+}
@@ -0,0 +1,27 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+)
+
+func (cli *CLI) getTransaction(txID string, nodeID string) {
+	ID, err := hex.DecodeString(txID)
+	if err != nil {
+		log.Panic("ERROR: Invalid transaction ID")
+	}
+
+	bc := NewBlockchain(nodeID, cli.params)
+	defer bc.Close()
+
+	tx, block, err := bc.GetTransaction(ID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Println(tx)
+	fmt.Printf("Block: %x\n", block.Hash)
+	fmt.Printf("Height: %d\n", block.Height)
+	fmt.Printf("Confirmations: %d\n", bc.GetBestHeight()-block.Height+1)
+}
@@ -0,0 +1,16 @@
+package blockchain
+
+import "fmt"
+
+func (cli *CLI) getTxOutSetInfo(nodeID string) {
+	bc := NewBlockchain(nodeID, cli.params)
+	defer bc.Close()
+
+	info := bc.GetUTXOSetInfo()
+
+	fmt.Printf("Height: %d\n", info.Height)
+	fmt.Printf("Best block: %x\n", info.BestBlock)
+	fmt.Printf("Unspent outputs: %d\n", info.Outputs)
+	fmt.Printf("Total amount: %d\n", info.Amount)
+	fmt.Printf("UTXO set hash: %x\n", info.Hash)
+}
@@ -0,0 +1,45 @@
+package blockchain
+
+import (
+	"fmt"
+	"log"
+)
+
+func (cli *CLI) governance(from, address string, action int, nodeID string, mineNow bool) {
+	if cli.params.Consensus != ConsensusPoA {
+		log.Panic("ERROR: Governance transactions need proof of authority")
+	}
+	if !ValidateAddress(from, cli.params) {
+		log.Panic("ERROR: Validator address is not valid")
+	}
+	if !ValidateAddress(address, cli.params) {
+		log.Panic("ERROR: Address is not valid")
+	}
+
+	bc := NewBlockchain(nodeID, cli.params)
+	defer bc.Close()
+
+	wallets, err := NewWallets(nodeID, cli.params)
+	logPanicErr(err)
+	wallet := wallets.GetWallet(from)
+
+	set, err := bc.validatorSetAt(bc.tipHash())
+	logPanicErr(err)
+
+	tx := NewGovernanceTX(&wallet, action, address, set.nonce)
+	if !bc.VerifyTransaction(tx) {
+		log.Panic("ERROR: " + from + " is not a validator")
+	}
+
+	if mineNow {
+		bc.SetSigner(&wallet)
+
+		cbTx := NewCoinbaseTX(from, "", bc.Params.BlockSubsidy(bc.GetBestHeight()+1))
+		_, err = bc.MineBlock([]*Transaction{cbTx, tx})
+		logPanicErr(err)
+	} else {
+		submitTx(bc, tx)
+	}
+
+	fmt.Println("Success!")
+}
@@ -0,0 +1,37 @@
+package blockchain
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+func (cli *CLI) importChain(file, nodeID string) {
+	f, err := os.Open(file)
+	logPanicErr(err)
+	defer f.Close()
+
+	var bc *Blockchain
+	dbFile := cli.params.dbFile(nodeID)
+	if dbExists(dbFile) {
+		bc = NewBlockchain(nodeID, cli.params)
+	} else {
+		// A fresh node takes its genesis block from the file
+		store, err := OpenBoltStore(dbFile)
+		logPanicErr(err)
+
+		bc, err = ImportGenesis(store, f, cli.params)
+		if err != nil {
+			store.Close()
+			os.Remove(dbFile)
+			log.Panic(err)
+		}
+	}
+	defer bc.Close()
+
+	imported, skipped, err := bc.ImportChain(f)
+	fmt.Printf("Imported %d blocks, skipped %d already in the chain, height %d\n", imported, skipped, bc.GetBestHeight())
+	if err != nil {
+		log.Panic(err)
+	}
+}
@@ -6,7 +6,7 @@ import (
 )
 
 func (cli *CLI) listAddress(nodeID string) {
-	wallets, err := NewWallets(nodeID)
+	wallets, err := NewWallets(nodeID, cli.params)
 	if err != nil {
 		log.Panic(err)
 	}
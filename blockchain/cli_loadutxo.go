@@ -0,0 +1,33 @@
+package blockchain
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+func (cli *CLI) loadUTXO(file, nodeID string) {
+	dbFile := cli.params.dbFile(nodeID)
+	if dbExists(dbFile) {
+		fmt.Println("Blockchain already exists.")
+		os.Exit(1)
+	}
+
+	f, err := os.Open(file)
+	logPanicErr(err)
+	defer f.Close()
+
+	store, err := OpenBoltStore(dbFile)
+	logPanicErr(err)
+
+	bc, err := LoadUTXOSnapshot(store, f, cli.params)
+	if err != nil {
+		store.Close()
+		os.Remove(dbFile)
+		log.Panic(err)
+	}
+	defer bc.Close()
+
+	header, _ := bc.UTXOSnapshot()
+	fmt.Printf("Loaded %d unspent outputs at block %x, height %d\n", header.Outputs, header.BlockHash, header.Height)
+}
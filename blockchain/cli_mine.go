@@ -0,0 +1,48 @@
+package blockchain
+
+import (
+	"fmt"
+	"log"
+	"net/rpc"
+)
+
+func (cli *CLI) mine(address, rpcAddress string) {
+	if !ValidateAddress(address, cli.params) {
+		log.Panic("Wrong miner address!")
+	}
+
+	client, err := rpc.Dial(protocol, rpcAddress)
+	logPanicErr(err)
+	defer client.Close()
+
+	fmt.Printf("Mining against %s. Address to receive rewards: %s\n", rpcAddress, address)
+
+	for {
+		var template BlockTemplate
+		err := client.Call("Node.GetBlockTemplate", &GetBlockTemplateArgs{}, &template)
+		logPanicErr(err)
+
+		if template.PowAlgorithm != cli.params.PowAlgorithm || template.TargetBits != cli.params.TargetBits {
+			log.Panic("ERROR: Node is not on the " + cli.params.Name + " network")
+		}
+
+		fmt.Printf("Mining block %d with %d transactions\n", template.Height, len(template.Transactions))
+
+		coinbase := NewCoinbaseTX(address, template.CoinbaseData, template.CoinbaseValue)
+		block := template.NewBlock(coinbase)
+
+		pow := NewProofOfWork(block, cli.params)
+		nonce, hash := pow.Solve()
+		block.Hash = hash
+		block.Nonce = nonce
+
+		var reply SubmitBlockReply
+		err = client.Call("Node.SubmitBlock", &SubmitBlockArgs{block.Serialize()}, &reply)
+		if err != nil {
+			fmt.Printf("Block %x rejected: %s\n", block.Hash, err)
+			continue
+		}
+
+		fmt.Printf("Block %x accepted at height %d\n", reply.Hash, reply.Height)
+	}
+}
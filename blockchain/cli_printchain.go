@@ -6,7 +6,7 @@ import (
 )
 
 func (cli *CLI) printChain(nodeID string) {
-	bc := NewBlockchain(nodeID)
+	bc := NewBlockchain(nodeID, cli.params)
 	bci := bc.Iterator()
 
 	for {
@@ -15,8 +15,11 @@ func (cli *CLI) printChain(nodeID string) {
 		fmt.Printf("============ Block %x ============\n", block.Hash)
 		fmt.Printf("Height: %d\n", block.Height)
 		fmt.Printf("Prev. block: %x\n", block.PrevBlockHash)
-		pow := NewProofOfWork(block)
-		fmt.Printf("PoW: %s\n\n", strconv.FormatBool(pow.Validate()))
+		if block.IsPruned() {
+			fmt.Printf("Pruned: true\n\n")
+		} else {
+			fmt.Printf("Seal: %s\n\n", strconv.FormatBool(bc.Engine().VerifySeal(block) == nil))
+		}
 		for _, tx := range block.Transactions {
 			fmt.Println(tx)
 		}
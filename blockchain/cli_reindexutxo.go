@@ -4,12 +4,13 @@ import "fmt"
 
 func (cli *CLI) reindexUTXO(nodeID string) {
 
-	bc := NewBlockchain(nodeID)
+	bc := NewBlockchain(nodeID, cli.params)
 	utxoSet := UTXOSet{bc}
-	defer bc.DB.Close()
+	defer bc.Close()
 
 	utxoSet.Reindex()
 
 	count := utxoSet.CountTransactions()
-	fmt.Printf("Done! There are %d transactions in the UTXO set.\n", count)
+	outputs := utxoSet.CountOutputs()
+	fmt.Printf("Done! There are %d unspent outputs of %d transactions in the UTXO set.\n", outputs, count)
 }
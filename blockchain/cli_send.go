@@ -6,30 +6,32 @@ import (
 )
 
 func (cli *CLI) send(from, to string, amount int, nodeID string, mineNow bool) {
-	if !ValidateAddress(from) {
+	if !ValidateAddress(from, cli.params) {
 		log.Panic("ERROR: Sender address is not valid")
 	}
-	if !ValidateAddress(to) {
+	if !ValidateAddress(to, cli.params) {
 		log.Panic("ERROR: Recipient address is not valid")
 	}
 
-	bc := NewBlockchain(nodeID)
+	bc := NewBlockchain(nodeID, cli.params)
 	utxoSet := UTXOSet{bc}
-	defer bc.DB.Close()
+	defer bc.Close()
 
-	wallets, err := NewWallets(nodeID)
+	wallets, err := NewWallets(nodeID, cli.params)
 	logPanicErr(err)
 	wallet := wallets.GetWallet(from)
 
 	tx := NewUTXOTransaction(&wallet, to, amount, &utxoSet)
 
 	if mineNow {
+		bc.SetSigner(&wallet)
+
 		// Give reward to the mining
-		cbTx := NewCoinbaseTX(from, "")
-		newBlock := bc.MineBlock([]*Transaction{cbTx, tx})
-		utxoSet.Update(newBlock)
+		cbTx := NewCoinbaseTX(from, "", bc.Params.BlockSubsidy(bc.GetBestHeight()+1))
+		_, err = bc.MineBlock([]*Transaction{cbTx, tx})
+		logPanicErr(err)
 	} else {
-		sendTx(knownNodes[0], tx)
+		submitTx(bc, tx)
 	}
 
 	fmt.Println("Success!")
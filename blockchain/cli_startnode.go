@@ -5,14 +5,17 @@ import (
 	"log"
 )
 
-func (cli *CLI) startNode(nodeID string, minerAddress string) {
-	fmt.Printf("Starting node %s\n", nodeID)
-	if len(minerAddress) > 0 {
-		if ValidateAddress(minerAddress) {
-			fmt.Println("Mining is on. Address to receive rewards: ", minerAddress)
+func (cli *CLI) startNode(config ServerConfig) {
+	fmt.Printf("Starting node %s\n", config.NodeID)
+	if len(config.MinerAddress) > 0 {
+		if ValidateAddress(config.MinerAddress, cli.params) {
+			fmt.Println("Mining is on. Address to receive rewards: ", config.MinerAddress)
 		} else {
 			log.Panic("Wrong miner address!")
 		}
 	}
-	StartServer(nodeID, minerAddress)
+	if config.RPCAddress == "" {
+		config.RPCAddress = defaultRPCAddress(config.NodeID)
+	}
+	StartServer(config, cli.params)
 }
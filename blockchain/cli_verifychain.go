@@ -0,0 +1,40 @@
+package blockchain
+
+import (
+	"fmt"
+	"log"
+)
+
+func (cli *CLI) verifyChain(depth, level int, repair bool, nodeID string) {
+	if level < VerifyLinks || level > VerifyUTXOSet {
+		log.Panic("ERROR: Level must be between 0 and 4")
+	}
+
+	bc := NewBlockchain(nodeID, cli.params)
+	defer bc.Close()
+
+	if depth > 0 {
+		fmt.Printf("Verifying the last %d blocks at level %d\n", depth, level)
+	} else {
+		fmt.Printf("Verifying every block at level %d\n", level)
+	}
+
+	err := bc.VerifyChain(depth, level)
+	if err == nil {
+		fmt.Println("No inconsistencies found")
+		return
+	}
+	fmt.Println(err)
+
+	verifyErr, ok := err.(*ChainVerifyError)
+	if !repair || !ok {
+		return
+	}
+
+	err = bc.RepairChain(verifyErr)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Repaired, the tip is block %x at height %d\n", bc.tipHash(), bc.GetBestHeight())
+}
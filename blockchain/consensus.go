@@ -0,0 +1,70 @@
+package blockchain
+
+import (
+	"errors"
+	"log"
+)
+
+// Consensus engines selectable through ChainParams.Consensus
+const (
+	ConsensusPoW = "pow"
+	ConsensusPoA = "poa"
+)
+
+// Engine seals new blocks and verifies the seal of blocks
+type Engine interface {
+	// Seal makes the block valid under the consensus rules and sets its hash
+	Seal(b *Block) error
+
+	// VerifySeal checks the hash and seal of the block
+	VerifySeal(b *Block) error
+}
+
+// PoWEngine seals blocks by solving the proof of work of the network
+type PoWEngine struct {
+	params *ChainParams
+}
+
+// NewPoWEngine initializes and returns a proof of work engine
+func NewPoWEngine(params *ChainParams) *PoWEngine {
+	return &PoWEngine{params}
+}
+
+// Seal solves the proof of work and sets the nonce and hash of the block
+func (e *PoWEngine) Seal(b *Block) error {
+	pow := NewProofOfWork(b, e.params)
+	nonce, hash := pow.Solve()
+
+	b.Hash = hash[:]
+	b.Nonce = nonce
+
+	return nil
+}
+
+// VerifySeal checks the proof of work of the block
+func (e *PoWEngine) VerifySeal(b *Block) error {
+	pow := NewProofOfWork(b, e.params)
+	if !pow.Verify() {
+		return errors.New("Block has invalid proof of work")
+	}
+
+	return nil
+}
+
+// Engine returns the consensus engine of the blockchain
+func (bc *Blockchain) Engine() Engine {
+	switch bc.Params.Consensus {
+	case "", ConsensusPoW:
+		return NewPoWEngine(bc.Params)
+	case ConsensusPoA:
+		return NewPoAEngine(bc, bc.signer)
+	}
+
+	log.Panic("ERROR: Unknown consensus engine " + bc.Params.Consensus)
+	return nil
+}
+
+// SetSigner sets the validator wallet used to seal blocks under proof of authority
+func (bc *Blockchain) SetSigner(wallet *Wallet) {
+	bc.signer = wallet
+}
@@ -0,0 +1,159 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// PoAEngine seals blocks by signing them with the key of the
+// authorized validator whose turn it is, in round-robin order
+type PoAEngine struct {
+	bc     *Blockchain
+	signer *Wallet
+}
+
+// NewPoAEngine initializes and returns a proof of authority engine, signer may be nil to only verify
+func NewPoAEngine(bc *Blockchain, signer *Wallet) *PoAEngine {
+	return &PoAEngine{bc, signer}
+}
+
+// Seal signs the block with the validator key
+func (e *PoAEngine) Seal(b *Block) error {
+	if e.signer == nil {
+		return errors.New("No validator key to sign the block")
+	}
+
+	validator, err := e.inTurnValidator(b)
+	if err != nil {
+		return err
+	}
+
+	if bytes.Compare(HashPubKey(e.signer.PublicKey), validator) != 0 {
+		return fmt.Errorf("Validator is not in turn for block %d", b.Height)
+	}
+
+	b.Signer = e.signer.PublicKey
+	b.Hash = poaHeaderHash(b)
+	b.Signature = signHash(e.signer.PrivateKey, b.Hash)
+
+	return nil
+}
+
+// InTurn reports whether the signer is the validator in turn for the block after the tip
+func (e *PoAEngine) InTurn() bool {
+	if e.signer == nil {
+		return false
+	}
+
+	validators, err := e.bc.validatorsAt(e.bc.tipHash())
+	if err != nil {
+		return false
+	}
+
+	height := e.bc.GetBestHeight() + 1
+	return bytes.Compare(HashPubKey(e.signer.PublicKey), validators[height%len(validators)]) == 0
+}
+
+// VerifySeal checks the block is signed by the validator in turn
+func (e *PoAEngine) VerifySeal(b *Block) error {
+	// The genesis block is fixed by the network, not signed
+	if b.Height == 0 {
+		return nil
+	}
+
+	validator, err := e.inTurnValidator(b)
+	if err != nil {
+		return err
+	}
+
+	if bytes.Compare(HashPubKey(b.Signer), validator) != 0 {
+		return fmt.Errorf("Block %d is not signed by the validator in turn", b.Height)
+	}
+
+	if bytes.Compare(poaHeaderHash(b), b.Hash) != 0 {
+		return errors.New("Block hash does not match its header")
+	}
+
+	if !verifyHash(b.Signer, b.Hash, b.Signature) {
+		return errors.New("Block has invalid validator signature")
+	}
+
+	return nil
+}
+
+// inTurnValidator returns the pubkey hash of the validator expected to sign the block
+func (e *PoAEngine) inTurnValidator(b *Block) ([]byte, error) {
+	validators, err := e.bc.validatorsAt(b.PrevBlockHash)
+	if err != nil {
+		return nil, err
+	}
+
+	return validators[b.Height%len(validators)], nil
+}
+
+// poaHeaderHash hashes the block header including the signer
+func poaHeaderHash(b *Block) []byte {
+	data := bytes.Join(
+		[][]byte{
+			b.PrevBlockHash,
+			b.HashTransactions(),
+			IntToHex(b.Timestamp),
+			IntToHex(int64(b.Height)),
+			b.Signer,
+		},
+		[]byte{},
+	)
+
+	return sha256Hash(data)
+}
+
+// signHash signs the hash, r and s are padded to the curve size
+func signHash(privateKey ecdsa.PrivateKey, hash []byte) []byte {
+	r, s, err := ecdsa.Sign(rand.Reader, &privateKey, hash)
+	logPanicErr(err)
+
+	return padCurvePoints(privateKey.Curve, r, s)
+}
+
+// padCurvePoints concatenates the numbers, each padded to the curve size, so that
+// signatures and public keys split back into their halves
+func padCurvePoints(curve elliptic.Curve, a, b *big.Int) []byte {
+	size := (curve.Params().BitSize + 7) / 8
+	data := make([]byte, 2*size)
+	a.FillBytes(data[:size])
+	b.FillBytes(data[size:])
+
+	return data
+}
+
+// verifyHash verifies a signature made with signHash
+func verifyHash(pubKey, hash, signature []byte) bool {
+	if len(pubKey) == 0 || len(signature) == 0 {
+		return false
+	}
+
+	r := big.Int{}
+	s := big.Int{}
+	sigLen := len(signature)
+	r.SetBytes(signature[:(sigLen / 2)])
+	s.SetBytes(signature[(sigLen / 2):])
+
+	x := big.Int{}
+	y := big.Int{}
+	keyLen := len(pubKey)
+	x.SetBytes(pubKey[:(keyLen / 2)])
+	y.SetBytes(pubKey[(keyLen / 2):])
+
+	rawPubKey := ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     &x,
+		Y:     &y,
+	}
+
+	return ecdsa.Verify(&rawPubKey, hash, &r, &s)
+}
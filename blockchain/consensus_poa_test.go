@@ -0,0 +1,59 @@
+package blockchain
+
+import (
+	"testing"
+)
+
+// newTestPoAChain creates a proof of authority blockchain in memory with the validators
+func newTestPoAChain(t *testing.T, validators ...*Wallet) *Blockchain {
+	t.Helper()
+
+	params := RegTestParams
+	params.Consensus = ConsensusPoA
+	params.Validators = nil
+	for _, validator := range validators {
+		params.Validators = append(params.Validators, string(validator.GetAddress(&params)))
+	}
+
+	return CreateBlockchainWithStore(NewMemoryStore(), params.Validators[0], &params)
+}
+
+func TestPoASeal(t *testing.T) {
+	first, second := NewWallet(), NewWallet()
+	bc := newTestPoAChain(t, first, second)
+
+	tests := []struct {
+		name   string
+		signer *Wallet
+		height int
+		sealed bool
+	}{
+		{"no signer", nil, 0, false},
+		{"not in turn", first, 0, false},
+		{"in turn", second, 1, true},
+		{"next turn", first, 2, true},
+		{"same signer again", first, 2, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bc.SetSigner(test.signer)
+
+			block, err := bc.MineBlock([]*Transaction{NewCoinbaseTX(bc.Params.Validators[0], "", bc.Params.BlockSubsidy(bc.GetBestHeight()+1))})
+			if test.sealed && err != nil {
+				t.Fatal(err)
+			}
+			if !test.sealed && (err == nil || block != nil) {
+				t.Fatal("Block is sealed by a validator not in turn")
+			}
+			if bc.GetBestHeight() != test.height {
+				t.Errorf("Chain is at height %d, expected %d", bc.GetBestHeight(), test.height)
+			}
+			if test.sealed {
+				if err := bc.Engine().VerifySeal(block); err != nil {
+					t.Error(err)
+				}
+			}
+		})
+	}
+}
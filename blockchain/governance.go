@@ -0,0 +1,233 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// governanceVout marks the single input of a governance transaction
+const governanceVout = -2
+
+// Governance actions on the proof of authority validator set
+const (
+	GovernanceAddValidator    = 1
+	GovernanceRemoveValidator = 2
+)
+
+// NewGovernanceTX creates a transaction that adds or removes the validator with
+// the address. It is signed by a current validator and carries the action, the nonce
+// and the validator pubkey hash in its only output, which holds no value. The nonce is
+// the number of governance transactions applied before it, so it is applied once and
+// the same change can be made again later with the next nonce.
+//
+// A single validator adds or removes any other validator, there is no quorum. Every
+// validator is trusted with the whole set, one of them can remove all the others.
+func NewGovernanceTX(validator *Wallet, action int, address string, nonce int) *Transaction {
+	txin := TXInput{
+		TxID:      []byte{},
+		Vout:      governanceVout,
+		PubKey:    validator.PublicKey,
+		Signature: nil,
+	}
+	data := make([]byte, 9)
+	data[0] = byte(action)
+	binary.BigEndian.PutUint64(data[1:], uint64(nonce))
+
+	txout := TXOutput{
+		Value:      0,
+		PubKeyHash: append(data, GetPubKeyHash([]byte(address))...),
+	}
+
+	tx := Transaction{nil, []TXInput{txin}, []TXOutput{txout}}
+	tx.ID = tx.Hash()
+	tx.Vin[0].Signature = signHash(validator.PrivateKey, tx.ID)
+
+	return &tx
+}
+
+// IsGovernance returns whether the transaction changes the validator set
+func (tx Transaction) IsGovernance() bool {
+	return len(tx.Vin) == 1 && len(tx.Vin[0].TxID) == 0 && tx.Vin[0].Vout == governanceVout
+}
+
+// governanceAction returns the action, the nonce and the pubkey hash of the validator it applies to
+func (tx Transaction) governanceAction() (int, int, []byte) {
+	if len(tx.Vout) != 1 || len(tx.Vout[0].PubKeyHash) < 10 {
+		return 0, 0, nil
+	}
+
+	data := tx.Vout[0].PubKeyHash
+	return int(data[0]), int(binary.BigEndian.Uint64(data[1:9])), data[9:]
+}
+
+// verifyGovernance checks the governance transaction is well formed, signed by one of the
+// validators of the set and has the nonce of the set, so it can not be replayed
+func (tx *Transaction) verifyGovernance(set *validatorSet) bool {
+	action, nonce, subject := tx.governanceAction()
+	if action != GovernanceAddValidator && action != GovernanceRemoveValidator {
+		return false
+	}
+
+	if subject == nil || tx.Vout[0].Value != 0 {
+		return false
+	}
+
+	in := tx.Vin[0]
+	if indexOfHash(set.validators, HashPubKey(in.PubKey)) < 0 {
+		return false
+	}
+
+	if bytes.Compare(tx.ID, tx.unsignedHash()) != 0 {
+		return false
+	}
+
+	if nonce != set.nonce {
+		return false
+	}
+
+	return verifyHash(in.PubKey, tx.ID, in.Signature)
+}
+
+// applyGovernance returns the validator set after the governance transaction.
+// The last validator is never removed.
+func applyGovernance(validators [][]byte, tx *Transaction) [][]byte {
+	action, _, subject := tx.governanceAction()
+	i := indexOfHash(validators, subject)
+
+	switch {
+	case action == GovernanceAddValidator && i < 0:
+		validators = append(validators, subject)
+	case action == GovernanceRemoveValidator && i >= 0 && len(validators) > 1:
+		validators = append(validators[:i:i], validators[i+1:]...)
+	}
+
+	return validators
+}
+
+// validatorSet is the validator set after a block and the number of governance
+// transactions applied up to it, the nonce of the next one. Sets are shared between
+// blocks and never modified.
+type validatorSet struct {
+	validators [][]byte
+	nonce      int
+}
+
+// apply returns the set after the governance transactions of the block, the set itself when there are none
+func (s *validatorSet) apply(block *Block) *validatorSet {
+	next := s
+
+	for _, tx := range block.Transactions {
+		if !tx.IsGovernance() {
+			continue
+		}
+
+		if next == s {
+			next = &validatorSet{append([][]byte{}, s.validators...), s.nonce}
+		}
+
+		next.validators = applyGovernance(next.validators, tx)
+		next.nonce++
+	}
+
+	return next
+}
+
+// validatorsAt returns the validators after the block with the hash
+func (bc *Blockchain) validatorsAt(blockHash []byte) ([][]byte, error) {
+	set, err := bc.validatorSetAt(blockHash)
+	if err != nil {
+		return nil, err
+	}
+
+	return set.validators, nil
+}
+
+// validatorSetAt returns the validator set after the block with the hash, see validatorSetIn
+func (bc *Blockchain) validatorSetAt(blockHash []byte) (*validatorSet, error) {
+	var set *validatorSet
+
+	err := bc.store.View(func(tx StoreTx) error {
+		var err error
+		set, err = bc.validatorSetIn(tx, blockHash)
+		return err
+	})
+
+	return set, err
+}
+
+// validatorSetIn returns the validator set after the block with the hash, replaying the
+// governance transactions since the closest block with a cached set, or on top of the
+// validators in the params. The sets of the replayed blocks are cached by block hash.
+func (bc *Blockchain) validatorSetIn(tx StoreTx, blockHash []byte) (*validatorSet, error) {
+	var blocks []*Block
+	var set *validatorSet
+
+	hash := blockHash
+	for set == nil {
+		if len(hash) == 0 {
+			set = &validatorSet{}
+			for _, address := range bc.Params.Validators {
+				set.validators = append(set.validators, GetPubKeyHash([]byte(address)))
+			}
+			break
+		}
+
+		set = bc.cachedValidatorSet(hash)
+		if set != nil {
+			break
+		}
+
+		blockData := tx.Bucket(blocksBucketName).Get(hash)
+		if blockData == nil {
+			return nil, fmt.Errorf("Block %x is not found", hash)
+		}
+		block, err := DeserializeBlock(blockData)
+		if err != nil {
+			return nil, err
+		}
+
+		blocks = append(blocks, block)
+		hash = block.PrevBlockHash
+	}
+
+	for i := len(blocks) - 1; i >= 0; i-- {
+		set = set.apply(blocks[i])
+		bc.cacheValidatorSet(blocks[i].Hash, set)
+	}
+
+	if len(set.validators) == 0 {
+		return nil, errors.New("Network has no validators")
+	}
+
+	return set, nil
+}
+
+func (bc *Blockchain) cachedValidatorSet(blockHash []byte) *validatorSet {
+	bc.validatorSetsLock.Lock()
+	defer bc.validatorSetsLock.Unlock()
+
+	return bc.validatorSets[hex.EncodeToString(blockHash)]
+}
+
+func (bc *Blockchain) cacheValidatorSet(blockHash []byte, set *validatorSet) {
+	bc.validatorSetsLock.Lock()
+	defer bc.validatorSetsLock.Unlock()
+
+	if bc.validatorSets == nil {
+		bc.validatorSets = make(map[string]*validatorSet)
+	}
+	bc.validatorSets[hex.EncodeToString(blockHash)] = set
+}
+
+func indexOfHash(hashes [][]byte, hash []byte) int {
+	for i, h := range hashes {
+		if bytes.Compare(h, hash) == 0 {
+			return i
+		}
+	}
+
+	return -1
+}
@@ -0,0 +1,134 @@
+package blockchain
+
+import (
+	"bytes"
+	"testing"
+)
+
+// setSignerInTurn sets the wallet of the validator in turn for the next block as the signer
+func setSignerInTurn(t *testing.T, bc *Blockchain, wallets ...*Wallet) {
+	t.Helper()
+
+	validators, err := bc.validatorsAt(bc.tipHash())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inTurn := validators[(bc.GetBestHeight()+1)%len(validators)]
+	for _, wallet := range wallets {
+		if bytes.Equal(HashPubKey(wallet.PublicKey), inTurn) {
+			bc.SetSigner(wallet)
+			return
+		}
+	}
+	t.Fatal("No wallet of the validator in turn")
+}
+
+func TestGovernanceReplay(t *testing.T) {
+	first, second, third := NewWallet(), NewWallet(), NewWallet()
+	bc := newTestPoAChain(t, first, second)
+	thirdAddress := string(third.GetAddress(bc.Params))
+	coinbase := func() *Transaction {
+		return NewCoinbaseTX(bc.Params.Validators[0], "", bc.Params.BlockSubsidy(bc.GetBestHeight()+1))
+	}
+	mine := func(txs ...*Transaction) {
+		t.Helper()
+		setSignerInTurn(t, bc, first, second, third)
+		_, err := bc.MineBlock(append([]*Transaction{coinbase()}, txs...))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	validators := func() [][]byte {
+		validators, err := bc.validatorsAt(bc.tipHash())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return validators
+	}
+
+	add := NewGovernanceTX(first, GovernanceAddValidator, thirdAddress, 0)
+	if !bc.VerifyTransaction(add) {
+		t.Fatal("Governance transaction is not valid")
+	}
+	mine(add)
+	if len(validators()) != 3 {
+		t.Fatalf("Chain has %d validators, expected 3", len(validators()))
+	}
+
+	remove := NewGovernanceTX(first, GovernanceRemoveValidator, string(second.GetAddress(bc.Params)), 1)
+	tests := []struct {
+		name string
+		txs  []*Transaction
+	}{
+		{"replayed", []*Transaction{add}},
+		{"stale nonce", []*Transaction{NewGovernanceTX(first, GovernanceRemoveValidator, thirdAddress, 0)}},
+		{"future nonce", []*Transaction{NewGovernanceTX(first, GovernanceRemoveValidator, thirdAddress, 2)}},
+		{"twice in block", []*Transaction{remove, remove}},
+		{"two in block", []*Transaction{remove, NewGovernanceTX(first, GovernanceRemoveValidator, thirdAddress, 2)}},
+		{"not a validator", []*Transaction{NewGovernanceTX(NewWallet(), GovernanceRemoveValidator, thirdAddress, 1)}},
+	}
+
+	setSignerInTurn(t, bc, first, second, third)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			block, err := NewBlock(append([]*Transaction{coinbase()}, test.txs...), bc.tipHash(), bc.GetBestHeight()+1, bc.Engine())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if bc.ValidateBlock(block) == nil {
+				t.Error("Block with the governance transactions is valid")
+			}
+		})
+	}
+
+	// A removed validator is added again with a later nonce
+	mine(NewGovernanceTX(first, GovernanceRemoveValidator, thirdAddress, 1))
+	if len(validators()) != 2 {
+		t.Fatalf("Chain has %d validators after the removal, expected 2", len(validators()))
+	}
+	mine(NewGovernanceTX(first, GovernanceAddValidator, thirdAddress, 2))
+	if indexOfHash(validators(), HashPubKey(third.PublicKey)) < 0 {
+		t.Error("Removed validator is not added again")
+	}
+}
+
+func TestValidatorSetCache(t *testing.T) {
+	first, second, third := NewWallet(), NewWallet(), NewWallet()
+	bc := newTestPoAChain(t, first, second)
+	coinbase := func() *Transaction {
+		return NewCoinbaseTX(bc.Params.Validators[0], "", bc.Params.BlockSubsidy(bc.GetBestHeight()+1))
+	}
+
+	bc.SetSigner(second)
+	add := NewGovernanceTX(first, GovernanceAddValidator, string(third.GetAddress(bc.Params)), 0)
+	changed, err := bc.MineBlock([]*Transaction{coinbase(), add})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc.SetSigner(third)
+	unchanged, err := bc.MineBlock([]*Transaction{coinbase()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	set, err := bc.validatorSetAt(unchanged.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every block of the chain has a cached set, shared by blocks without governance transactions
+	genesis := bc.cachedValidatorSet(changed.PrevBlockHash)
+	if genesis == nil || len(genesis.validators) != 2 {
+		t.Fatal("Genesis block has no cached set of 2 validators")
+	}
+	if bc.cachedValidatorSet(changed.Hash) != set || len(set.validators) != 3 || set.nonce != 1 {
+		t.Error("Block without governance transactions does not share the set of its parent")
+	}
+
+	again, err := bc.validatorSetAt(unchanged.Hash)
+	if err != nil || again != set {
+		t.Error("Validator set is not taken from the cache")
+	}
+}
@@ -0,0 +1,147 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log"
+)
+
+const heightIndexBucket = "heightindex"
+
+var heightIndexBucketName = []byte(heightIndexBucket)
+
+// heightKey encodes a height so that keys sort in height order
+func heightKey(height int) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(height))
+
+	return key
+}
+
+// GetBlockHash returns the hash of the main chain block at the height
+func (bc *Blockchain) GetBlockHash(height int) ([]byte, error) {
+	var hash []byte
+
+	err := bc.store.View(func(tx StoreTx) error {
+		hash = tx.Bucket(heightIndexBucketName).Get(heightKey(height))
+		if hash == nil {
+			return fmt.Errorf("No block at height %d", height)
+		}
+
+		hash = append([]byte{}, hash...)
+		return nil
+	})
+
+	return hash, err
+}
+
+// IsMainChain returns whether the block with the hash is on the main chain
+func (bc *Blockchain) IsMainChain(hash []byte) bool {
+	block, err := bc.GetBlock(hash)
+	if err != nil {
+		return false
+	}
+
+	mainHash, err := bc.GetBlockHash(block.Height)
+	return err == nil && bytes.Compare(mainHash, hash) == 0
+}
+
+// GetBlockByHeight returns the main chain block at the height
+func (bc *Blockchain) GetBlockByHeight(height int) (Block, error) {
+	hash, err := bc.GetBlockHash(height)
+	if err != nil {
+		return Block{}, err
+	}
+
+	return bc.GetBlock(hash)
+}
+
+// confirmations returns the number of main chain blocks from the block to the tip,
+// 0 for blocks off the main chain
+func (bc *Blockchain) confirmations(block *Block) int {
+	mainHash, err := bc.GetBlockHash(block.Height)
+	if err != nil || bytes.Compare(mainHash, block.Hash) != 0 {
+		return 0
+	}
+
+	return bc.GetBestHeight() - block.Height + 1
+}
+
+// blockLocator returns main chain hashes from the tip down to the genesis block, every
+// block for the first ten and then doubling the step, so that a peer finds the fork
+// point of a long chain in a few hashes
+func (bc *Blockchain) blockLocator() [][]byte {
+	var locator [][]byte
+
+	err := bc.store.View(func(tx StoreTx) error {
+		heights := tx.Bucket(heightIndexBucketName)
+		tip, err := DeserializeBlock(tx.Bucket(blocksBucketName).Get(bc.tipHash()))
+		if err != nil {
+			return err
+		}
+
+		step := 1
+		for height := tip.Height; height > 0; height -= step {
+			locator = append(locator, append([]byte{}, heights.Get(heightKey(height))...))
+			if len(locator) >= 10 {
+				step *= 2
+			}
+		}
+		locator = append(locator, append([]byte{}, heights.Get(heightKey(0))...))
+
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return locator
+}
+
+// blocksAfterLocator returns up to max main chain hashes following the first locator hash
+// on the main chain, from the highest down. Without a known hash they start at the genesis block.
+func (bc *Blockchain) blocksAfterLocator(locator [][]byte, max int) [][]byte {
+	var hashes [][]byte
+
+	err := bc.store.View(func(tx StoreTx) error {
+		b := tx.Bucket(blocksBucketName)
+		heights := tx.Bucket(heightIndexBucketName)
+
+		start := 0
+		for _, hash := range locator {
+			blockData := b.Get(hash)
+			if blockData == nil {
+				continue
+			}
+			block, err := DeserializeBlock(blockData)
+			if err != nil {
+				return err
+			}
+			if bytes.Compare(heights.Get(heightKey(block.Height)), hash) == 0 {
+				start = block.Height + 1
+				break
+			}
+		}
+
+		tip, err := DeserializeBlock(b.Get(bc.tipHash()))
+		if err != nil {
+			return err
+		}
+		end := tip.Height
+		if end >= start+max {
+			end = start + max - 1
+		}
+
+		for height := end; height >= start; height-- {
+			hashes = append(hashes, append([]byte{}, heights.Get(heightKey(height))...))
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return hashes
+}
@@ -0,0 +1,133 @@
+package blockchain
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestHeightIndex(t *testing.T) {
+	bc, wallet := newTestBlockchain(t)
+	mineBlocks(bc, wallet, 2)
+	fork, _ := bc.GetBlockByHeight(1)
+	stale, _ := bc.GetBlockByHeight(2)
+
+	// A longer branch from height 1 replaces the block at height 2
+	var branch []*Block
+	parent := &fork
+	for height := 2; height <= 3; height++ {
+		block, err := NewBlock([]*Transaction{newTestCoinbase(bc, wallet)}, parent.Hash, height, bc.Engine())
+		logPanicErr(err)
+		bc.AddBlock(block)
+		branch = append(branch, block)
+		parent = block
+	}
+
+	tests := []struct {
+		name          string
+		height        int
+		hash          []byte
+		confirmations int
+	}{
+		{"fork", 1, fork.Hash, 3},
+		{"replaced", 2, branch[0].Hash, 2},
+		{"tip", 3, branch[1].Hash, 1},
+		{"above the tip", 4, nil, 0},
+		{"negative", -1, nil, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hash, err := bc.GetBlockHash(test.height)
+			if test.hash == nil {
+				if err == nil {
+					t.Errorf("Block %x at height %d", hash, test.height)
+				}
+				return
+			}
+			if err != nil || bytes.Compare(hash, test.hash) != 0 {
+				t.Fatalf("Block %x at height %d, expected %x (%v)", hash, test.height, test.hash, err)
+			}
+
+			block, err := bc.GetBlockByHeight(test.height)
+			if err != nil || bytes.Compare(block.Hash, test.hash) != 0 || block.Height != test.height {
+				t.Fatalf("Block by height is %x at height %d, expected %x", block.Hash, block.Height, test.hash)
+			}
+			if !bc.IsMainChain(block.Hash) {
+				t.Error("Block is not on the main chain")
+			}
+			if confirmations := bc.confirmations(&block); confirmations != test.confirmations {
+				t.Errorf("Block has %d confirmations, expected %d", confirmations, test.confirmations)
+			}
+		})
+	}
+
+	// The replaced block is still found by hash, off the main chain
+	block, err := bc.GetBlock(stale.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bc.IsMainChain(block.Hash) || bc.confirmations(&block) != 0 {
+		t.Error("Replaced block is on the main chain")
+	}
+}
+
+func TestBlockLocator(t *testing.T) {
+	bc, wallet := newTestBlockchain(t)
+	mineBlocks(bc, wallet, 30)
+
+	expected := []int{30, 29, 28, 27, 26, 25, 24, 23, 22, 21, 19, 15, 7, 0}
+	locator := bc.blockLocator()
+	if len(locator) != len(expected) {
+		t.Fatalf("Locator has %d hashes, expected %d", len(locator), len(expected))
+	}
+
+	for i, height := range expected {
+		hash, _ := bc.GetBlockHash(height)
+		if bytes.Compare(locator[i], hash) != 0 {
+			t.Errorf("Locator hash %d is %x, expected block %x at height %d", i, locator[i], hash, height)
+		}
+	}
+}
+
+func TestBlocksAfterLocator(t *testing.T) {
+	bc, wallet := newTestBlockchain(t)
+	mineBlocks(bc, wallet, 30)
+	fork, _ := bc.GetBlockHash(1)
+	middle, _ := bc.GetBlockHash(20)
+
+	side, err := NewBlock([]*Transaction{newTestCoinbase(bc, wallet)}, fork, 2, bc.Engine())
+	logPanicErr(err)
+	bc.AddBlock(side)
+
+	// Hashes are listed from the first height down to the last, none when the last is higher
+	tests := []struct {
+		name    string
+		locator [][]byte
+		max     int
+		first   int
+		last    int
+	}{
+		{"tip", [][]byte{bc.tipHash()}, maxGetBlocksReply, 30, 31},
+		{"middle", [][]byte{middle}, maxGetBlocksReply, 30, 21},
+		{"capped", [][]byte{middle}, 5, 25, 21},
+		{"side branch", [][]byte{side.Hash, fork}, maxGetBlocksReply, 30, 2},
+		{"unknown", [][]byte{{1}}, maxGetBlocksReply, 30, 0},
+		{"empty", nil, maxGetBlocksReply, 30, 0},
+		{"capped from genesis", nil, 10, 9, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hashes := bc.blocksAfterLocator(test.locator, test.max)
+
+			var expected [][]byte
+			for height := test.first; height >= test.last; height-- {
+				hash, _ := bc.GetBlockHash(height)
+				expected = append(expected, hash)
+			}
+			if !bytes.Equal(bytes.Join(hashes, nil), bytes.Join(expected, nil)) {
+				t.Errorf("%d hashes after the locator, expected heights %d to %d", len(hashes), test.first, test.last)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+)
+
+const metadataBucket = "metadata"
+
+// SchemaVersion is the version of the bucket layout and serialization formats
+// this node reads and writes
+const SchemaVersion = 1
+
+var (
+	metadataBucketName = []byte(metadataBucket)
+
+	schemaVersionKey = []byte("version")
+	paramsHashKey    = []byte("params")
+)
+
+// migration upgrades a database from the schema version before it to version
+type migration struct {
+	version     int
+	description string
+	migrate     func(tx StoreTx) error
+}
+
+// migrations upgrade databases created by older nodes, in version order. Schema version 1
+// is the first. Databases without a schema version were created by nodes hashing blocks and
+// transactions with gob, whose hashes can not be reproduced, so they are not upgraded.
+var migrations []migration
+
+// writeMetadata records the schema version and the network of the database
+func writeMetadata(tx StoreTx, params *ChainParams) error {
+	meta := tx.Bucket(metadataBucketName)
+	if meta == nil {
+		var err error
+		meta, err = tx.CreateBucket(metadataBucketName)
+		if err != nil {
+			return err
+		}
+	}
+
+	version := make([]byte, 4)
+	binary.BigEndian.PutUint32(version, SchemaVersion)
+
+	err := meta.Put(schemaVersionKey, version)
+	if err != nil {
+		return err
+	}
+
+	return meta.Put(paramsHashKey, params.Hash())
+}
+
+// openMetadata checks that the database belongs to the network and upgrades it to the schema version
+func openMetadata(tx StoreTx, params *ChainParams) error {
+	meta := tx.Bucket(metadataBucketName)
+	if meta == nil || meta.Get(schemaVersionKey) == nil {
+		return errors.New("Database was created by a node that hashed blocks differently and can not be upgraded, remove it and sync again")
+	}
+
+	if bytes.Compare(meta.Get(paramsHashKey), params.Hash()) != 0 {
+		return fmt.Errorf("Database was created for a different network than %s", params.Name)
+	}
+
+	version := int(binary.BigEndian.Uint32(meta.Get(schemaVersionKey)))
+	if version > SchemaVersion {
+		return fmt.Errorf("Database schema version %d is newer than %d, upgrade the node", version, SchemaVersion)
+	}
+
+	for _, m := range migrations {
+		if m.version <= version {
+			continue
+		}
+
+		log.Printf("Upgrading the database to version %d: %s\n", m.version, m.description)
+		err := m.migrate(tx)
+		if err != nil {
+			return err
+		}
+	}
+
+	return writeMetadata(tx, params)
+}
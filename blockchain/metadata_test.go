@@ -0,0 +1,61 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestOpenMetadata(t *testing.T) {
+	newer := make([]byte, 4)
+	binary.BigEndian.PutUint32(newer, SchemaVersion+1)
+
+	tests := []struct {
+		name   string
+		stored func(tx StoreTx) error
+		change func(*ChainParams)
+		opens  bool
+	}{
+		{"same network", func(StoreTx) error { return nil }, func(*ChainParams) {}, true},
+		{"other max nonce", func(StoreTx) error { return nil }, func(p *ChainParams) { p.MaxNonce = 1000 }, true},
+		{"other network", func(StoreTx) error { return nil }, func(p *ChainParams) { p.TargetBits++ }, false},
+		{"newer schema", func(tx StoreTx) error {
+			return tx.Bucket(metadataBucketName).Put(schemaVersionKey, newer)
+		}, func(*ChainParams) {}, false},
+		{"no schema version", func(tx StoreTx) error {
+			return tx.Bucket(metadataBucketName).Delete(schemaVersionKey)
+		}, func(*ChainParams) {}, false},
+		{"no metadata", func(tx StoreTx) error {
+			return tx.DeleteBucket(metadataBucketName)
+		}, func(*ChainParams) {}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bc, _ := newTestBlockchain(t)
+			err := bc.store.Update(test.stored)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			params := *bc.Params
+			test.change(&params)
+			err = bc.store.Update(func(tx StoreTx) error {
+				return openMetadata(tx, &params)
+			})
+			if (err == nil) != test.opens {
+				t.Fatalf("Open error %v, expected to open: %t", err, test.opens)
+			}
+			if err != nil {
+				return
+			}
+
+			bc.store.View(func(tx StoreTx) error {
+				if !bytes.Equal(tx.Bucket(metadataBucketName).Get(paramsHashKey), params.Hash()) {
+					t.Error("Params hash is not rewritten")
+				}
+				return nil
+			})
+		})
+	}
+}
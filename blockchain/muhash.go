@@ -0,0 +1,94 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"errors"
+	"math/big"
+)
+
+// muHashBytes is the size of the numbers MuHash multiplies
+const muHashBytes = 384
+
+// muHashPrime is 2^3072 - 1103717, the largest 3072 bit safe prime
+var muHashPrime = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 8*muHashBytes), big.NewInt(1103717))
+
+// MuHash is a rolling hash of a set. Elements are mapped to numbers modulo a prime and
+// multiplied in, removed elements are multiplied into a denominator. The hash of a set
+// does not depend on the order its elements were added and removed in, so it is updated
+// as the set changes without a rescan.
+type MuHash struct {
+	numerator   *big.Int
+	denominator *big.Int
+}
+
+// NewMuHash returns the hash of the empty set
+func NewMuHash() *MuHash {
+	return &MuHash{big.NewInt(1), big.NewInt(1)}
+}
+
+// muHashElement maps data to a number modulo the prime by expanding its SHA-256 hash
+func muHashElement(data []byte) *big.Int {
+	seed := sha256.Sum256(data)
+
+	var expanded []byte
+	for i := byte(0); len(expanded) < muHashBytes; i++ {
+		block := sha256.Sum256(append(seed[:], i))
+		expanded = append(expanded, block[:]...)
+	}
+
+	element := new(big.Int).SetBytes(expanded[:muHashBytes])
+	return element.Mod(element, muHashPrime)
+}
+
+// Add adds the element to the set
+func (h *MuHash) Add(data []byte) {
+	h.numerator.Mul(h.numerator, muHashElement(data))
+	h.numerator.Mod(h.numerator, muHashPrime)
+}
+
+// Remove removes the element from the set
+func (h *MuHash) Remove(data []byte) {
+	h.denominator.Mul(h.denominator, muHashElement(data))
+	h.denominator.Mod(h.denominator, muHashPrime)
+}
+
+// Clone returns a copy of the hash
+func (h *MuHash) Clone() *MuHash {
+	return &MuHash{new(big.Int).Set(h.numerator), new(big.Int).Set(h.denominator)}
+}
+
+// normalize divides the numerator by the denominator
+func (h *MuHash) normalize() {
+	if h.denominator.Cmp(big.NewInt(1)) == 0 {
+		return
+	}
+
+	inverse := new(big.Int).ModInverse(h.denominator, muHashPrime)
+	h.numerator.Mul(h.numerator, inverse)
+	h.numerator.Mod(h.numerator, muHashPrime)
+	h.denominator.SetInt64(1)
+}
+
+// Digest returns the SHA-256 hash of the set
+func (h *MuHash) Digest() []byte {
+	h.normalize()
+
+	digest := sha256.Sum256(h.numerator.FillBytes(make([]byte, muHashBytes)))
+	return digest[:]
+}
+
+// Serialize serializes the hash state
+func (h *MuHash) Serialize() []byte {
+	h.normalize()
+
+	return h.numerator.FillBytes(make([]byte, muHashBytes))
+}
+
+// DeserializeMuHash deserializes a hash state
+func DeserializeMuHash(data []byte) (*MuHash, error) {
+	if len(data) != muHashBytes {
+		return nil, errors.New("MuHash state has the wrong size")
+	}
+
+	return &MuHash{new(big.Int).SetBytes(data), big.NewInt(1)}, nil
+}
@@ -0,0 +1,286 @@
+package blockchain
+
+import (
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// peerSendQueueLen is the number of messages queued for a peer before it is dropped as too slow
+	peerSendQueueLen = 100
+
+	// peerWriteTimeout is how long writing a message to a peer may take
+	peerWriteTimeout = time.Minute
+
+	// handshakeTimeout is how long a peer has to complete the version handshake
+	handshakeTimeout = 30 * time.Second
+)
+
+// peer is a long-lived connection to another node. Messages are read and
+// handled in order by a read loop and written from a queue by a write loop.
+type peer struct {
+	conn    net.Conn
+	manager *PeerManager
+	bc      *Blockchain
+	inbound bool
+
+	// addr is the address the peer was dialed at, or connected from when inbound
+	addr string
+	// listenAddr is the address the peer accepts connections on, once it is known
+	listenAddr string
+
+	// Set from the version of the peer under statsLock, version is the negotiated protocol
+	// version. Only bestHeight changes once the handshake completes.
+	version    int
+	services   uint64
+	userAgent  string
+	timeOffset int64
+	bestHeight int
+
+	// handshakeDone is closed once the peer acknowledged the version of the node
+	handshakeDone chan struct{}
+
+	// blocksInTransit are the blocks to request from the peer in order, used by the read loop only
+	blocksInTransit [][]byte
+
+	// statsLock guards the stats the read and write loops update, and the version fields and
+	// listen address the stats include
+	statsLock sync.Mutex
+	lastSeen  time.Time
+	bytesIn   int
+	bytesOut  int
+
+	// sendLock orders queueing messages with close, no message is counted as pending once
+	// closing is set
+	sendLock  sync.Mutex
+	closing   bool
+	sendQueue chan []byte
+	pending   sync.WaitGroup
+	quit      chan struct{}
+	closeOnce sync.Once
+}
+
+func newPeer(conn net.Conn, inbound bool, manager *PeerManager) *peer {
+	p := &peer{
+		conn:      conn,
+		manager:   manager,
+		bc:        manager.bc,
+		inbound:   inbound,
+		addr:      conn.RemoteAddr().String(),
+		sendQueue: make(chan []byte, peerSendQueueLen),
+		quit:      make(chan struct{}),
+
+		handshakeDone: make(chan struct{}),
+	}
+	if !inbound {
+		p.listenAddr = p.addr
+	}
+
+	return p
+}
+
+// startHandshake drops the peer when it does not complete the handshake in time
+func (p *peer) startHandshake() {
+	time.AfterFunc(handshakeTimeout, func() {
+		if !p.ready() {
+			log.Printf("Disconnecting %s: handshake timed out\n", p.addr)
+			p.disconnect()
+		}
+	})
+}
+
+// ready returns whether the version handshake with the peer is complete
+func (p *peer) ready() bool {
+	select {
+	case <-p.handshakeDone:
+		return true
+	default:
+		return false
+	}
+}
+
+// waitHandshake waits for the version handshake and returns whether it completed
+func (p *peer) waitHandshake() bool {
+	select {
+	case <-p.handshakeDone:
+		return true
+	case <-p.quit:
+		return false
+	case <-time.After(handshakeTimeout):
+		return false
+	}
+}
+
+// supports returns whether the negotiated protocol version includes the feature of the version
+func (p *peer) supports(version int) bool {
+	return p.version >= version
+}
+
+// misbehave disconnects a peer that broke the protocol
+func (p *peer) misbehave(reason string) {
+	log.Printf("Disconnecting %s: %s\n", p.addr, reason)
+	p.disconnect()
+}
+
+// readLoop handles the messages of the peer until it disconnects
+func (p *peer) readLoop() {
+	defer p.disconnect()
+
+	for {
+		command, payload, err := readMessage(p.conn, p.bc.Params.NetworkMagic)
+		if err != nil {
+			select {
+			case <-p.quit:
+			default:
+				log.Printf("Disconnecting %s: %s\n", p.addr, err)
+			}
+			return
+		}
+
+		p.statsLock.Lock()
+		p.lastSeen = time.Now()
+		p.bytesIn += messageHeaderLength + len(payload)
+		p.statsLock.Unlock()
+
+		log.Printf("command %s received from %s\n", command, p.addr)
+		handleMessage(p, command, payload)
+	}
+}
+
+// writeLoop writes the queued messages until the peer disconnects
+func (p *peer) writeLoop() {
+	for {
+		select {
+		case message := <-p.sendQueue:
+			p.conn.SetWriteDeadline(time.Now().Add(peerWriteTimeout))
+			n, err := p.conn.Write(message)
+			p.pending.Done()
+
+			p.statsLock.Lock()
+			p.bytesOut += n
+			p.statsLock.Unlock()
+
+			if err != nil {
+				log.Printf("Disconnecting %s: %s\n", p.addr, err)
+				p.disconnect()
+			}
+		case <-p.quit:
+			// Release the messages that will not be written
+			for {
+				select {
+				case <-p.sendQueue:
+					p.pending.Done()
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// queueMessage queues a message for the write loop, a peer that does not keep up is dropped
+func (p *peer) queueMessage(command string, payload []byte) {
+	message := frameMessage(p.bc.Params.NetworkMagic, command, payload)
+
+	select {
+	case <-p.quit:
+		return
+	default:
+	}
+
+	p.sendLock.Lock()
+	if p.closing {
+		p.sendLock.Unlock()
+		return
+	}
+
+	p.pending.Add(1)
+	select {
+	case p.sendQueue <- message:
+		p.sendLock.Unlock()
+	default:
+		p.pending.Done()
+		p.sendLock.Unlock()
+		log.Printf("Disconnecting %s: send queue is full\n", p.addr)
+		p.disconnect()
+	}
+}
+
+// close stops queueing messages and disconnects once the queued ones are written
+func (p *peer) close() {
+	p.sendLock.Lock()
+	p.closing = true
+	p.sendLock.Unlock()
+
+	sent := make(chan struct{})
+	go func() {
+		p.pending.Wait()
+		close(sent)
+	}()
+
+	select {
+	case <-sent:
+	case <-p.quit:
+	}
+	p.disconnect()
+}
+
+func (p *peer) disconnect() {
+	p.closeOnce.Do(func() {
+		close(p.quit)
+		p.conn.Close()
+		p.manager.removePeer(p)
+
+		// Dialing a node that does not complete the handshake counts as a failure
+		if !p.inbound && !p.ready() {
+			p.manager.dialFailed(p.addr)
+		}
+	})
+}
+
+// setBlocksInTransit replaces the blocks to download from the peer, in the order they are requested
+func (p *peer) setBlocksInTransit(hashes [][]byte) {
+	p.blocksInTransit = hashes
+}
+
+// nextBlockInTransit removes and returns the next block to download, nil when there is none
+func (p *peer) nextBlockInTransit() []byte {
+	if len(p.blocksInTransit) == 0 {
+		return nil
+	}
+
+	hash := p.blocksInTransit[0]
+	p.blocksInTransit = p.blocksInTransit[1:]
+
+	return hash
+}
+
+// setBestHeight raises the best height of the peer to the height of a block it sent
+func (p *peer) setBestHeight(height int) {
+	p.statsLock.Lock()
+	defer p.statsLock.Unlock()
+
+	if height > p.bestHeight {
+		p.bestHeight = height
+	}
+}
+
+func (p *peer) stats() PeerStats {
+	p.statsLock.Lock()
+	defer p.statsLock.Unlock()
+
+	return PeerStats{
+		Addr:       p.addr,
+		ListenAddr: p.listenAddr,
+		Inbound:    p.inbound,
+		Version:    p.version,
+		Services:   p.services,
+		UserAgent:  p.userAgent,
+		BestHeight: p.bestHeight,
+		LastSeen:   p.lastSeen,
+		BytesIn:    p.bytesIn,
+		BytesOut:   p.bytesOut,
+	}
+}
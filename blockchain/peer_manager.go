@@ -0,0 +1,494 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMaxInbound is the default number of peers that may connect to the node
+	DefaultMaxInbound = 32
+
+	// DefaultMaxOutbound is the default number of peers the node connects to
+	DefaultMaxOutbound = 8
+
+	// connectInterval is how often the manager dials known nodes to fill its outbound connections
+	connectInterval = 5 * time.Second
+
+	// Backoff before dialing a node again after failed attempts, doubling up to the maximum
+	minReconnectBackoff = 5 * time.Second
+	maxReconnectBackoff = 10 * time.Minute
+
+	// maxMempoolSize is the number of transactions the mempool holds
+	maxMempoolSize = 5000
+)
+
+// knownNode is an address the node may connect to
+type knownNode struct {
+	addr        string
+	failures    int
+	nextAttempt time.Time
+
+	// manual is set for addresses given with -connect or -addnode
+	manual bool
+}
+
+// PeerManager owns the peer connections of a node and the state shared between them:
+// the known addresses and the mempool. It limits the
+// number of connections and dials known nodes again with backoff when they fail.
+type PeerManager struct {
+	bc          *Blockchain
+	maxInbound  int
+	maxOutbound int
+
+	mtx         sync.Mutex
+	connectOnly bool
+	peers       map[string]*peer
+	knownNodes  []*knownNode
+	mempool     map[string]Transaction
+	maxMempool  int
+
+	// mempoolSpends maps the outputs spent by mempool transactions to the transaction IDs
+	mempoolSpends map[string]string
+
+	// mineRequests wakes the miner, it holds at most one pending request
+	mineRequests chan struct{}
+}
+
+// PeerStats describes a connected peer
+type PeerStats struct {
+	Addr       string
+	ListenAddr string
+	Inbound    bool
+	Version    int
+	Services   uint64
+	UserAgent  string
+	BestHeight int
+	LastSeen   time.Time
+	BytesIn    int
+	BytesOut   int
+}
+
+// NewPeerManager initializes a peer manager with the connection limits
+func NewPeerManager(bc *Blockchain, maxInbound, maxOutbound int) *PeerManager {
+	return &PeerManager{
+		bc:            bc,
+		maxInbound:    maxInbound,
+		maxOutbound:   maxOutbound,
+		peers:         make(map[string]*peer),
+		mempool:       make(map[string]Transaction),
+		maxMempool:    maxMempoolSize,
+		mempoolSpends: make(map[string]string),
+		mineRequests:  make(chan struct{}, 1),
+	}
+}
+
+// Start dials known nodes in the background whenever there are free outbound connections
+func (m *PeerManager) Start() {
+	go func() {
+		for {
+			m.connectKnownNodes()
+			time.Sleep(connectInterval)
+		}
+	}()
+}
+
+// StartMiner mines the mempool in the background, paying to the address. Blocks are
+// mined one at a time off the peer read loops, whenever a transaction requests it.
+func (m *PeerManager) StartMiner(address string) {
+	go func() {
+		for range m.mineRequests {
+			mineMempool(m, address)
+		}
+	}()
+}
+
+// requestMining wakes the miner, requests made while it is busy are merged into one
+func (m *PeerManager) requestMining() {
+	select {
+	case m.mineRequests <- struct{}{}:
+	default:
+	}
+}
+
+func (m *PeerManager) connectKnownNodes() {
+	for _, addr := range m.nodesToDial() {
+		m.connect(addr)
+	}
+}
+
+// nodesToDial returns the known nodes that are not connected and are not backing off,
+// manual nodes first, up to the number of free outbound connections
+func (m *PeerManager) nodesToDial() []string {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	free := m.maxOutbound - m.countPeers(false)
+	now := time.Now()
+
+	var addrs []string
+	for _, manual := range []bool{true, false} {
+		if !manual && m.connectOnly {
+			break
+		}
+
+		for _, node := range m.knownNodes {
+			if len(addrs) >= free {
+				return addrs
+			}
+			if node.manual == manual && node.addr != nodeAddress && now.After(node.nextAttempt) && m.findPeerLocked(node.addr) == nil {
+				addrs = append(addrs, node.addr)
+			}
+		}
+	}
+
+	return addrs
+}
+
+// connect returns the connected peer at the address, dialing it when there is none.
+// It returns nil when the address is the node itself, is not available or the
+// outbound connections are full.
+func (m *PeerManager) connect(addr string) *peer {
+	if addr == nodeAddress {
+		return nil
+	}
+	if p := m.findPeer(addr); p != nil {
+		return p
+	}
+
+	m.mtx.Lock()
+	full := m.countPeers(false) >= m.maxOutbound
+	m.mtx.Unlock()
+	if full {
+		return nil
+	}
+
+	conn, err := net.Dial(protocol, addr)
+	if err != nil {
+		fmt.Printf("%s is not available\n", addr)
+		m.dialFailed(addr)
+
+		return nil
+	}
+
+	// Other dials may have filled the outbound connections or reached the address meanwhile
+	p := newPeer(conn, false, m)
+	p.addr = addr
+	p.listenAddr = addr
+	if !m.addPeer(p, m.maxOutbound) {
+		conn.Close()
+		return m.findPeer(addr)
+	}
+
+	go p.writeLoop()
+	go p.readLoop()
+	p.startHandshake()
+
+	// Outbound connections announce their version first
+	sendVersion(p, m.bc)
+
+	return p
+}
+
+// accept handles an inbound connection until it closes, refusing it when the inbound connections are full
+func (m *PeerManager) accept(conn net.Conn) {
+	p := newPeer(conn, true, m)
+	if !m.addPeer(p, m.maxInbound) {
+		log.Printf("Refusing %s: too many inbound connections\n", conn.RemoteAddr())
+		conn.Close()
+		return
+	}
+
+	go p.writeLoop()
+	p.startHandshake()
+	p.readLoop()
+}
+
+// countPeers returns the number of inbound or outbound peers, the lock must be held
+func (m *PeerManager) countPeers(inbound bool) int {
+	count := 0
+	for _, p := range m.peers {
+		if p.inbound == inbound {
+			count++
+		}
+	}
+
+	return count
+}
+
+// findPeer returns the connected peer at the address, matching its listen address too
+func (m *PeerManager) findPeer(addr string) *peer {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	return m.findPeerLocked(addr)
+}
+
+func (m *PeerManager) findPeerLocked(addr string) *peer {
+	if p, ok := m.peers[addr]; ok {
+		return p
+	}
+	for _, p := range m.peers {
+		if p.listenAddr == addr {
+			return p
+		}
+	}
+
+	return nil
+}
+
+// addPeer adds the peer unless the connections of its direction reached the limit
+// or a peer is connected at its address
+func (m *PeerManager) addPeer(p *peer, limit int) bool {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if m.countPeers(p.inbound) >= limit || m.peers[p.addr] != nil {
+		return false
+	}
+
+	m.peers[p.addr] = p
+	return true
+}
+
+func (m *PeerManager) removePeer(p *peer) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if m.peers[p.addr] == p {
+		delete(m.peers, p.addr)
+	}
+}
+
+// setListenAddr records the address the peer accepts connections on
+func (m *PeerManager) setListenAddr(p *peer, addr string) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	p.statsLock.Lock()
+	defer p.statsLock.Unlock()
+
+	p.listenAddr = addr
+}
+
+// connectedPeers returns the peers the node completed the handshake with
+func (m *PeerManager) connectedPeers() []*peer {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	var connected []*peer
+	for _, p := range m.peers {
+		if p.ready() {
+			connected = append(connected, p)
+		}
+	}
+
+	return connected
+}
+
+// PeerStats returns the stats of the peers the node completed the handshake with
+func (m *PeerManager) PeerStats() []PeerStats {
+	var stats []PeerStats
+	for _, p := range m.connectedPeers() {
+		stats = append(stats, p.stats())
+	}
+
+	return stats
+}
+
+// AddKnownNodes adds the addresses to the known nodes
+func (m *PeerManager) AddKnownNodes(addrs []string) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	for _, addr := range addrs {
+		if addr != "" && m.knownNode(addr) == nil {
+			m.knownNodes = append(m.knownNodes, &knownNode{addr: addr})
+		}
+	}
+}
+
+// AddManualNodes adds addresses given by the user, they are dialed before the other known nodes
+func (m *PeerManager) AddManualNodes(addrs []string) {
+	m.AddKnownNodes(addrs)
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	for _, addr := range addrs {
+		if node := m.knownNode(addr); node != nil {
+			node.manual = true
+		}
+	}
+}
+
+// ConnectOnly makes the node dial the addresses and no other known nodes
+func (m *PeerManager) ConnectOnly(addrs []string) {
+	m.AddManualNodes(addrs)
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.connectOnly = true
+}
+
+// KnownNodes returns the known addresses
+func (m *PeerManager) KnownNodes() []string {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	var addrs []string
+	for _, node := range m.knownNodes {
+		addrs = append(addrs, node.addr)
+	}
+
+	return addrs
+}
+
+// knownNode returns the known node with the address, the lock must be held
+func (m *PeerManager) knownNode(addr string) *knownNode {
+	for _, node := range m.knownNodes {
+		if node.addr == addr {
+			return node
+		}
+	}
+
+	return nil
+}
+
+// dialFailed backs off from dialing the address again
+func (m *PeerManager) dialFailed(addr string) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	node := m.knownNode(addr)
+	if node == nil {
+		return
+	}
+
+	backoff := minReconnectBackoff << uint(node.failures)
+	if backoff > maxReconnectBackoff || backoff <= 0 {
+		backoff = maxReconnectBackoff
+	}
+	node.failures++
+	node.nextAttempt = time.Now().Add(backoff)
+}
+
+// handshakeCompleted resets the backoff of the address the peer listens on
+func (m *PeerManager) handshakeCompleted(p *peer) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if node := m.knownNode(p.listenAddr); node != nil {
+		node.failures = 0
+		node.nextAttempt = time.Time{}
+	}
+}
+
+// forgetNode removes the address from the known nodes, for addresses of the node itself
+func (m *PeerManager) forgetNode(addr string) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	for i, node := range m.knownNodes {
+		if node.addr == addr {
+			m.knownNodes = append(m.knownNodes[:i], m.knownNodes[i+1:]...)
+			return
+		}
+	}
+}
+
+// addToMempool adds the transaction to the mempool and returns the mempool size. Transactions
+// spending an output a mempool transaction spends are rejected, as well as any when it is full.
+func (m *PeerManager) addToMempool(tx Transaction) (int, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if len(m.mempool) >= m.maxMempool {
+		return len(m.mempool), errors.New("Mempool is full")
+	}
+
+	spends := mempoolSpends(tx)
+	for _, key := range spends {
+		if spender, ok := m.mempoolSpends[key]; ok {
+			return len(m.mempool), fmt.Errorf("Transaction %x double spends transaction %s in the mempool", tx.ID, spender)
+		}
+	}
+
+	txID := hex.EncodeToString(tx.ID)
+	m.mempool[txID] = tx
+	for _, key := range spends {
+		m.mempoolSpends[key] = txID
+	}
+
+	return len(m.mempool), nil
+}
+
+// mempoolSpends returns the keys of the outputs the transaction spends
+func mempoolSpends(tx Transaction) []string {
+	var keys []string
+	for _, in := range tx.Vin {
+		if len(in.TxID) != 0 {
+			keys = append(keys, string(outpointKey(in.TxID, in.Vout)))
+		}
+	}
+
+	return keys
+}
+
+// mempoolTx returns the transaction with the ID from the mempool
+func (m *PeerManager) mempoolTx(txID []byte) (Transaction, bool) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	tx, ok := m.mempool[hex.EncodeToString(txID)]
+
+	return tx, ok
+}
+
+// mempoolTxs returns the transactions of the mempool
+func (m *PeerManager) mempoolTxs() []Transaction {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	var txs []Transaction
+	for _, tx := range m.mempool {
+		txs = append(txs, tx)
+	}
+
+	return txs
+}
+
+// updateMempool drops the mempool transactions that are no longer valid on top of the tip,
+// those the connected blocks include and those spending the same outputs
+func (m *PeerManager) updateMempool() {
+	var invalid []string
+
+	spent := make(map[string]bool)
+	for _, tx := range m.mempoolTxs() {
+		tx := tx
+		if m.bc.checkTransaction(&tx, spent) != nil {
+			invalid = append(invalid, hex.EncodeToString(tx.ID))
+		}
+	}
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	for _, txID := range invalid {
+		tx, ok := m.mempool[txID]
+		if !ok {
+			continue
+		}
+
+		for _, key := range mempoolSpends(tx) {
+			delete(m.mempoolSpends, key)
+		}
+		delete(m.mempool, txID)
+	}
+}
@@ -0,0 +1,312 @@
+package blockchain
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestPeerStatsDuringHandshake reads the stats of a peer while its version is handled,
+// run with -race to check the fields are guarded
+func TestPeerStatsDuringHandshake(t *testing.T) {
+	bc, _ := newTestBlockchain(t)
+	p := newTestPeer(t, bc, false)
+
+	handled := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			p.stats()
+
+			select {
+			case <-handled:
+				return
+			default:
+			}
+		}
+	}()
+
+	// Synchronizing with the reader would order its reads before the version, let it run instead
+	time.Sleep(10 * time.Millisecond)
+	handleMessage(p, "version", GobEncode(version{
+		Version:    nodeVersion + 1,
+		Services:   ServiceNetwork,
+		UserAgent:  "/test/",
+		Nonce:      1,
+		RemoteAddr: "localhost:3000",
+		BestHeight: 7,
+	}))
+	close(handled)
+	wg.Wait()
+
+	stats := p.stats()
+	if stats.Version != nodeVersion || stats.Services != ServiceNetwork || stats.UserAgent != "/test/" ||
+		stats.BestHeight != 7 || stats.ListenAddr != "localhost:3000" {
+		t.Errorf("Peer stats are %+v", stats)
+	}
+}
+
+// newTestListener accepts connections on a local port and discards what they send
+func newTestListener(t *testing.T) net.Listener {
+	t.Helper()
+
+	listener, err := net.Listen(protocol, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(ioutil.Discard, conn)
+		}
+	}()
+
+	return listener
+}
+
+// countPeers returns the number of inbound or outbound peers of the manager
+func countPeers(m *PeerManager, inbound bool) int {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	return m.countPeers(inbound)
+}
+
+func disconnectPeers(m *PeerManager) {
+	m.mtx.Lock()
+	var peers []*peer
+	for _, p := range m.peers {
+		peers = append(peers, p)
+	}
+	m.mtx.Unlock()
+
+	for _, p := range peers {
+		p.disconnect()
+	}
+}
+
+// TestPeerLimits opens more connections at once than the limits allow
+func TestPeerLimits(t *testing.T) {
+	bc, _ := newTestBlockchain(t)
+	const attempts = 5
+
+	t.Run("inbound", func(t *testing.T) {
+		m := NewPeerManager(bc, 2, 0)
+		defer disconnectPeers(m)
+
+		listener, err := net.Listen(protocol, "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer listener.Close()
+
+		// Refused connections return from accept, accepted ones are read until they close
+		refused := make(chan struct{}, attempts)
+		for i := 0; i < attempts; i++ {
+			conn, err := net.Dial(protocol, listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			accepted, err := listener.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			go func() {
+				m.accept(accepted)
+				refused <- struct{}{}
+			}()
+		}
+
+		for i := 0; i < attempts-2; i++ {
+			select {
+			case <-refused:
+			case <-time.After(5 * time.Second):
+				t.Fatal("Connections over the limit are not refused")
+			}
+		}
+		if count := countPeers(m, true); count != 2 {
+			t.Errorf("%d inbound peers, expected 2", count)
+		}
+	})
+
+	t.Run("outbound", func(t *testing.T) {
+		m := NewPeerManager(bc, 0, 2)
+		defer disconnectPeers(m)
+
+		var wg sync.WaitGroup
+		for i := 0; i < attempts; i++ {
+			addr := newTestListener(t).Addr().String()
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				m.connect(addr)
+			}()
+		}
+		wg.Wait()
+
+		if count := countPeers(m, false); count != 2 {
+			t.Errorf("%d outbound peers, expected 2", count)
+		}
+	})
+}
+
+func TestDialBackoff(t *testing.T) {
+	bc, _ := newTestBlockchain(t)
+	m := NewPeerManager(bc, 1, 1)
+
+	// A node that is not listening fails to dial
+	listener := newTestListener(t)
+	addr := listener.Addr().String()
+	listener.Close()
+	m.AddKnownNodes([]string{addr})
+
+	tests := []time.Duration{
+		minReconnectBackoff,
+		2 * minReconnectBackoff,
+		4 * minReconnectBackoff,
+		8 * minReconnectBackoff,
+	}
+
+	for failures, backoff := range tests {
+		if failures == 0 {
+			if m.connect(addr) != nil {
+				t.Fatal("Closed listener is connected")
+			}
+		} else {
+			m.dialFailed(addr)
+		}
+
+		m.mtx.Lock()
+		node := *m.knownNode(addr)
+		m.mtx.Unlock()
+
+		wait := time.Until(node.nextAttempt)
+		if node.failures != failures+1 || wait > backoff || wait < backoff-time.Second {
+			t.Errorf("After %d failures the node is dialed again in %s, expected %s", node.failures, wait, backoff)
+		}
+		if len(m.nodesToDial()) != 0 {
+			t.Error("Node backing off is dialed")
+		}
+	}
+
+	// The backoff stops doubling at the maximum
+	for i := 0; i < 20; i++ {
+		m.dialFailed(addr)
+	}
+	m.mtx.Lock()
+	wait := time.Until(m.knownNode(addr).nextAttempt)
+	m.mtx.Unlock()
+	if wait > maxReconnectBackoff || wait < maxReconnectBackoff-time.Second {
+		t.Errorf("Node is dialed again in %s, expected the maximum %s", wait, maxReconnectBackoff)
+	}
+
+	// A completed handshake resets the backoff
+	m.handshakeCompleted(&peer{listenAddr: addr})
+	if dial := m.nodesToDial(); len(dial) != 1 || dial[0] != addr {
+		t.Errorf("Nodes to dial %v after the handshake, expected %s", dial, addr)
+	}
+}
+
+// TestPeerCloseWhileSending queues messages while the peer closes, run with -race to check
+// no message is counted as pending once close waits for them
+func TestPeerCloseWhileSending(t *testing.T) {
+	bc, _ := newTestBlockchain(t)
+	p := newTestPeer(t, bc, true)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				p.queueMessage("ping", nil)
+			}
+		}()
+	}
+
+	p.close()
+	wg.Wait()
+
+	if !disconnected(p) {
+		t.Error("Peer is not disconnected")
+	}
+}
+
+// TestBlocksInTransitPerPeer downloads blocks from two peers, a notfound of one of them
+// does not drop the blocks requested from the other
+func TestBlocksInTransitPerPeer(t *testing.T) {
+	bc, _ := newTestBlockchain(t)
+	first := newTestPeer(t, bc, true)
+	second := newTestPeer(t, bc, true)
+	second.manager = first.manager
+
+	handleMessage(first, "inv", GobEncode(inv{"block", [][]byte{{2}, {1}}}))
+	handleMessage(second, "inv", GobEncode(inv{"block", [][]byte{{4}, {3}}}))
+	handleMessage(second, "notfound", GobEncode(notfound{"block", []byte{3}}))
+
+	if hash := first.nextBlockInTransit(); len(hash) != 1 || hash[0] != 2 {
+		t.Errorf("Next block of the first peer is %x, expected 02", hash)
+	}
+	if hash := second.nextBlockInTransit(); hash != nil {
+		t.Errorf("Next block of the second peer is %x after notfound", hash)
+	}
+}
+
+// TestNodesToDial checks the addresses given with -connect and -addnode against the
+// seeds and the addresses learned from peers
+func TestNodesToDial(t *testing.T) {
+	bc, _ := newTestBlockchain(t)
+
+	defer func(addr string) { nodeAddress = addr }(nodeAddress)
+	nodeAddress = "localhost:3000"
+
+	tests := []struct {
+		name   string
+		start  func(m *PeerManager)
+		dialed []string
+	}{
+		{"seeds", func(m *PeerManager) {
+			m.AddKnownNodes([]string{"seed:1", "seed:2"})
+		}, []string{"seed:1", "seed:2"}},
+		{"added nodes first", func(m *PeerManager) {
+			m.AddManualNodes([]string{"added:1"})
+			m.AddKnownNodes([]string{"seed:1", "seed:2"})
+		}, []string{"added:1", "seed:1"}},
+		{"seed added as node", func(m *PeerManager) {
+			m.AddKnownNodes([]string{"seed:1", "seed:2"})
+			m.AddManualNodes([]string{"seed:2"})
+		}, []string{"seed:2", "seed:1"}},
+		{"connect only", func(m *PeerManager) {
+			m.ConnectOnly([]string{"connect:1"})
+			m.AddKnownNodes([]string{"learned:1", "learned:2"})
+		}, []string{"connect:1"}},
+		{"own address", func(m *PeerManager) {
+			m.ConnectOnly([]string{nodeAddress, "connect:1"})
+		}, []string{"connect:1"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := NewPeerManager(bc, 0, 2)
+			test.start(m)
+
+			dialed := m.nodesToDial()
+			if strings.Join(dialed, " ") != strings.Join(test.dialed, " ") {
+				t.Errorf("Dialed %v, expected %v", dialed, test.dialed)
+			}
+		})
+	}
+}
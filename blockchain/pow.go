@@ -2,42 +2,99 @@ package blockchain
 
 import (
 	"bytes"
-	"crypto/sha256"
 	"fmt"
+	"log"
 	"math/big"
 )
 
+// Proof of work algorithms selectable through ChainParams.PowAlgorithm
 const (
-	targetBits = 16
-	maxNonce   = 1000000000000
+	PowSHA256 = "sha256"
+	PowScrypt = "scrypt"
 )
 
-// ProofOfWork defines the difficulty for adding new block
-type ProofOfWork struct {
-	block  *Block
-	target *big.Int
+// PoW is a proof of work algorithm
+type PoW interface {
+	// Prepare sets the block to work on
+	Prepare(b *Block)
+
+	// Solve searches the proof for the block and returns the nonce and block hash
+	Solve() (int, []byte)
+
+	// Verify checks the block hash and that it meets the target
+	Verify() bool
+
+	// Target returns the value a block hash must be below
+	Target() *big.Int
 }
 
-// NewProofOfWork initializes and returns pow
-func NewProofOfWork(b *Block) *ProofOfWork {
-	target := big.NewInt(1)
-	target.Lsh(target, uint(256-targetBits))
+// NewProofOfWork returns the proof of work of the network prepared for the block
+func NewProofOfWork(b *Block, params *ChainParams) PoW {
+	var pow PoW
 
-	pow := &ProofOfWork{
-		block:  b,
-		target: target,
+	switch params.PowAlgorithm {
+	case "", PowSHA256:
+		pow = NewSHA256PoW(params)
+	case PowScrypt:
+		pow = NewScryptPoW(params)
+	default:
+		log.Panic("ERROR: Unknown proof of work algorithm " + params.PowAlgorithm)
 	}
+
+	pow.Prepare(b)
 	return pow
 }
 
+// newTarget returns the target a block hash must be below for the difficulty bits
+func newTarget(bits int) *big.Int {
+	target := big.NewInt(1)
+	target.Lsh(target, uint(256-bits))
+
+	return target
+}
+
+// hashPoW is the nonce and extranonce search shared by hash based algorithms
+type hashPoW struct {
+	block      *Block
+	target     *big.Int
+	targetBits int
+
+	// maxNonce bounds the nonce search,
+	// the search space is extended by rolling the coinbase extranonce
+	maxNonce   int
+	merkleRoot []byte
+
+	hash func(data []byte) []byte
+}
+
+func newHashPoW(params *ChainParams, hash func(data []byte) []byte) *hashPoW {
+	return &hashPoW{
+		target:     newTarget(params.TargetBits),
+		targetBits: params.TargetBits,
+		maxNonce:   params.MaxNonce,
+		hash:       hash,
+	}
+}
+
+// Prepare sets the block to work on
+func (pow *hashPoW) Prepare(b *Block) {
+	pow.block = b
+	pow.merkleRoot = b.HashTransactions()
+}
+
+// Target returns the value a block hash must be below
+func (pow *hashPoW) Target() *big.Int {
+	return pow.target
+}
+
 // prepareData prepares data for pow
-func (pow *ProofOfWork) prepareData(nonce int) []byte {
+func (pow *hashPoW) prepareData(nonce int) []byte {
 	data := bytes.Join(
 		[][]byte{
 			pow.block.PrevBlockHash,
-			pow.block.HashTransactions(),
+			pow.merkleRoot,
 			IntToHex(pow.block.Timestamp),
-			IntToHex(int64(targetBits)),
+			IntToHex(int64(pow.targetBits)),
 			IntToHex(int64(nonce)),
 		},
 		[]byte{},
@@ -45,36 +102,66 @@ func (pow *ProofOfWork) prepareData(nonce int) []byte {
 	return data
 }
 
-// Run runs the proof of work
-func (pow *ProofOfWork) Run() (int, []byte) {
+// Solve runs the proof of work. When the nonce space is exhausted the
+// coinbase extranonce is rolled and the search starts over.
+func (pow *hashPoW) Solve() (int, []byte) {
+	var extraNonce uint64
+
+	for {
+		nonce, hash, found := pow.search()
+		if found {
+			fmt.Print("\n\n")
+			return nonce, hash
+		}
+
+		extraNonce++
+		if extraNonce == 0 {
+			log.Panic("ERROR: Extranonce space is exhausted")
+		}
+		pow.setExtraNonce(extraNonce)
+	}
+}
+
+// search tries every nonce and reports whether one meets the target
+func (pow *hashPoW) search() (int, []byte, bool) {
 	var hashInt big.Int
-	var hash [32]byte
-	nonce := 0
 
-	for nonce < maxNonce {
-		data := pow.prepareData(nonce)
-		hash = sha256.Sum256(data)
+	for nonce := 0; nonce < pow.maxNonce; nonce++ {
+		hash := pow.hash(pow.prepareData(nonce))
 		fmt.Printf("\r%x", hash)
-		hashInt.SetBytes(hash[:])
+		hashInt.SetBytes(hash)
 
 		if hashInt.Cmp(pow.target) == -1 {
-			break
-		} else {
-			nonce++
+			return nonce, hash, true
+		}
+	}
+
+	return 0, nil, false
+}
+
+// setExtraNonce updates the coinbase extranonce and rebuilds the merkle root
+func (pow *hashPoW) setExtraNonce(extraNonce uint64) {
+	for _, tx := range pow.block.Transactions {
+		if tx.IsCoinbase() {
+			tx.SetExtraNonce(extraNonce)
+			pow.merkleRoot = pow.block.HashTransactions()
+			return
 		}
 	}
-	fmt.Print("\n\n")
 
-	return nonce, hash[:]
+	log.Panic("ERROR: Block has no coinbase to carry an extranonce")
 }
 
-// Validate validates whether a pow is validate for a block
-func (pow *ProofOfWork) Validate() bool {
+// Verify checks the block hash and that it meets the target
+func (pow *hashPoW) Verify() bool {
 	var hashInt big.Int
 
-	data := pow.prepareData(pow.block.Nonce)
-	hash := sha256.Sum256(data)
-	hashInt.SetBytes(hash[:])
+	hash := pow.hash(pow.prepareData(pow.block.Nonce))
+	if bytes.Compare(hash, pow.block.Hash) != 0 {
+		return false
+	}
+
+	hashInt.SetBytes(hash)
 
 	isValid := hashInt.Cmp(pow.target) == -1
 	return isValid
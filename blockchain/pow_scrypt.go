@@ -0,0 +1,30 @@
+package blockchain
+
+import (
+	"golang.org/x/crypto/scrypt"
+)
+
+// scrypt cost parameters, N*r*128 bytes of memory are needed per hash
+const (
+	scryptN      = 1024
+	scryptR      = 1
+	scryptP      = 1
+	scryptKeyLen = 32
+)
+
+// ScryptPoW is a memory-hard proof of work hashing block headers with scrypt
+type ScryptPoW struct {
+	*hashPoW
+}
+
+// NewScryptPoW initializes and returns a scrypt proof of work
+func NewScryptPoW(params *ChainParams) *ScryptPoW {
+	return &ScryptPoW{newHashPoW(params, scryptHash)}
+}
+
+func scryptHash(data []byte) []byte {
+	hash, err := scrypt.Key(data, data, scryptN, scryptR, scryptP, scryptKeyLen)
+	logPanicErr(err)
+
+	return hash
+}
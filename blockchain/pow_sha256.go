@@ -0,0 +1,21 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+)
+
+// SHA256PoW is the proof of work hashing block headers with SHA-256
+type SHA256PoW struct {
+	*hashPoW
+}
+
+// NewSHA256PoW initializes and returns a SHA-256 proof of work
+func NewSHA256PoW(params *ChainParams) *SHA256PoW {
+	return &SHA256PoW{newHashPoW(params, sha256Hash)}
+}
+
+func sha256Hash(data []byte) []byte {
+	hash := sha256.Sum256(data)
+
+	return hash[:]
+}
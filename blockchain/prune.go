@@ -0,0 +1,159 @@
+package blockchain
+
+import (
+	"encoding/binary"
+	"errors"
+	"log"
+)
+
+// MinBlocksToKeep is the number of blocks below the tip whose bodies and undo data
+// are never pruned, so that reorganizations within this window can be handled
+const MinBlocksToKeep = 288
+
+// prunedHeightKey holds the height of the highest pruned block in the blocks bucket
+var prunedHeightKey = []byte("p")
+
+// IsPruned returns whether the body of the block was pruned, leaving only its header
+func (b *Block) IsPruned() bool {
+	return b.Pruned
+}
+
+// header returns a copy of the block without its transactions, marked pruned
+func (b *Block) header() *Block {
+	header := *b
+	header.Transactions = nil
+	header.Pruned = true
+
+	return &header
+}
+
+// SetPruneTarget sets the size in bytes the stored block bodies are pruned to, 0 disables
+// pruning. Blocks are pruned right away and as new blocks are connected.
+func (bc *Blockchain) SetPruneTarget(size int) {
+	bc.pruneTarget = size
+	if size <= 0 {
+		return
+	}
+
+	err := bc.store.Update(bc.pruneBlocks)
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+// PrunedHeight returns the height of the highest pruned block, -1 if no block was pruned
+func (bc *Blockchain) PrunedHeight() int {
+	height := -1
+
+	err := bc.store.View(func(tx StoreTx) error {
+		height = prunedHeight(tx.Bucket(blocksBucketName))
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return height
+}
+
+func prunedHeight(b StoreBucket) int {
+	data := b.Get(prunedHeightKey)
+	if data == nil {
+		return -1
+	}
+
+	return int(binary.BigEndian.Uint64(data))
+}
+
+// pruneBlocks replaces the oldest main chain blocks with their headers and deletes their
+// undo data, until the bodies from the first unpruned block to the tip fit the prune target.
+// Blocks within MinBlocksToKeep of the tip and blocks the chainstate has not reached are kept.
+func (bc *Blockchain) pruneBlocks(tx StoreTx) error {
+	if bc.pruneTarget <= 0 {
+		return nil
+	}
+
+	b := tx.Bucket(blocksBucketName)
+	heights := tx.Bucket(heightIndexBucketName)
+	undo := tx.Bucket(undoBucketName)
+
+	tip, err := DeserializeBlock(b.Get(bc.tipHash()))
+	if err != nil {
+		return err
+	}
+	tipHeight := tip.Height
+	last := tipHeight - MinBlocksToKeep
+
+	// Unflushed blocks are replayed from their bodies after a crash, a chainstate
+	// without a best block was never flushed and needs every block
+	best := tx.Bucket(chainstateInfoBucketName).Get(bestBlockKey)
+	if best == nil {
+		return nil
+	}
+	bestBlock := b.Get(best)
+	if bestBlock == nil {
+		return nil
+	}
+	block, err := DeserializeBlock(bestBlock)
+	if err != nil {
+		return err
+	}
+	if block.Height < last {
+		last = block.Height
+	}
+
+	first := prunedHeight(b) + 1
+	if first > last {
+		return nil
+	}
+
+	size := 0
+	for height := first; height <= tipHeight; height++ {
+		size += len(b.Get(heights.Get(heightKey(height))))
+	}
+
+	for height := first; height <= last && size > bc.pruneTarget; height++ {
+		hash := heights.Get(heightKey(height))
+		blockData := b.Get(hash)
+		size -= len(blockData)
+
+		block, err := DeserializeBlock(blockData)
+		if err != nil {
+			return err
+		}
+
+		err = b.Put(block.Hash, block.header().Serialize())
+		if err != nil {
+			return err
+		}
+
+		err = undo.Delete(block.Hash)
+		if err != nil {
+			return err
+		}
+
+		err = b.Put(prunedHeightKey, heightKey(height))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// prunedTransaction adds an output of a transaction to prevTx from the UTXO set, for
+// transactions whose block body is pruned. Outputs not added are left empty.
+func (bc *Blockchain) prunedTransaction(prevTx Transaction, txID []byte, vout int) (Transaction, error) {
+	entry, ok := UTXOSet{bc}.GetUTXO(txID, vout)
+	if !ok {
+		return Transaction{}, errors.New("Transaction is not found")
+	}
+
+	prevTx.ID = txID
+	for len(prevTx.Vout) <= vout {
+		prevTx.Vout = append(prevTx.Vout, TXOutput{})
+	}
+	prevTx.Vout[vout] = entry.Output()
+
+	return prevTx, nil
+}
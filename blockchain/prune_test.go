@@ -0,0 +1,193 @@
+package blockchain
+
+import (
+	"bytes"
+	"testing"
+)
+
+// newTestPrunedChain mines past MinBlocksToKeep, flushing the UTXO cache at the flush
+// height, and prunes every block it can. A negative flush height never flushes.
+func newTestPrunedChain(t *testing.T, flushHeight int) (*Blockchain, *Wallet) {
+	t.Helper()
+
+	bc, wallet := newTestBlockchain(t)
+	for bc.GetBestHeight() < MinBlocksToKeep+5 {
+		if bc.GetBestHeight() == flushHeight {
+			bc.FlushUTXOCache()
+		}
+		mineBlocks(bc, wallet, 1)
+	}
+	if bc.GetBestHeight() == flushHeight {
+		bc.FlushUTXOCache()
+	}
+	bc.SetPruneTarget(1)
+
+	return bc, wallet
+}
+
+func TestPrunedBlocksFromPeers(t *testing.T) {
+	bc, wallet := newTestBlockchain(t)
+	mineBlocks(bc, wallet, 2)
+	first, _ := bc.GetBlockByHeight(1)
+
+	emptyOnTip := newTestBlock(bc, wallet)
+	emptyOnTip.Transactions = nil
+
+	prunedOnTip := newTestBlock(bc, wallet)
+	prunedOnTip.Pruned = true
+
+	emptySide, err := NewBlock([]*Transaction{newTestCoinbase(bc, wallet)}, first.Hash, 2, bc.Engine())
+	logPanicErr(err)
+	emptySide.Transactions = nil
+
+	side, err := NewBlock([]*Transaction{newTestCoinbase(bc, wallet)}, first.Hash, 2, bc.Engine())
+	logPanicErr(err)
+
+	tests := []struct {
+		name  string
+		block *Block
+	}{
+		{"empty on tip", emptyOnTip},
+		{"marked pruned on tip", prunedOnTip},
+		{"empty in side branch", emptySide},
+		{"header in side branch", side.header()},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if bc.CheckBlock(test.block) == nil {
+				t.Fatal("Block is valid")
+			}
+		})
+	}
+
+	// A block without transactions is not a pruned header
+	empty := Block{Height: 3}
+	if empty.IsPruned() || !empty.header().IsPruned() {
+		t.Error("Pruned is not told by the flag")
+	}
+}
+
+func TestPruneBlocks(t *testing.T) {
+	const tipHeight = MinBlocksToKeep + 5
+
+	tests := []struct {
+		name         string
+		flushHeight  int
+		prunedHeight int
+	}{
+		{"flushed", tipHeight, tipHeight - MinBlocksToKeep},
+		{"flushed below the kept blocks", 2, 2},
+		{"never flushed", -1, -1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bc, _ := newTestPrunedChain(t, test.flushHeight)
+			if bc.PrunedHeight() != test.prunedHeight {
+				t.Fatalf("Pruned up to height %d, expected %d", bc.PrunedHeight(), test.prunedHeight)
+			}
+
+			for height := 0; height <= tipHeight; height++ {
+				block, err := bc.GetBlockByHeight(height)
+				if err != nil {
+					t.Fatalf("Block at height %d: %s", height, err)
+				}
+
+				var undo []byte
+				bc.store.View(func(tx StoreTx) error {
+					undo = tx.Bucket(undoBucketName).Get(block.Hash)
+					return nil
+				})
+
+				pruned := height <= test.prunedHeight
+				if block.IsPruned() != pruned || (len(block.Transactions) == 0) != pruned {
+					t.Fatalf("Block at height %d has %d transactions, expected pruned: %t", height, len(block.Transactions), pruned)
+				}
+				// The genesis block has no undo data
+				if height > 0 && (undo == nil) != pruned {
+					t.Fatalf("Block at height %d has undo data: %t, expected pruned: %t", height, undo != nil, pruned)
+				}
+			}
+		})
+	}
+}
+
+func TestPrunedHeadersAfterRestart(t *testing.T) {
+	tests := []struct {
+		name        string
+		flushHeight int
+	}{
+		{"flushed", MinBlocksToKeep + 5},
+		{"unflushed blocks replayed", 2},
+		{"never flushed", -1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bc, wallet := newTestPrunedChain(t, test.flushHeight)
+			height := bc.GetBestHeight()
+			prunedHeight := bc.PrunedHeight()
+
+			reopened := NewBlockchainWithStore(bc.store, bc.Params)
+			if reopened.PrunedHeight() != prunedHeight || reopened.GetBestHeight() != height {
+				t.Fatalf("Chain at height %d pruned to %d after the restart, expected %d pruned to %d",
+					reopened.GetBestHeight(), reopened.PrunedHeight(), height, prunedHeight)
+			}
+			if !bytes.Equal(reopened.GetUTXOSetInfo().Hash, bc.GetUTXOSetInfo().Hash) {
+				t.Error("UTXO set differs after the restart")
+			}
+
+			for h := 0; h <= prunedHeight; h++ {
+				block, err := reopened.GetBlockByHeight(h)
+				if err != nil || !block.IsPruned() {
+					t.Fatalf("Header at height %d is not kept: %v", h, err)
+				}
+				if !reopened.IsMainChain(block.Hash) {
+					t.Errorf("Header at height %d is not in the main chain", h)
+				}
+			}
+
+			// The chain still grows on top of the pruned history
+			mineBlocks(reopened, wallet, 1)
+			if reopened.GetBestHeight() != height+1 {
+				t.Error("Block is not mined after the restart")
+			}
+		})
+	}
+}
+
+func TestGetDataPrunedBlock(t *testing.T) {
+	bc, _ := newTestPrunedChain(t, MinBlocksToKeep+5)
+	pruned, _ := bc.GetBlockByHeight(bc.PrunedHeight())
+	kept, _ := bc.GetBlockByHeight(bc.PrunedHeight() + 1)
+
+	tests := []struct {
+		name    string
+		hash    []byte
+		command string
+	}{
+		{"pruned block", pruned.Hash, "notfound"},
+		{"kept block", kept.Hash, "block"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, remote := newTestPeerConn(t, bc, true)
+
+			go handleMessage(p, "getdata", GobEncode(getdata{"block", test.hash}))
+			command, payload := readTestMessage(t, bc, remote)
+			if command != test.command {
+				t.Fatalf("Answered %s, expected %s", command, test.command)
+			}
+
+			if command == "notfound" {
+				var answer notfound
+				logPanicErr(GobDecode(payload, &answer))
+				if answer.Type != "block" || !bytes.Equal(answer.ID, test.hash) {
+					t.Errorf("Not found %s %x, expected block %x", answer.Type, answer.ID, test.hash)
+				}
+			}
+		})
+	}
+}
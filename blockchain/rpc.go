@@ -0,0 +1,208 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/rpc"
+	"strconv"
+	"time"
+)
+
+// rpcPortOffset is added to the node port to get the default RPC port
+const rpcPortOffset = 10000
+
+// BlockTemplate is the work a miner needs to build a block on top of the tip
+type BlockTemplate struct {
+	PrevBlockHash []byte
+	Height        int
+	Timestamp     int64
+	PowAlgorithm  string
+	TargetBits    int
+	Target        []byte
+	CoinbaseValue int
+
+	// CoinbaseData starts the coinbase data, it commits to the UTXO set on networks that require it
+	CoinbaseData string
+
+	// Transactions are serialized mempool transactions selected for the block
+	Transactions [][]byte
+}
+
+// GetBlockTemplateArgs are the arguments of Node.GetBlockTemplate
+type GetBlockTemplateArgs struct{}
+
+// SubmitBlockArgs are the arguments of Node.SubmitBlock
+type SubmitBlockArgs struct {
+	Block []byte
+}
+
+// SubmitBlockReply is the result of Node.SubmitBlock
+type SubmitBlockReply struct {
+	Hash   []byte
+	Height int
+}
+
+// Node exposes a running node over RPC
+type Node struct {
+	bc      *Blockchain
+	manager *PeerManager
+}
+
+// GetBlockTemplate returns a template for a block extending the current tip
+func (n *Node) GetBlockTemplate(args *GetBlockTemplateArgs, reply *BlockTemplate) error {
+	if n.bc.Params.Consensus == ConsensusPoA {
+		return errors.New("Block templates are only available under proof of work")
+	}
+
+	lastBlock, err := n.bc.GetBlock(n.bc.tipHash())
+	if err != nil {
+		return err
+	}
+
+	reply.PrevBlockHash = lastBlock.Hash
+	reply.Height = lastBlock.Height + 1
+	reply.Timestamp = time.Now().Unix()
+	reply.PowAlgorithm = n.bc.Params.PowAlgorithm
+	reply.TargetBits = n.bc.Params.TargetBits
+	reply.Target = newTarget(n.bc.Params.TargetBits).Bytes()
+	reply.CoinbaseValue = n.bc.Params.BlockSubsidy(reply.Height)
+	reply.CoinbaseData = n.bc.utxoCommitment()
+	reply.Transactions = nil
+
+	// Transactions are checked as the block would be, dropping conflicting and invalid ones
+	spent := make(map[string]bool)
+	for _, tx := range n.manager.mempoolTxs() {
+		if n.bc.checkTransaction(&tx, spent) == nil {
+			reply.Transactions = append(reply.Transactions, tx.Serialize())
+		}
+	}
+
+	return nil
+}
+
+// SubmitBlock validates a solved block and connects it to the chain
+func (n *Node) SubmitBlock(args *SubmitBlockArgs, reply *SubmitBlockReply) error {
+	block, err := DeserializeBlock(args.Block)
+	if err != nil {
+		return err
+	}
+
+	err = n.bc.ValidateBlock(block)
+	if err != nil {
+		return err
+	}
+
+	n.bc.AddBlock(block)
+
+	fmt.Printf("Added submitted block %x\n", block.Hash)
+
+	n.manager.updateMempool()
+	n.manager.broadcastInv("block", [][]byte{block.Hash}, nil)
+
+	reply.Hash = block.Hash
+	reply.Height = block.Height
+
+	return nil
+}
+
+// GenerateArgs are the arguments of Node.Generate
+type GenerateArgs struct {
+	Blocks  int
+	Address string
+}
+
+// GenerateReply is the result of Node.Generate
+type GenerateReply struct {
+	Hashes [][]byte
+}
+
+// Generate mines blocks immediately, the first one includes the mempool. Regtest only.
+func (n *Node) Generate(args *GenerateArgs, reply *GenerateReply) error {
+	if !n.bc.Params.MineOnDemand {
+		return errors.New("Generate is not available on " + n.bc.Params.Name)
+	}
+
+	if !ValidateAddress(args.Address, n.bc.Params) {
+		return errors.New("Invalid wallet address")
+	}
+
+	for i := 0; i < args.Blocks; i++ {
+		var txs []*Transaction
+
+		spent := make(map[string]bool)
+		for _, tx := range n.manager.mempoolTxs() {
+			tx := tx
+			if n.bc.checkTransaction(&tx, spent) == nil {
+				txs = append(txs, &tx)
+			}
+		}
+		txs = append(txs, NewCoinbaseTX(args.Address, "", n.bc.Params.BlockSubsidy(n.bc.GetBestHeight()+1)))
+
+		newBlock, err := n.bc.MineBlock(txs)
+		if err != nil {
+			return err
+		}
+
+		n.manager.updateMempool()
+		n.manager.broadcastInv("block", [][]byte{newBlock.Hash}, nil)
+
+		reply.Hashes = append(reply.Hashes, newBlock.Hash)
+	}
+
+	return nil
+}
+
+// NewBlock builds an unsolved block from the template and the miner's coinbase
+func (t *BlockTemplate) NewBlock(coinbase *Transaction) *Block {
+	transactions := []*Transaction{coinbase}
+
+	for _, txData := range t.Transactions {
+		tx, err := DeserializeTransaction(txData)
+		logPanicErr(err)
+		transactions = append(transactions, &tx)
+	}
+
+	return &Block{
+		PrevBlockHash: t.PrevBlockHash,
+		Timestamp:     t.Timestamp,
+		Transactions:  transactions,
+		Height:        t.Height,
+	}
+}
+
+// GetPeerInfoArgs are the arguments of Node.GetPeerInfo
+type GetPeerInfoArgs struct{}
+
+// GetPeerInfoReply is the result of Node.GetPeerInfo
+type GetPeerInfoReply struct {
+	Peers []PeerStats
+}
+
+// GetPeerInfo returns the stats of the connected peers
+func (n *Node) GetPeerInfo(args *GetPeerInfoArgs, reply *GetPeerInfoReply) error {
+	reply.Peers = n.manager.PeerStats()
+
+	return nil
+}
+
+// defaultRPCAddress returns the RPC address of the node with the id
+func defaultRPCAddress(nodeID string) string {
+	port, err := strconv.Atoi(nodeID)
+	logPanicErr(err)
+
+	return fmt.Sprintf("localhost:%d", port+rpcPortOffset)
+}
+
+func startRPCServer(addr string, bc *Blockchain, manager *PeerManager) {
+	server := rpc.NewServer()
+	err := server.Register(&Node{bc, manager})
+	logPanicErr(err)
+
+	ln, err := net.Listen(protocol, addr)
+	logPanicErr(err)
+
+	log.Printf("RPC server listening on %s\n", addr)
+	server.Accept(ln)
+}
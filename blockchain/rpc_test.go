@@ -0,0 +1,44 @@
+package blockchain
+
+import "testing"
+
+func TestBlockTemplateFiltersMempool(t *testing.T) {
+	bc, wallet := newTestBlockchain(t)
+	mineBlocks(bc, wallet, 1)
+	genesis, _ := bc.GetBlockByHeight(0)
+	first, _ := bc.GetBlockByHeight(1)
+
+	manager := NewPeerManager(bc, 0, 0)
+	node := &Node{bc, manager}
+
+	// The transaction paying more than its inputs is left out of the block
+	manager.addToMempool(*spendTX(bc, wallet, genesis.Transactions[0].ID, []int{0}, 10))
+	manager.addToMempool(*spendTX(bc, wallet, first.Transactions[0].ID, []int{0}, 11))
+
+	var template BlockTemplate
+	err := node.GetBlockTemplate(&GetBlockTemplateArgs{}, &template)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(template.Transactions) != 1 {
+		t.Fatalf("Template has %d transactions, expected 1", len(template.Transactions))
+	}
+
+	block := template.NewBlock(NewCoinbaseTX(string(wallet.GetAddress(bc.Params)), template.CoinbaseData, template.CoinbaseValue))
+	err = bc.Engine().Seal(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var reply SubmitBlockReply
+	err = node.SubmitBlock(&SubmitBlockArgs{block.Serialize()}, &reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Height != 2 || bc.GetBestHeight() != 2 {
+		t.Errorf("Submitted block is at height %d, chain at %d, expected 2", reply.Height, bc.GetBestHeight())
+	}
+	if len(manager.mempoolTxs()) != 0 {
+		t.Errorf("Mempool has %d transactions after the block, expected none", len(manager.mempoolTxs()))
+	}
+}
@@ -2,83 +2,245 @@ package blockchain
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
 	"net"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
 const (
 	protocol      = "tcp"
-	nodeVersion   = 1
 	commandLength = 12
+
+	// nodeVersion is the protocol version of the node
+	nodeVersion = 2
+
+	// minPeerVersion is the oldest protocol version the node talks to
+	minPeerVersion = 1
+
+	// notFoundVersion is the protocol version peers understand notfound from
+	notFoundVersion = 2
+
+	// messageHeaderLength is the size of the network magic, command, payload length and checksum
+	messageHeaderLength = 4 + commandLength + 4 + 4
+
+	// maxMessagePayload caps the payload of a message, it fits the largest block
+	maxMessagePayload = maxBlockRecordLen + 1024
 )
 
 var (
-	nodeAddress     string
-	miningAddress   string
-	knownNodes      = []string{"localhost:3000"}
-	blocksInTransit = [][]byte{}
-	mempool         = make(map[string]Transaction)
+	nodeAddress   string
+	miningAddress string
 )
 
+// ServerConfig holds the options of a node server
+type ServerConfig struct {
+	NodeID       string
+	MinerAddress string
+	RPCAddress   string
+
+	// TxIndex and AddrIndex build and maintain the optional indexes
+	TxIndex   bool
+	AddrIndex bool
+
+	// UTXOCacheSize is the memory budget of the UTXO cache in bytes, 0 disables it
+	UTXOCacheSize int
+
+	// PruneSize is the size in bytes block bodies are pruned to, 0 disables pruning
+	PruneSize int
+	// VerifySnapshot downloads and replays the history below a loaded UTXO snapshot
+	VerifySnapshot bool
+
+	// MaxInbound and MaxOutbound limit the peer connections
+	MaxInbound  int
+	MaxOutbound int
+
+	// ListenAddress is the address peers connect to, defaults to localhost:NODE_ID
+	ListenAddress string
+	// ExternalIP is the host, or host and port, announced to peers when it differs from the listen address
+	ExternalIP string
+
+	// Connect lists the only peers to dial, instead of the seeds and learned addresses.
+	// AddNode lists peers dialed before the others.
+	Connect []string
+	AddNode []string
+}
+
 // StartServer start a node server
-func StartServer(nodeID string, minerAddr string) {
-	nodeAddress = fmt.Sprintf("localhost:%s", nodeID)
-	miningAddress = minerAddr
+func StartServer(config ServerConfig, params *ChainParams) {
+	nodeID := config.NodeID
+	listenAddress := config.ListenAddress
+	if listenAddress == "" {
+		listenAddress = fmt.Sprintf("localhost:%s", nodeID)
+	}
+	nodeAddress = announcedAddress(listenAddress, config.ExternalIP)
+	miningAddress = config.MinerAddress
 
 	// start server
-	ln, err := net.Listen(protocol, nodeAddress)
+	ln, err := net.Listen(protocol, listenAddress)
 	if err != nil {
 		log.Panic(err)
 	}
+	log.Printf("Listening on %s, announcing %q\n", listenAddress, nodeAddress)
 	defer ln.Close()
 
-	bc := NewBlockchain(nodeID)
+	if config.PruneSize > 0 && config.TxIndex {
+		log.Panic("ERROR: Pruning is incompatible with the transaction index")
+	}
+	// Validator sets are replayed from the governance transactions of every block
+	if config.PruneSize > 0 && params.Consensus == ConsensusPoA {
+		log.Panic("ERROR: Pruning needs proof of work")
+	}
+
+	bc := NewBlockchain(nodeID, params)
+	bc.SetUTXOCacheSize(config.UTXOCacheSize)
+	if config.TxIndex {
+		bc.EnableTxIndex()
+	}
+	if config.AddrIndex {
+		bc.EnableAddrIndex()
+	}
+	bc.SetPruneTarget(config.PruneSize)
 
-	if nodeAddress != knownNodes[0] {
-		sendVersion(knownNodes[0], bc)
+	// Pruned nodes and nodes loaded from a UTXO snapshot only serve recent blocks
+	localServices = ServiceNetworkLimited
+	if config.PruneSize == 0 && bc.PrunedHeight() < 0 {
+		localServices |= ServiceNetwork
 	}
 
+	// Flush the UTXO cache on shutdown
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-interrupt
+		fmt.Println(bc.UTXOCacheStats())
+		bc.Close()
+		os.Exit(0)
+	}()
+
+	// Under proof of authority the miner address is the validator key blocks are signed with
+	if params.Consensus == ConsensusPoA && miningAddress != "" {
+		wallets, err := NewWallets(nodeID, params)
+		logPanicErr(err)
+
+		wallet := wallets.Wallets[miningAddress]
+		if wallet == nil {
+			log.Panic("ERROR: Validator key is not in the wallet file")
+		}
+		bc.SetSigner(wallet)
+	}
+
+	manager := NewPeerManager(bc, config.MaxInbound, config.MaxOutbound)
+	if len(config.Connect) > 0 {
+		manager.ConnectOnly(config.Connect)
+	} else {
+		manager.AddManualNodes(config.AddNode)
+		manager.AddKnownNodes(params.Seeds)
+	}
+
+	go startRPCServer(config.RPCAddress, bc, manager)
+
+	if _, loaded := bc.UTXOSnapshot(); loaded && config.VerifySnapshot {
+		go func() {
+			err := bc.VerifyUTXOSnapshot(func(hash []byte) {
+				for _, p := range manager.connectedPeers() {
+					if p.services&ServiceNetwork != 0 {
+						sendGetData(p, "block", hash)
+					}
+				}
+			})
+			if err != nil {
+				log.Panic(err)
+			}
+			fmt.Println("UTXO snapshot history is verified")
+		}()
+	}
+
+	if miningAddress != "" {
+		manager.StartMiner(miningAddress)
+	}
+	manager.Start()
+
 	for {
 		conn, err := ln.Accept()
 		logPanicErr(err)
 
-		go handleConnection(conn, bc)
+		go manager.accept(conn)
 	}
 
 }
 
-func handleConnection(conn net.Conn, bc *Blockchain) {
-	defer conn.Close()
+func handleMessage(p *peer, command string, payload []byte) {
+	bc := p.bc
 
-	request, err := ioutil.ReadAll(conn)
-	if err != nil {
-		log.Panic()
+	// The version handshake comes first and only once
+	handshake := command == "version" || command == "verack"
+	if !p.ready() && !handshake {
+		p.misbehave(command + " before the handshake")
+		return
+	}
+	if p.ready() && handshake {
+		p.misbehave(command + " after the handshake")
+		return
 	}
 
-	command := bytesToCommand(request[:commandLength])
-	log.Printf("command %s received\n", command)
-
+	var err error
 	switch command {
 	case "addr":
-		handleAddr(request)
+		err = handleAddr(p, payload, bc)
 	case "block":
-		handleBlock(request, bc)
+		err = handleBlock(p, payload, bc)
 	case "inv":
-		handleInv(request, bc)
+		err = handleInv(p, payload, bc)
 	case "getblocks":
-		handleGetBlocks(request, bc)
+		err = handleGetBlocks(p, payload, bc)
 	case "getdata":
-		handleGetData(request, bc)
+		err = handleGetData(p, payload, bc)
+	case "notfound":
+		err = handleNotFound(p, payload, bc)
 	case "tx":
-		handleTx(request, bc)
+		err = handleTx(p, payload, bc)
+	case "verack":
+		err = handleVerack(p, payload, bc)
 	case "version":
-		handleVersion(request, bc)
+		err = handleVersion(p, payload, bc)
 	default:
 		fmt.Println("Unknown command!")
 	}
+
+	// Handlers return an error for messages that break the protocol
+	if err != nil {
+		p.misbehave(fmt.Sprintf("invalid %s: %s", command, err))
+	}
+}
+
+// announcedAddress returns the address peers reach the node at, empty when it is not known.
+// The external IP takes the listen port when it has none.
+func announcedAddress(listenAddress, externalIP string) string {
+	host, port, err := net.SplitHostPort(listenAddress)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	if externalIP != "" {
+		if _, _, err := net.SplitHostPort(externalIP); err == nil {
+			return externalIP
+		}
+		return net.JoinHostPort(externalIP, port)
+	}
+
+	// Peers can not dial a wildcard address
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		return ""
+	}
+
+	return listenAddress
 }
 
 func commandToBytes(command string) []byte {
@@ -103,37 +265,53 @@ func bytesToCommand(bytes []byte) string {
 	return fmt.Sprintf("%s", command)
 }
 
-func sendData(addr string, data []byte) {
-	conn, err := net.Dial(protocol, addr)
-	if err != nil {
-		fmt.Printf("%s is not available\n", addr)
+// messageChecksum is the first 4 bytes of the double SHA-256 of the payload
+func messageChecksum(payload []byte) []byte {
+	first := sha256.Sum256(payload)
+	second := sha256.Sum256(first[:])
 
-		// remove the address from known ndoes since it is not available
-		var updatedNodes []string
+	return second[:4]
+}
 
-		for _, node := range knownNodes {
-			if node != addr {
-				updatedNodes = append(updatedNodes, node)
-			}
-		}
+// frameMessage prefixes the payload with the network magic, command, payload length and checksum
+func frameMessage(magic uint32, command string, payload []byte) []byte {
+	message := make([]byte, messageHeaderLength, messageHeaderLength+len(payload))
 
-		knownNodes = updatedNodes
+	binary.BigEndian.PutUint32(message[:4], magic)
+	copy(message[4:], commandToBytes(command))
+	binary.BigEndian.PutUint32(message[4+commandLength:], uint32(len(payload)))
+	copy(message[8+commandLength:], messageChecksum(payload))
 
-		return
+	return append(message, payload...)
+}
+
+// readMessage reads the next message from the connection and returns its command and payload
+func readMessage(r io.Reader, magic uint32) (string, []byte, error) {
+	header := make([]byte, messageHeaderLength)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return "", nil, err
 	}
-	defer conn.Close()
 
-	_, err = io.Copy(conn, bytes.NewReader(data))
+	if binary.BigEndian.Uint32(header[:4]) != magic {
+		return "", nil, errors.New("Message is not of this network")
+	}
+
+	command := bytesToCommand(header[4 : 4+commandLength])
+	length := binary.BigEndian.Uint32(header[4+commandLength:])
+	if length > maxMessagePayload {
+		return "", nil, fmt.Errorf("Message %s of %d bytes is over the limit", command, length)
+	}
+
+	payload := make([]byte, length)
+	_, err = io.ReadFull(r, payload)
 	if err != nil {
-		log.Panic(err)
+		return "", nil, err
 	}
-}
 
-func isNodeKnown(addr string) bool {
-	for _, node := range knownNodes {
-		if node == addr {
-			return true
-		}
+	if bytes.Compare(messageChecksum(payload), header[8+commandLength:]) != 0 {
+		return "", nil, fmt.Errorf("Message %s has invalid checksum", command)
 	}
-	return false
+
+	return command, payload, nil
 }
@@ -1,43 +1,44 @@
 package blockchain
 
 import (
-	"bytes"
-	"encoding/gob"
 	"fmt"
-	"log"
 )
 
 type addr struct {
 	AddrList []string
 }
 
-func sendAddr(address string) {
-	nodes := addr{knownNodes}
-	nodes.AddrList = append(nodes.AddrList, nodeAddress)
+func sendAddr(p *peer) {
+	nodes := addr{p.manager.KnownNodes()}
+	if nodeAddress != "" {
+		nodes.AddrList = append(nodes.AddrList, nodeAddress)
+	}
 	payload := GobEncode(nodes)
-	request := append(commandToBytes("addr"), payload...)
 
-	sendData(address, request)
+	p.queueMessage("addr", payload)
 }
 
-func handleAddr(request []byte) {
-	var buff bytes.Buffer
+func handleAddr(p *peer, request []byte, bc *Blockchain) error {
 	var payload addr
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
+	err := GobDecode(request, &payload)
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
 
-	knownNodes = append(knownNodes, payload.AddrList...)
-	fmt.Printf("There are %d known nodes now!\n", len(knownNodes))
-	requestBlocks()
+	p.manager.AddKnownNodes(payload.AddrList)
+	fmt.Printf("There are %d known nodes now!\n", len(p.manager.KnownNodes()))
+	p.manager.requestBlocks()
+
+	return nil
 }
 
-func requestBlocks() {
-	for _, node := range knownNodes {
-		sendGetBlocks(node)
+// requestBlocks asks the connected peers for blocks, the new addresses
+// are dialed by the manager and ask for blocks once the handshake completes
+func (m *PeerManager) requestBlocks() {
+	for _, p := range m.connectedPeers() {
+		if p.services&ServiceNetwork != 0 {
+			sendGetBlocks(p)
+		}
 	}
 }
@@ -2,56 +2,82 @@ package blockchain
 
 import (
 	"bytes"
-	"encoding/gob"
 	"fmt"
+	"log"
 )
 
 type block struct {
-	RemoteAddr string
-	Block      []byte
+	Block []byte
 }
 
-func sendBlock(addr string, b *Block) {
-	// Todo: why node address here? always to the central?
-	payload := GobEncode(block{nodeAddress, b.Serialize()})
-	request := append(commandToBytes("block"), payload...)
+func sendBlock(p *peer, b *Block) {
+	payload := GobEncode(block{b.Serialize()})
 
-	sendData(addr, request)
+	p.queueMessage("block", payload)
 }
 
-// TODO: Instead of trusting unconditionally,
-// we should validate every incoming block before adding it to the blockchain.
-
-// TODO: Instead of running UTXOSet.Reindex(),
-// UTXOSet.Update(block) should be used, because if blockchain is big,
-// it’ll take a lot of time to reindex the whole UTXO set.
-
-func handleBlock(request []byte, bc *Blockchain) {
-	var buff bytes.Buffer
+func handleBlock(p *peer, request []byte, bc *Blockchain) error {
 	var payload block
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
-	logPanicErr(err)
+	err := GobDecode(request, &payload)
+	if err != nil {
+		return err
+	}
 
 	blockData := payload.Block
-	block := DeserializeBlock(blockData)
+	block, err := DeserializeBlock(blockData)
+	if err != nil {
+		return err
+	}
 
 	fmt.Println("Recevied a new block!")
-	bc.AddBlock(block)
+
+	// A relayed block on a chain the node has not seen can not be checked without its
+	// parent, ask the peer for the blocks before it
+	_, err = bc.GetBlock(block.PrevBlockHash)
+	if err != nil && len(block.PrevBlockHash) != 0 {
+		sendGetBlocks(p)
+		return nil
+	}
+
+	err = bc.CheckBlock(block)
+	if err != nil {
+		return err
+	}
+
+	// A branch the block makes the heaviest is validated as it connects
+	wasMainChain := bc.IsMainChain(block.Hash)
+	tip := bc.tipHash()
+	err = bc.addBlock(block)
+	if _, ok := err.(*invalidBlockError); ok {
+		return err
+	}
+	if err != nil {
+		log.Println(err)
+	}
 
 	fmt.Printf("Added block %x\n", block.Hash)
+	if bytes.Compare(bc.tipHash(), tip) != 0 {
+		p.manager.updateMempool()
+	}
+	p.setBestHeight(block.Height)
 
-	// When blocksInTransit sending request to get block again
-	// A smart way to do the iteration
-	if len(blocksInTransit) > 0 {
-		blockHash := blocksInTransit[0]
-		sendGetData(payload.RemoteAddr, "block", blockHash)
+	// Request the next block in transit until the download is done
+	if blockHash := p.nextBlockInTransit(); blockHash != nil {
+		sendGetData(p, "block", blockHash)
+		return nil
+	}
+
+	// A getblocks reply is capped, ask for the blocks after it while the peer is ahead
+	if p.bestHeight > bc.GetBestHeight() && p.services&ServiceNetwork != 0 {
+		sendGetBlocks(p)
+		return nil
+	}
 
-		blocksInTransit = blocksInTransit[1:]
-	} else {
-		UTXOSet := UTXOSet{bc}
-		UTXOSet.Reindex()
+	// Relay new tips, so blocks reach nodes that are not connected to where they were mined
+	if !wasMainChain && bytes.Compare(bc.tipHash(), block.Hash) == 0 {
+		p.manager.broadcastInv("block", [][]byte{block.Hash}, p)
 	}
+
+	return nil
 }
@@ -1,35 +1,43 @@
 package blockchain
 
 import (
-	"bytes"
-	"encoding/gob"
 	"log"
 )
 
+// maxGetBlocksReply caps the block hashes a getblocks is answered with, the node asks
+// again for the blocks after them while the peer is ahead
+const maxGetBlocksReply = 500
+
+// getblocks asks the peer for the inventory of the main chain blocks after the first
+// locator hash it knows. Nodes before the locator send no payload and get every block.
 type getblocks struct {
-	RemoteAddr string
+	Locator [][]byte
 }
 
-func sendGetBlocks(addr string) {
+func sendGetBlocks(p *peer) {
 	log.Println("send get blocks")
 
-	payload := GobEncode(getblocks{nodeAddress})
-	request := append(commandToBytes("getblocks"), payload...)
+	payload := GobEncode(getblocks{p.bc.blockLocator()})
 
-	sendData(addr, request)
+	p.queueMessage("getblocks", payload)
 }
 
-func handleGetBlocks(request []byte, bc *Blockchain) {
+func handleGetBlocks(p *peer, request []byte, bc *Blockchain) error {
 	log.Println("handle get blocks")
 
-	var buff bytes.Buffer
 	var payload getblocks
-
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
-	logPanicErr(err)
-
-	blocks := bc.GetBlockHashes()
-	sendInv(payload.RemoteAddr, "block", blocks)
+	if len(request) != 0 {
+		err := GobDecode(request, &payload)
+		if err != nil {
+			return err
+		}
+	}
+
+	blocks := bc.blocksAfterLocator(payload.Locator, maxGetBlocksReply)
+	if len(blocks) == 0 {
+		return nil
+	}
+	sendInv(p, "block", blocks)
+
+	return nil
 }
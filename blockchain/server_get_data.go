@@ -1,54 +1,57 @@
 package blockchain
 
 import (
-	"bytes"
-	"encoding/gob"
-	"encoding/hex"
 	"log"
 )
 
 type getdata struct {
-	RemoteAddr string
-	Type       string
-	ID         []byte
+	Type string
+	ID   []byte
 }
 
-func sendGetData(addr, kind string, id []byte) {
+func sendGetData(p *peer, kind string, id []byte) {
 	log.Println("send get data")
 
-	payload := GobEncode(getdata{nodeAddress, kind, id})
-	request := append(commandToBytes("getdata"), payload...)
+	payload := GobEncode(getdata{kind, id})
 
-	sendData(addr, request)
+	p.queueMessage("getdata", payload)
 }
 
-func handleGetData(request []byte, bc *Blockchain) {
+func handleGetData(p *peer, request []byte, bc *Blockchain) error {
 	log.Println("handle get data")
 
-	var buff bytes.Buffer
 	var payload getdata
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
+	err := GobDecode(request, &payload)
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
 
 	if payload.Type == "block" {
 		block, err := bc.GetBlock([]byte(payload.ID))
 		if err != nil {
-			return
+			return nil
 		}
 
-		sendBlock(payload.RemoteAddr, &block)
+		// Pruned nodes only keep the headers of historic blocks
+		if block.IsPruned() {
+			if p.supports(notFoundVersion) {
+				sendNotFound(p, "block", payload.ID)
+			}
+			return nil
+		}
+
+		sendBlock(p, &block)
 	}
 
 	if payload.Type == "tx" {
-		txID := hex.EncodeToString(payload.ID)
-		tx := mempool[txID]
+		tx, ok := p.manager.mempoolTx(payload.ID)
+		if !ok {
+			return nil
+		}
 
-		sendTx(payload.RemoteAddr, &tx)
-		// delete(mempool, txID)
+		sendTx(p, &tx)
 	}
+
+	return nil
 }
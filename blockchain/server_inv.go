@@ -1,66 +1,75 @@
 package blockchain
 
 import (
-	"bytes"
-	"encoding/gob"
-	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
 )
 
 type inv struct {
-	RemoteAddr string
-	Type       string
-	Items      [][]byte
+	Type  string
+	Items [][]byte
 }
 
-func sendInv(addr string, kind string, items [][]byte) {
+func sendInv(p *peer, kind string, items [][]byte) {
 	log.Println("send inv")
 
-	// Todo: why send to nodeAddrsess ?
-	payload := GobEncode(inv{nodeAddress, kind, items})
-	request := append(commandToBytes("inv"), payload...)
+	payload := GobEncode(inv{kind, items})
 
-	sendData(addr, request)
+	p.queueMessage("inv", payload)
 }
 
-func handleInv(request []byte, bc *Blockchain) {
+// broadcastInv announces the items to every connected peer except the one they came from
+func (m *PeerManager) broadcastInv(kind string, items [][]byte, from *peer) {
+	for _, p := range m.connectedPeers() {
+		if p != from {
+			sendInv(p, kind, items)
+		}
+	}
+}
+
+func handleInv(p *peer, request []byte, bc *Blockchain) error {
 	log.Println("handle inv")
 
-	var buff bytes.Buffer
 	var payload inv
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
+	err := GobDecode(request, &payload)
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
 
 	fmt.Printf("Recevied inventory with %d %s\n", len(payload.Items), payload.Type)
 
 	if payload.Type == "block" {
-		blocksInTransit = payload.Items
-
-		blockHash := payload.Items[0]
-		sendGetData(payload.RemoteAddr, "block", blockHash)
-
-		// Remove the block hash that has sent get data
-		newInTransit := [][]byte{}
-		for _, b := range blocksInTransit {
-			if bytes.Compare(b, blockHash) != 0 {
-				newInTransit = append(newInTransit, b)
+		// Inventory lists blocks from the tip down, request the oldest first
+		// so that every block arrives after its parent. Main chain blocks are
+		// skipped, which also ends the relay of a block between peers.
+		var inTransit [][]byte
+		for i := len(payload.Items) - 1; i >= 0; i-- {
+			if !bc.IsMainChain(payload.Items[i]) {
+				inTransit = append(inTransit, payload.Items[i])
 			}
 		}
-		blocksInTransit = newInTransit
+		if len(inTransit) == 0 {
+			return nil
+		}
+		p.setBlocksInTransit(inTransit)
+
+		if blockHash := p.nextBlockInTransit(); blockHash != nil {
+			sendGetData(p, "block", blockHash)
+		}
 	}
 
 	if payload.Type == "tx" {
+		if len(payload.Items) == 0 {
+			return errors.New("Transaction inventory is empty")
+		}
 		txID := payload.Items[0]
 
-		if mempool[hex.EncodeToString(txID)].ID == nil {
-			sendGetData(payload.RemoteAddr, "tx", txID)
+		if _, ok := p.manager.mempoolTx(txID); !ok {
+			sendGetData(p, "tx", txID)
 		}
 	}
 
+	return nil
 }
@@ -0,0 +1,40 @@
+package blockchain
+
+import (
+	"fmt"
+	"log"
+)
+
+// notfound answers a getdata the node can not serve, such as blocks a pruned node deleted
+type notfound struct {
+	Type string
+	ID   []byte
+}
+
+func sendNotFound(p *peer, kind string, id []byte) {
+	log.Println("send not found")
+
+	payload := GobEncode(notfound{kind, id})
+
+	p.queueMessage("notfound", payload)
+}
+
+func handleNotFound(p *peer, request []byte, bc *Blockchain) error {
+	log.Println("handle not found")
+
+	var payload notfound
+
+	err := GobDecode(request, &payload)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s can not serve %s %x\n", p.addr, payload.Type, payload.ID)
+
+	// The blocks after a missing one would not connect, drop them until the next inventory
+	if payload.Type == "block" {
+		p.setBlocksInTransit(nil)
+	}
+
+	return nil
+}
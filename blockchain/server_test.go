@@ -0,0 +1,358 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+// newTestPeer returns an inbound peer of the blockchain whose messages are discarded,
+// with the version handshake completed when ready is set
+func newTestPeer(t *testing.T, bc *Blockchain, ready bool) *peer {
+	t.Helper()
+
+	p, remote := newTestPeerConn(t, bc, ready)
+	go io.Copy(ioutil.Discard, remote)
+
+	return p
+}
+
+// newTestPeerConn returns an inbound peer of the blockchain and the remote end of its
+// connection, the messages sent to the peer are read from it
+func newTestPeerConn(t *testing.T, bc *Blockchain, ready bool) (*peer, net.Conn) {
+	t.Helper()
+
+	conn, remote := net.Pipe()
+	t.Cleanup(func() { remote.Close() })
+
+	p := newPeer(conn, true, NewPeerManager(bc, 1, 1))
+	p.manager.addPeer(p, 1)
+	go p.writeLoop()
+	t.Cleanup(p.disconnect)
+
+	if ready {
+		p.version = nodeVersion
+		close(p.handshakeDone)
+	}
+
+	return p, remote
+}
+
+// readTestMessage reads the next message sent to the peer from the remote end of its connection
+func readTestMessage(t *testing.T, bc *Blockchain, remote net.Conn) (string, []byte) {
+	t.Helper()
+
+	remote.SetReadDeadline(time.Now().Add(5 * time.Second))
+	command, payload, err := readMessage(remote, bc.Params.NetworkMagic)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return command, payload
+}
+
+// disconnected returns whether the peer was disconnected
+func disconnected(p *peer) bool {
+	select {
+	case <-p.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestHandleMessage(t *testing.T) {
+	bc, _ := newTestBlockchain(t)
+	garbage := []byte("not gob")
+
+	tests := []struct {
+		name      string
+		ready     bool
+		command   string
+		payload   []byte
+		misbehave bool
+	}{
+		{"version", false, "version", GobEncode(version{Version: nodeVersion, Nonce: 1}), false},
+		{"malformed version", false, "version", garbage, true},
+		{"old version", false, "version", GobEncode(version{Version: minPeerVersion - 1, Nonce: 1}), true},
+		{"version after handshake", true, "version", GobEncode(version{Version: nodeVersion, Nonce: 1}), true},
+		{"verack before version", false, "verack", nil, true},
+		{"verack after handshake", true, "verack", nil, true},
+		{"inv before handshake", false, "inv", GobEncode(inv{"tx", [][]byte{{1}}}), true},
+		{"inv", true, "inv", GobEncode(inv{"tx", [][]byte{{1}}}), false},
+		{"malformed inv", true, "inv", garbage, true},
+		{"empty transaction inv", true, "inv", GobEncode(inv{"tx", nil}), true},
+		{"malformed addr", true, "addr", garbage, true},
+		{"malformed getdata", true, "getdata", garbage, true},
+		{"getdata", true, "getdata", GobEncode(getdata{"block", []byte{1}}), false},
+		{"malformed notfound", true, "notfound", garbage, true},
+		{"malformed block", true, "block", garbage, true},
+		{"malformed block data", true, "block", GobEncode(block{garbage}), true},
+		{"malformed tx", true, "tx", garbage, true},
+		{"malformed tx data", true, "tx", GobEncode(tx{garbage}), true},
+		{"getblocks", true, "getblocks", nil, false},
+		{"getblocks with locator", true, "getblocks", GobEncode(getblocks{[][]byte{{1}}}), false},
+		{"malformed getblocks", true, "getblocks", garbage, true},
+		{"unknown command", true, "unknown", garbage, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := newTestPeer(t, bc, test.ready)
+
+			handleMessage(p, test.command, test.payload)
+
+			if disconnected(p) != test.misbehave {
+				t.Errorf("Peer is disconnected: %t, expected %t", disconnected(p), test.misbehave)
+			}
+		})
+	}
+}
+
+func TestHandleTx(t *testing.T) {
+	bc, wallet := newTestBlockchain(t)
+	genesis, _ := bc.GetBlockByHeight(0)
+	coinbase := genesis.Transactions[0]
+	value := coinbase.Vout[0].Value
+
+	forged := spendTX(bc, wallet, coinbase.ID, []int{0}, value)
+	forged.Vin[0].Signature[0] ^= 0xff
+
+	unknown := *spendTX(bc, wallet, coinbase.ID, []int{0}, value)
+	unknown.Vin = []TXInput{{[]byte("unknown"), 0, wallet.PublicKey, nil}}
+	unknown.ID = unknown.unsignedHash()
+
+	tests := []struct {
+		name    string
+		tx      *Transaction
+		mempool bool
+	}{
+		{"spend", spendTX(bc, wallet, coinbase.ID, []int{0}, value), true},
+		{"unknown output", &unknown, false},
+		{"overspend", spendTX(bc, wallet, coinbase.ID, []int{0}, value+1), false},
+		{"invalid signature", forged, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := newTestPeer(t, bc, true)
+
+			handleMessage(p, "tx", GobEncode(tx{test.tx.Serialize()}))
+
+			if disconnected(p) {
+				t.Error("Peer is disconnected")
+			}
+			if _, ok := p.manager.mempoolTx(test.tx.ID); ok != test.mempool {
+				t.Errorf("Transaction is in the mempool: %t, expected %t", ok, test.mempool)
+			}
+		})
+	}
+}
+
+func TestAddToMempool(t *testing.T) {
+	bc, wallet := newTestBlockchain(t)
+	mineBlocks(bc, wallet, 1)
+	genesis, _ := bc.GetBlockByHeight(0)
+	first, _ := bc.GetBlockByHeight(1)
+	funds := genesis.Transactions[0].ID
+	other := first.Transactions[0].ID
+
+	m := NewPeerManager(bc, 1, 1)
+	m.maxMempool = 2
+
+	tests := []struct {
+		name  string
+		tx    *Transaction
+		added bool
+	}{
+		{"spend", spendTX(bc, wallet, funds, []int{0}, 10), true},
+		{"double spend", spendTX(bc, wallet, funds, []int{0}, 9), false},
+		{"other spend", spendTX(bc, wallet, other, []int{0}, 10), true},
+		{"full", spendTX(bc, wallet, other, []int{0}, 9), false},
+	}
+
+	for _, test := range tests {
+		_, err := m.addToMempool(*test.tx)
+		if (err == nil) != test.added {
+			t.Errorf("%s: addToMempool returned %v", test.name, err)
+		}
+		if _, ok := m.mempoolTx(test.tx.ID); ok != test.added {
+			t.Errorf("%s: transaction is in the mempool: %t, expected %t", test.name, ok, test.added)
+		}
+	}
+}
+
+func TestMineMempool(t *testing.T) {
+	bc, wallet := newTestBlockchain(t)
+	genesis, _ := bc.GetBlockByHeight(0)
+	coinbase := genesis.Transactions[0]
+	value := coinbase.Vout[0].Value
+
+	m := NewPeerManager(bc, 1, 1)
+	m.addToMempool(*spendTX(bc, wallet, coinbase.ID, []int{0}, value))
+
+	mineMempool(m, string(wallet.GetAddress(bc.Params)))
+
+	if bc.GetBestHeight() != 1 {
+		t.Fatalf("Height %d, expected 1", bc.GetBestHeight())
+	}
+	block, _ := bc.GetBlockByHeight(1)
+	if len(block.Transactions) != 2 {
+		t.Errorf("Block has %d transactions, expected 2", len(block.Transactions))
+	}
+	if len(m.mempoolTxs()) != 0 {
+		t.Errorf("Mempool has %d transactions after mining, expected none", len(m.mempoolTxs()))
+	}
+}
+
+// TestUpdateMempool connects a block from another node spending an output a mempool transaction spends
+func TestUpdateMempool(t *testing.T) {
+	bc, wallet := newTestBlockchain(t)
+	mineBlocks(bc, wallet, 1)
+	genesis, _ := bc.GetBlockByHeight(0)
+	first, _ := bc.GetBlockByHeight(1)
+
+	spend := spendTX(bc, wallet, genesis.Transactions[0].ID, []int{0}, 10)
+	other := spendTX(bc, wallet, first.Transactions[0].ID, []int{0}, 10)
+
+	p := newTestPeer(t, bc, true)
+	m := p.manager
+	m.addToMempool(*spend)
+	m.addToMempool(*other)
+
+	mined := newTestBlock(bc, wallet, spendTX(bc, wallet, genesis.Transactions[0].ID, []int{0}, 9))
+	handleMessage(p, "block", GobEncode(block{mined.Serialize()}))
+
+	if bc.GetBestHeight() != 2 {
+		t.Fatalf("Height %d, expected 2", bc.GetBestHeight())
+	}
+	if _, ok := m.mempoolTx(spend.ID); ok {
+		t.Error("Transaction spending an output the block spends is in the mempool")
+	}
+	if _, ok := m.mempoolTx(other.ID); !ok {
+		t.Error("Transaction not in conflict with the block is not in the mempool")
+	}
+
+	// The outputs of the transaction left in the mempool are still reserved
+	if _, err := m.addToMempool(*spendTX(bc, wallet, first.Transactions[0].ID, []int{0}, 8)); err == nil {
+		t.Error("Double spend of a mempool transaction is added")
+	}
+}
+
+func TestReadMessage(t *testing.T) {
+	const magic = 0x0b110907
+	payload := []byte("payload")
+	message := frameMessage(magic, "block", payload)
+
+	corrupted := append([]byte{}, message...)
+	corrupted[len(corrupted)-1] ^= 0xff
+
+	badChecksum := append([]byte{}, message...)
+	badChecksum[8+commandLength] ^= 0xff
+
+	oversized := append([]byte{}, message...)
+	binary.BigEndian.PutUint32(oversized[4+commandLength:], maxMessagePayload+1)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		command string
+		payload []byte
+	}{
+		{"message", message, "block", payload},
+		{"empty payload", frameMessage(magic, "verack", nil), "verack", []byte{}},
+		{"other network", frameMessage(magic+1, "block", payload), "", nil},
+		{"truncated header", message[:messageHeaderLength-1], "", nil},
+		{"truncated payload", message[:len(message)-1], "", nil},
+		{"corrupted payload", corrupted, "", nil},
+		{"corrupted checksum", badChecksum, "", nil},
+		{"over the limit", oversized, "", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			command, payload, err := readMessage(bytes.NewReader(test.data), magic)
+			if (err == nil) != (test.command != "") {
+				t.Fatalf("Error %v, expected %q", err, test.command)
+			}
+			if command != test.command || !bytes.Equal(payload, test.payload) {
+				t.Errorf("Read %s %q, expected %s %q", command, payload, test.command, test.payload)
+			}
+		})
+	}
+
+	// Messages follow each other on the connection
+	r := bytes.NewReader(append(frameMessage(magic, "tx", nil), message...))
+	for _, expected := range []string{"tx", "block"} {
+		command, _, err := readMessage(r, magic)
+		if err != nil || command != expected {
+			t.Errorf("Read %s (%v), expected %s", command, err, expected)
+		}
+	}
+	if _, _, err := readMessage(r, magic); err != io.EOF {
+		t.Errorf("Read past the last message: %v", err)
+	}
+}
+
+func TestAnnouncedAddress(t *testing.T) {
+	tests := []struct {
+		name       string
+		listen     string
+		externalIP string
+		announced  string
+	}{
+		{"listen address", "localhost:3000", "", "localhost:3000"},
+		{"all interfaces", "0.0.0.0:3000", "", ""},
+		{"all IPv6 interfaces", "[::]:3000", "", ""},
+		{"no host", ":3000", "", ""},
+		{"external host", "0.0.0.0:3000", "203.0.113.1", "203.0.113.1:3000"},
+		{"external IPv6 host", ":3000", "2001:db8::1", "[2001:db8::1]:3000"},
+		{"external host and port", "0.0.0.0:3000", "203.0.113.1:4000", "203.0.113.1:4000"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			announced := announcedAddress(test.listen, test.externalIP)
+			if announced != test.announced {
+				t.Errorf("Announced %q, expected %q", announced, test.announced)
+			}
+		})
+	}
+}
+
+func TestHandleGetBlocks(t *testing.T) {
+	bc, wallet := newTestBlockchain(t)
+	mineBlocks(bc, wallet, 3)
+	middle, _ := bc.GetBlockHash(1)
+
+	tests := []struct {
+		name    string
+		payload []byte
+		items   int
+	}{
+		{"no payload", nil, 4},
+		{"locator", GobEncode(getblocks{[][]byte{middle}}), 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, remote := newTestPeerConn(t, bc, true)
+
+			go handleMessage(p, "getblocks", test.payload)
+			command, payload := readTestMessage(t, bc, remote)
+
+			var answer inv
+			logPanicErr(GobDecode(payload, &answer))
+			if command != "inv" || answer.Type != "block" || len(answer.Items) != test.items {
+				t.Fatalf("Answered %s of %d %s, expected %d blocks", command, len(answer.Items), answer.Type, test.items)
+			}
+			if bytes.Compare(answer.Items[0], bc.tipHash()) != 0 {
+				t.Error("Inventory does not start at the tip")
+			}
+		})
+	}
+}
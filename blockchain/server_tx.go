@@ -1,87 +1,118 @@
 package blockchain
 
 import (
-	"bytes"
-	"encoding/gob"
-	"encoding/hex"
 	"fmt"
 	"log"
 )
 
 type tx struct {
-	RemoteAddr  string
 	Transaction []byte
 }
 
-func sendTx(addr string, tnx *Transaction) {
-	payload := GobEncode(tx{nodeAddress, tnx.Serialize()})
-	request := append(commandToBytes("tx"), payload...)
+func sendTx(p *peer, tnx *Transaction) {
+	payload := GobEncode(tx{tnx.Serialize()})
 
-	sendData(addr, request)
+	p.queueMessage("tx", payload)
 }
 
-func handleTx(request []byte, bc *Blockchain) {
-	var buff bytes.Buffer
+// submitTx hands the transaction to the first seed node that completes the handshake
+func submitTx(bc *Blockchain, tnx *Transaction) {
+	manager := NewPeerManager(bc, 0, 1)
+
+	for _, seed := range bc.Params.Seeds {
+		p := manager.connect(seed)
+		if p != nil && p.waitHandshake() {
+			sendTx(p, tnx)
+			p.close()
+			return
+		}
+	}
+
+	log.Panic("ERROR: No seed node is available to send the transaction to")
+}
+
+func handleTx(p *peer, request []byte, bc *Blockchain) error {
 	var payload tx
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
+	err := GobDecode(request, &payload)
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
 
 	txData := payload.Transaction
-	tx := DeserializeTransaction(txData)
-	mempool[hex.EncodeToString(tx.ID)] = tx
+	tx, err := DeserializeTransaction(txData)
+	if err != nil {
+		return err
+	}
+	if _, ok := p.manager.mempoolTx(tx.ID); ok {
+		return nil
+	}
 
-	if nodeAddress == knownNodes[0] {
-		for _, node := range knownNodes {
-			if node != nodeAddress && node != payload.RemoteAddr {
-				sendInv(node, "tx", [][]byte{tx.ID})
-			}
-		}
-	} else {
-		// For mining
-		if len(mempool) >= 2 && len(miningAddress) > 0 {
-		MineTransactions:
-			var txs []*Transaction
-
-			for _, tx := range mempool {
-				if bc.VerifyTransaction(&tx) {
-					txs = append(txs, &tx)
-				}
-			}
+	// Transactions are checked as a block would check them. They are dropped rather than
+	// held against the peer, whose chain may be ahead of or behind ours.
+	err = bc.checkTransaction(&tx, make(map[string]bool))
+	if err != nil {
+		fmt.Printf("Rejected transaction: %s\n", err)
+		return nil
+	}
+	mempoolSize, err := p.manager.addToMempool(tx)
+	if err != nil {
+		fmt.Printf("Rejected transaction: %s\n", err)
+		return nil
+	}
 
-			if len(txs) == 0 {
-				fmt.Println("All transactions are invalid! Waiting for new ones...")
-				return
-			}
+	// Every node relays new transactions, miners mine them too
+	p.manager.broadcastInv("tx", [][]byte{tx.ID}, p)
 
-			cbTx := NewCoinbaseTX(miningAddress, "")
-			txs = append(txs, cbTx)
+	if mempoolSize >= 2 && len(miningAddress) > 0 {
+		p.manager.requestMining()
+	}
 
-			// TODO: Again, UTXOSet.Update should be used instead of UTXOSet.Reindex
-			newBlock := bc.MineBlock(txs)
-			UTXOSet := UTXOSet{bc}
-			UTXOSet.Reindex()
+	return nil
+}
 
-			fmt.Println("New block is mined!")
+// mineMempool mines blocks of the mempool transactions paying to the address until the mempool is empty
+func mineMempool(m *PeerManager, address string) {
+	bc := m.bc
 
-			for _, tx := range txs {
-				txID := hex.EncodeToString(tx.ID)
-				delete(mempool, txID)
-			}
+	for len(m.mempoolTxs()) > 0 {
+		if poa, ok := bc.Engine().(*PoAEngine); ok && !poa.InTurn() {
+			fmt.Println("Not in turn to sign the next block. Waiting...")
+			return
+		}
 
-			for _, node := range knownNodes {
-				if node != nodeAddress {
-					sendInv(node, "block", [][]byte{newBlock.Hash})
-				}
-			}
+		var txs []*Transaction
 
-			if len(mempool) > 0 {
-				goto MineTransactions
+		// Transactions are checked as the block would be, dropping conflicting and invalid ones
+		spent := make(map[string]bool)
+		for _, tx := range m.mempoolTxs() {
+			tx := tx
+			if bc.checkTransaction(&tx, spent) == nil {
+				txs = append(txs, &tx)
 			}
 		}
+
+		if len(txs) == 0 {
+			fmt.Println("All transactions are invalid! Waiting for new ones...")
+			return
+		}
+
+		cbTx := NewCoinbaseTX(address, "", bc.Params.BlockSubsidy(bc.GetBestHeight()+1))
+		txs = append(txs, cbTx)
+
+		newBlock, err := bc.MineBlock(txs)
+		if err == errStaleBlock {
+			// A block arrived while mining, mine again on top of it
+			continue
+		}
+		if err != nil {
+			fmt.Printf("Not mining: %s\n", err)
+			return
+		}
+
+		fmt.Println("New block is mined!")
+
+		m.updateMempool()
+		m.broadcastInv("block", [][]byte{newBlock.Hash}, nil)
 	}
 }
@@ -0,0 +1,33 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"log"
+)
+
+// verack acknowledges the version of the peer, it has no payload
+func sendVerack(p *peer) {
+	log.Println("send verack")
+
+	p.queueMessage("verack", nil)
+}
+
+func handleVerack(p *peer, request []byte, bc *Blockchain) error {
+	log.Println("handle verack")
+
+	if p.version == 0 {
+		return errors.New("Verack before version")
+	}
+
+	close(p.handshakeDone)
+	p.manager.handshakeCompleted(p)
+	fmt.Printf("Connected to %s\n", p.addr)
+
+	// Catch up with peers that serve the whole chain and are ahead
+	if p.bestHeight > bc.GetBestHeight() && p.services&ServiceNetwork != 0 {
+		sendGetBlocks(p)
+	}
+
+	return nil
+}
@@ -1,13 +1,40 @@
 package blockchain
 
 import (
-	"bytes"
-	"encoding/gob"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"log"
+	"time"
+)
+
+// Service bits a node announces in its version
+const (
+	// ServiceNetwork is set by nodes that serve every block of the main chain
+	ServiceNetwork uint64 = 1 << iota
+
+	// ServiceNetworkLimited is set by nodes that serve the last MinBlocksToKeep blocks
+	ServiceNetworkLimited
+)
+
+// userAgent identifies the node software in its version
+const userAgent = "/go-blockchain:0.2/"
+
+var (
+	// localServices are the services the node announces, none for command line clients
+	localServices uint64
+
+	// localNonce is announced in the version to detect connections to the node itself
+	localNonce = newNonce()
 )
 
 type version struct {
-	Version int
+	Version   int
+	Services  uint64
+	UserAgent string
+	Nonce     uint64
+	Timestamp int64
 
 	// RemoteAddr is the address where the version from
 	RemoteAddr string
@@ -15,45 +42,79 @@ type version struct {
 	BestHeight int
 }
 
-func sendVersion(addr string, bc *Blockchain) {
+func newNonce() uint64 {
+	var nonce uint64
+
+	err := binary.Read(rand.Reader, binary.BigEndian, &nonce)
+	logPanicErr(err)
+
+	return nonce
+}
+
+func sendVersion(p *peer, bc *Blockchain) {
 	log.Println("send version")
 	bestHeight := bc.GetBestHeight()
 
 	payload := GobEncode(
 		version{
 			Version:    nodeVersion,
+			Services:   localServices,
+			UserAgent:  userAgent,
+			Nonce:      localNonce,
+			Timestamp:  time.Now().Unix(),
 			RemoteAddr: nodeAddress,
 			BestHeight: bestHeight,
 		})
-	request := append(commandToBytes("version"), payload...)
-	sendData(addr, request)
+
+	p.queueMessage("version", payload)
 }
 
-func handleVersion(request []byte, bc *Blockchain) {
+func handleVersion(p *peer, request []byte, bc *Blockchain) error {
 	log.Println("handle version")
-	var buff bytes.Buffer
 	var payload version
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
-	logPanicErr(err)
+	err := GobDecode(request, &payload)
+	if err != nil {
+		return err
+	}
+
+	if p.version != 0 {
+		return errors.New("Duplicate version")
+	}
+	if payload.Nonce == localNonce {
+		fmt.Printf("%s is the node itself\n", payload.RemoteAddr)
+		p.manager.forgetNode(payload.RemoteAddr)
+		p.disconnect()
+		return nil
+	}
+	if payload.Version < minPeerVersion {
+		return fmt.Errorf("Protocol version %d is too old", payload.Version)
+	}
 
-	myBestHeight := bc.GetBestHeight()
-	foreignerBestHeight := payload.BestHeight
+	// Both nodes speak the lower of their protocol versions
+	p.statsLock.Lock()
+	p.version = payload.Version
+	if p.version > nodeVersion {
+		p.version = nodeVersion
+	}
+	p.services = payload.Services
+	p.userAgent = payload.UserAgent
+	p.timeOffset = payload.Timestamp - time.Now().Unix()
+	p.statsLock.Unlock()
+	p.setBestHeight(payload.BestHeight)
 
-	log.Println("myBestHeight", myBestHeight)
-	log.Println("foreignerBestHeight", foreignerBestHeight)
+	log.Printf("%s runs %s, protocol version %d, height %d\n", p.addr, payload.UserAgent, payload.Version, payload.BestHeight)
 
-	if myBestHeight < foreignerBestHeight {
-		// If current blockchain height is smaller than the got-blockchain height
-		// Send get blocks request
-		sendGetBlocks(payload.RemoteAddr)
-	} else if myBestHeight > foreignerBestHeight {
-		sendVersion(payload.RemoteAddr, bc)
+	if payload.RemoteAddr != "" {
+		p.manager.setListenAddr(p, payload.RemoteAddr)
+		p.manager.AddKnownNodes([]string{payload.RemoteAddr})
 	}
 
-	if !isNodeKnown(payload.RemoteAddr) {
-		knownNodes = append(knownNodes, payload.RemoteAddr)
+	// Inbound peers learn about the node from its version once they sent theirs
+	if p.inbound {
+		sendVersion(p, bc)
 	}
+	sendVerack(p)
+
+	return nil
 }
@@ -0,0 +1,46 @@
+package blockchain
+
+import "errors"
+
+// ErrStoreNotWritable is returned when writing in a read only store transaction
+var ErrStoreNotWritable = errors.New("Store transaction is not writable")
+
+// ChainStore is the storage of blocks, the tip, the chainstate and the indexes.
+// Data is kept in named buckets of keys and values and every change happens in a transaction.
+type ChainStore interface {
+	// View runs fn in a read only transaction
+	View(fn func(tx StoreTx) error) error
+
+	// Update runs fn in a read write transaction, which is rolled back if fn returns an error
+	Update(fn func(tx StoreTx) error) error
+
+	Close() error
+}
+
+// StoreTx is a transaction of a ChainStore
+type StoreTx interface {
+	// Bucket returns the bucket with the name, nil if it does not exist
+	Bucket(name []byte) StoreBucket
+
+	CreateBucket(name []byte) (StoreBucket, error)
+	DeleteBucket(name []byte) error
+}
+
+// StoreBucket is a set of keys and values. Values returned are only valid within the transaction.
+type StoreBucket interface {
+	Get(key []byte) []byte
+	Put(key, value []byte) error
+	Delete(key []byte) error
+
+	// Cursor iterates over the keys in byte order
+	Cursor() StoreCursor
+}
+
+// StoreCursor iterates over a bucket. Methods return a nil key past the last key.
+type StoreCursor interface {
+	First() ([]byte, []byte)
+	Next() ([]byte, []byte)
+
+	// Seek moves to the first key equal or greater than the key
+	Seek(key []byte) ([]byte, []byte)
+}
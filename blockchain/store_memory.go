@@ -0,0 +1,197 @@
+package blockchain
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+// memoryStore keeps the chain in memory, for tests and simulations that run
+// many blocks without touching disk
+type memoryStore struct {
+	mu      sync.RWMutex
+	buckets map[string]*memoryBucket
+}
+
+type memoryTx struct {
+	store    *memoryStore
+	writable bool
+
+	// undo restores the state before the transaction on rollback
+	undo []func()
+}
+
+type memoryBucket struct {
+	tx   *memoryTx
+	data map[string][]byte
+}
+
+type memoryCursor struct {
+	bucket *memoryBucket
+	keys   []string
+	pos    int
+}
+
+// NewMemoryStore returns an empty in-memory store
+func NewMemoryStore() ChainStore {
+	return &memoryStore{buckets: make(map[string]*memoryBucket)}
+}
+
+func (s *memoryStore) View(fn func(tx StoreTx) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return fn(&memoryTx{store: s})
+}
+
+func (s *memoryStore) Update(fn func(tx StoreTx) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx := &memoryTx{store: s, writable: true}
+	committed := false
+	defer func() {
+		if !committed {
+			tx.rollback()
+		}
+	}()
+
+	err := fn(tx)
+	if err != nil {
+		return err
+	}
+
+	committed = true
+	return nil
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
+
+func (t *memoryTx) rollback() {
+	for i := len(t.undo) - 1; i >= 0; i-- {
+		t.undo[i]()
+	}
+}
+
+func (t *memoryTx) Bucket(name []byte) StoreBucket {
+	b := t.store.buckets[string(name)]
+	if b == nil {
+		return nil
+	}
+
+	return &memoryBucket{t, b.data}
+}
+
+func (t *memoryTx) CreateBucket(name []byte) (StoreBucket, error) {
+	if !t.writable {
+		return nil, ErrStoreNotWritable
+	}
+
+	key := string(name)
+	if t.store.buckets[key] != nil {
+		return nil, errors.New("Bucket already exists")
+	}
+
+	b := &memoryBucket{data: make(map[string][]byte)}
+	t.store.buckets[key] = b
+	t.undo = append(t.undo, func() { delete(t.store.buckets, key) })
+
+	return &memoryBucket{t, b.data}, nil
+}
+
+func (t *memoryTx) DeleteBucket(name []byte) error {
+	if !t.writable {
+		return ErrStoreNotWritable
+	}
+
+	key := string(name)
+	b := t.store.buckets[key]
+	if b == nil {
+		return errors.New("Bucket not found")
+	}
+
+	delete(t.store.buckets, key)
+	t.undo = append(t.undo, func() { t.store.buckets[key] = b })
+
+	return nil
+}
+
+func (b *memoryBucket) Get(key []byte) []byte {
+	return b.data[string(key)]
+}
+
+func (b *memoryBucket) Put(key, value []byte) error {
+	if !b.tx.writable {
+		return ErrStoreNotWritable
+	}
+
+	b.saveUndo(string(key))
+	b.data[string(key)] = append([]byte{}, value...)
+
+	return nil
+}
+
+func (b *memoryBucket) Delete(key []byte) error {
+	if !b.tx.writable {
+		return ErrStoreNotWritable
+	}
+
+	b.saveUndo(string(key))
+	delete(b.data, string(key))
+
+	return nil
+}
+
+// saveUndo records the current value of the key for rollback
+func (b *memoryBucket) saveUndo(key string) {
+	data := b.data
+	old, exists := data[key]
+
+	b.tx.undo = append(b.tx.undo, func() {
+		if exists {
+			data[key] = old
+		} else {
+			delete(data, key)
+		}
+	})
+}
+
+// Cursor iterates over the keys the bucket has when it is created
+func (b *memoryBucket) Cursor() StoreCursor {
+	keys := make([]string, 0, len(b.data))
+	for k := range b.data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return &memoryCursor{b, keys, 0}
+}
+
+func (c *memoryCursor) First() ([]byte, []byte) {
+	c.pos = 0
+	return c.current()
+}
+
+func (c *memoryCursor) Next() ([]byte, []byte) {
+	c.pos++
+	return c.current()
+}
+
+func (c *memoryCursor) Seek(key []byte) ([]byte, []byte) {
+	c.pos = sort.SearchStrings(c.keys, string(key))
+	return c.current()
+}
+
+// current skips keys deleted since the cursor was created
+func (c *memoryCursor) current() ([]byte, []byte) {
+	for ; c.pos < len(c.keys); c.pos++ {
+		value, ok := c.bucket.data[c.keys[c.pos]]
+		if ok {
+			return []byte(c.keys[c.pos]), value
+		}
+	}
+
+	return nil, nil
+}
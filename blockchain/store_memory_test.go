@@ -0,0 +1,159 @@
+package blockchain
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// storeContents returns the keys and values of the buckets of the store
+func storeContents(t *testing.T, store ChainStore, buckets ...string) map[string]map[string]string {
+	t.Helper()
+
+	contents := make(map[string]map[string]string)
+	err := store.View(func(tx StoreTx) error {
+		for _, name := range buckets {
+			b := tx.Bucket([]byte(name))
+			if b == nil {
+				continue
+			}
+
+			contents[name] = make(map[string]string)
+			c := b.Cursor()
+			for k, v := c.First(); k != nil; k, v = c.Next() {
+				contents[name][string(k)] = string(v)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return contents
+}
+
+func TestMemoryStoreRollback(t *testing.T) {
+	store := NewMemoryStore()
+	err := store.Update(func(tx StoreTx) error {
+		for _, name := range []string{"a", "b"} {
+			b, err := tx.CreateBucket([]byte(name))
+			if err != nil {
+				return err
+			}
+			err = b.Put([]byte("1"), []byte("one"))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := storeContents(t, store, "a", "b", "c")
+
+	failed := errors.New("failed after the changes")
+	err = store.Update(func(tx StoreTx) error {
+		a := tx.Bucket([]byte("a"))
+		a.Put([]byte("1"), []byte("uno"))
+		a.Put([]byte("2"), []byte("two"))
+		a.Delete([]byte("2"))
+
+		tx.DeleteBucket([]byte("b"))
+		b, _ := tx.CreateBucket([]byte("b"))
+		b.Put([]byte("2"), []byte("two"))
+
+		c, _ := tx.CreateBucket([]byte("c"))
+		c.Put([]byte("3"), []byte("three"))
+
+		return failed
+	})
+	if err != failed {
+		t.Fatalf("Update returned %v", err)
+	}
+
+	if after := storeContents(t, store, "a", "b", "c"); !reflect.DeepEqual(after, before) {
+		t.Errorf("Store has %v after the rollback, expected %v", after, before)
+	}
+}
+
+func TestMemoryStoreReadOnly(t *testing.T) {
+	store := NewMemoryStore()
+	err := store.Update(func(tx StoreTx) error {
+		_, err := tx.CreateBucket([]byte("a"))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store.View(func(tx StoreTx) error {
+		if err := tx.Bucket([]byte("a")).Put([]byte("1"), nil); err != ErrStoreNotWritable {
+			t.Errorf("Put returned %v", err)
+		}
+		if err := tx.Bucket([]byte("a")).Delete([]byte("1")); err != ErrStoreNotWritable {
+			t.Errorf("Delete returned %v", err)
+		}
+		if _, err := tx.CreateBucket([]byte("b")); err != ErrStoreNotWritable {
+			t.Errorf("CreateBucket returned %v", err)
+		}
+		if err := tx.DeleteBucket([]byte("a")); err != ErrStoreNotWritable {
+			t.Errorf("DeleteBucket returned %v", err)
+		}
+		return nil
+	})
+}
+
+func TestMemoryStoreCursor(t *testing.T) {
+	store := NewMemoryStore()
+	err := store.Update(func(tx StoreTx) error {
+		b, err := tx.CreateBucket([]byte("a"))
+		if err != nil {
+			return err
+		}
+		for _, k := range []string{"3", "1", "5", "7"} {
+			err = b.Put([]byte(k), []byte(k))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		seek  string
+		found string
+	}{
+		{"0", "1"},
+		{"1", "1"},
+		{"2", "3"},
+		{"6", "7"},
+		{"8", ""},
+	}
+
+	store.Update(func(tx StoreTx) error {
+		b := tx.Bucket([]byte("a"))
+		c := b.Cursor()
+
+		// Keys deleted while iterating are skipped
+		var keys []string
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			keys = append(keys, string(k))
+			b.Delete([]byte("5"))
+		}
+		if !reflect.DeepEqual(keys, []string{"1", "3", "7"}) {
+			t.Errorf("Cursor keys %v, expected 1 3 7", keys)
+		}
+
+		for _, test := range tests {
+			if k, _ := b.Cursor().Seek([]byte(test.seek)); string(k) != test.found {
+				t.Errorf("Seek to %s found %q, expected %q", test.seek, k, test.found)
+			}
+		}
+		return nil
+	})
+}
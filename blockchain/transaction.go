@@ -6,15 +6,18 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/binary"
 	"encoding/gob"
 	"encoding/hex"
 	"fmt"
 	"log"
 	"math/big"
+	"sort"
 	"strings"
 )
 
-const subsidy = 10
+// extraNonceLen is the size of the extranonce at the end of the coinbase data
+const extraNonceLen = 8
 
 // Transaction defines a transaction in blockchain
 type Transaction struct {
@@ -72,11 +75,48 @@ func (tx *Transaction) Hash() []byte {
 	txCopy := *tx
 	txCopy.ID = []byte{}
 
-	hash = sha256.Sum256(txCopy.Serialize())
+	hash = sha256.Sum256(txCopy.hashData())
 
 	return hash[:]
 }
 
+// hashData encodes the transaction for hashing. A fixed binary encoding is used
+// since gob encodings depend on the types encoded before in the process.
+func (tx Transaction) hashData() []byte {
+	var buff bytes.Buffer
+
+	writeVarBytes(&buff, tx.ID)
+
+	binary.Write(&buff, binary.BigEndian, uint32(len(tx.Vin)))
+	for _, in := range tx.Vin {
+		writeVarBytes(&buff, in.TxID)
+		binary.Write(&buff, binary.BigEndian, int32(in.Vout))
+		writeVarBytes(&buff, in.PubKey)
+		writeVarBytes(&buff, in.Signature)
+	}
+
+	binary.Write(&buff, binary.BigEndian, uint32(len(tx.Vout)))
+	for _, out := range tx.Vout {
+		binary.Write(&buff, binary.BigEndian, int64(out.Value))
+		writeVarBytes(&buff, out.PubKeyHash)
+	}
+
+	return buff.Bytes()
+}
+
+// unsignedHash returns the hash the transaction ID is made of, which is taken before the inputs are signed
+func (tx *Transaction) unsignedHash() []byte {
+	txCopy := *tx
+	txCopy.Vin = make([]TXInput, len(tx.Vin))
+
+	for i, in := range tx.Vin {
+		in.Signature = nil
+		txCopy.Vin[i] = in
+	}
+
+	return txCopy.Hash()
+}
+
 // Sign signs a transaction by setting siganatures to all its transaction inputs
 func (tx *Transaction) Sign(privateKey ecdsa.PrivateKey, prevTxs map[string]Transaction) {
 	if tx.IsCoinbase() {
@@ -101,7 +141,8 @@ func (tx *Transaction) Sign(privateKey ecdsa.PrivateKey, prevTxs map[string]Tran
 		if err != nil {
 			log.Panic(err)
 		}
-		signature := append(r.Bytes(), s.Bytes()...)
+		// r and s are padded so the signature splits into its halves when it is verified
+		signature := padCurvePoints(privateKey.Curve, r, s)
 
 		tx.Vin[inID].Signature = signature
 		txCopy.Vin[inID].PubKey = nil
@@ -186,7 +227,29 @@ func (tx *Transaction) TrimmedCopy() Transaction {
 
 // NewCoinbaseTX initialzes a new transaction which is the first transaction of the blockchain.
 // It gives incentivce for mining the this genesis transaction
-func NewCoinbaseTX(to, data string) *Transaction {
+func NewCoinbaseTX(to, data string, value int) *Transaction {
+	return newCoinbaseTX(data, []TXOutput{*NewTXOutput(value, to)})
+}
+
+// NewAllocationCoinbaseTX creates a coinbase paying every address its allocated amount.
+// Outputs are ordered by address so the same allocations give the same transaction.
+func NewAllocationCoinbaseTX(allocations map[string]int, data string) *Transaction {
+	var addresses []string
+	var outputs []TXOutput
+
+	for address := range allocations {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+
+	for _, address := range addresses {
+		outputs = append(outputs, *NewTXOutput(allocations[address], address))
+	}
+
+	return newCoinbaseTX(data, outputs)
+}
+
+func newCoinbaseTX(data string, outputs []TXOutput) *Transaction {
 	if data == "" {
 		randData := make([]byte, 20)
 		_, err := rand.Read(randData)
@@ -200,16 +263,26 @@ func NewCoinbaseTX(to, data string) *Transaction {
 	txin := TXInput{
 		TxID:      []byte{},
 		Vout:      -1,
-		PubKey:    []byte(data),
+		PubKey:    append([]byte(data), make([]byte, extraNonceLen)...),
 		Signature: nil,
 	}
-	txout := NewTXOutput(subsidy, to)
-	tx := Transaction{nil, []TXInput{txin}, []TXOutput{*txout}}
+	tx := Transaction{nil, []TXInput{txin}, outputs}
 	tx.ID = tx.Hash()
 
 	return &tx
 }
 
+// SetExtraNonce stores the extranonce in the coinbase input data and updates the ID
+func (tx *Transaction) SetExtraNonce(extraNonce uint64) {
+	if !tx.IsCoinbase() || len(tx.Vin[0].PubKey) < extraNonceLen {
+		log.Panic("ERROR: Transaction has no extranonce")
+	}
+
+	data := tx.Vin[0].PubKey
+	binary.BigEndian.PutUint64(data[len(data)-extraNonceLen:], extraNonce)
+	tx.ID = tx.Hash()
+}
+
 // NewUTXOTransaction initializes a new unspent transction
 func NewUTXOTransaction(wallet *Wallet, to string, amount int, utxoSet *UTXOSet) *Transaction {
 	var inputs []TXInput
@@ -239,7 +312,7 @@ func NewUTXOTransaction(wallet *Wallet, to string, amount int, utxoSet *UTXOSet)
 		}
 	}
 
-	from := string(wallet.GetAddress())
+	from := string(wallet.GetAddress(utxoSet.Blockchain.Params))
 	outputs = append(outputs, *NewTXOutput(amount, to))
 	if accumulated > amount {
 		outputs = append(outputs, *NewTXOutput(accumulated-amount, from))
@@ -252,13 +325,13 @@ func NewUTXOTransaction(wallet *Wallet, to string, amount int, utxoSet *UTXOSet)
 	return &tx
 }
 
-// DeserializeTransaction deserializes a transaction
-func DeserializeTransaction(data []byte) Transaction {
+// DeserializeTransaction deserializes a transaction. Transactions received
+// from peers are deserialized too, so malformed data is returned as an error.
+func DeserializeTransaction(data []byte) (Transaction, error) {
 	var tx Transaction
 
 	decoder := gob.NewDecoder(bytes.NewReader(data))
 	err := decoder.Decode(&tx)
-	logPanicErr(err)
 
-	return tx
+	return tx, err
 }
@@ -0,0 +1,35 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestTransactionHash(t *testing.T) {
+	tx := Transaction{
+		Vin:  []TXInput{{bytes.Repeat([]byte{1}, 32), 1, []byte{2, 3}, []byte{4}}},
+		Vout: []TXOutput{{5, bytes.Repeat([]byte{6}, 20)}},
+	}
+
+	// The hash of the transaction, fixed so encodings can not drift between processes
+	const expected = "27faa3e1c2b841d1532823c7daec3a3e868341825624e2f919001ded1e87e695"
+
+	// Encoding other types with gob first must not change the hash
+	GobEncode(UTXOEntry{})
+	GobEncode(Block{})
+
+	if hash := hex.EncodeToString(tx.Hash()); hash != expected {
+		t.Errorf("Hash is %s, expected %s", hash, expected)
+	}
+
+	signed := tx
+	signed.Vin = []TXInput{tx.Vin[0]}
+	signed.Vin[0].Signature = []byte{7, 8}
+	if bytes.Equal(signed.Hash(), tx.Hash()) {
+		t.Error("Signature does not change the hash")
+	}
+	if !bytes.Equal(signed.unsignedHash(), (&Transaction{Vin: []TXInput{{tx.Vin[0].TxID, 1, []byte{2, 3}, nil}}, Vout: tx.Vout}).Hash()) {
+		t.Error("Unsigned hash depends on the signature")
+	}
+}
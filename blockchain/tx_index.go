@@ -0,0 +1,161 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"log"
+)
+
+const txIndexBucket = "txindex"
+
+var txIndexBucketName = []byte(txIndexBucket)
+
+// txIndexEntry locates a main chain transaction
+type txIndexEntry struct {
+	BlockHash []byte
+	Position  int
+}
+
+func deserializeTxIndexEntry(data []byte) txIndexEntry {
+	var entry txIndexEntry
+
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	err := decoder.Decode(&entry)
+	logPanicErr(err)
+
+	return entry
+}
+
+// indexTransactions adds the transactions of the block to the index
+func indexTransactions(txIndex StoreBucket, block *Block) error {
+	for i, tx := range block.Transactions {
+		err := txIndex.Put(tx.ID, GobEncode(txIndexEntry{block.Hash, i}))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// unindexTransactions removes the transactions of the block from the index
+func unindexTransactions(txIndex StoreBucket, block *Block) error {
+	for _, tx := range block.Transactions {
+		err := txIndex.Delete(tx.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// EnableTxIndex creates the transaction index from the main chain if it does not exist.
+// Once created the index is maintained as blocks are connected and disconnected.
+func (bc *Blockchain) EnableTxIndex() {
+	err := bc.store.Update(func(tx StoreTx) error {
+		if tx.Bucket(txIndexBucketName) != nil {
+			return nil
+		}
+
+		if prunedHeight(tx.Bucket(blocksBucketName)) >= 0 {
+			return errors.New("The transaction index can not be built on a pruned chain")
+		}
+
+		txIndex, err := tx.CreateBucket(txIndexBucketName)
+		if err != nil {
+			return err
+		}
+
+		b := tx.Bucket(blocksBucketName)
+		block, err := DeserializeBlock(b.Get(bc.tipHash()))
+		for err == nil && block != nil {
+			err = indexTransactions(txIndex, block)
+			if err != nil {
+				return err
+			}
+
+			block, err = parentBlock(b, block)
+		}
+
+		return err
+	})
+
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+// HasTxIndex returns whether the transaction index is maintained
+func (bc *Blockchain) HasTxIndex() bool {
+	hasIndex := false
+
+	err := bc.store.View(func(tx StoreTx) error {
+		hasIndex = tx.Bucket(txIndexBucketName) != nil
+		return nil
+	})
+	logPanicErr(err)
+
+	return hasIndex
+}
+
+// GetTransaction finds a main chain transaction and the block it is in,
+// using the transaction index when it is enabled
+func (bc *Blockchain) GetTransaction(ID []byte) (Transaction, Block, error) {
+	var entryData []byte
+	hasIndex := false
+
+	err := bc.store.View(func(tx StoreTx) error {
+		txIndex := tx.Bucket(txIndexBucketName)
+		if txIndex != nil {
+			hasIndex = true
+			entryData = txIndex.Get(ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return Transaction{}, Block{}, err
+	}
+
+	if !hasIndex {
+		return bc.scanTransaction(ID)
+	}
+
+	if entryData == nil {
+		return Transaction{}, Block{}, errors.New("Transaction is not found")
+	}
+
+	entry := deserializeTxIndexEntry(entryData)
+	block, err := bc.GetBlock(entry.BlockHash)
+	if err != nil {
+		return Transaction{}, Block{}, err
+	}
+
+	if block.IsPruned() {
+		return Transaction{}, Block{}, fmt.Errorf("Block %x of the transaction is pruned", block.Hash)
+	}
+
+	return *block.Transactions[entry.Position], block, nil
+}
+
+// scanTransaction walks the chain from the tip to find a transaction
+func (bc *Blockchain) scanTransaction(ID []byte) (Transaction, Block, error) {
+	bci := bc.Iterator()
+
+	for {
+		block := bci.Next()
+
+		for _, tx := range block.Transactions {
+			if bytes.Compare(tx.ID, ID) == 0 {
+				return *tx, *block, nil
+			}
+		}
+
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+	return Transaction{}, Block{}, errors.New("Transaction is not found")
+}
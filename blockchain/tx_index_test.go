@@ -0,0 +1,75 @@
+package blockchain
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestGetTransaction(t *testing.T) {
+	tests := []struct {
+		name  string
+		index func(bc *Blockchain)
+		build func(bc *Blockchain)
+	}{
+		{"maintained index", (*Blockchain).EnableTxIndex, func(*Blockchain) {}},
+		{"index built after the blocks", func(*Blockchain) {}, (*Blockchain).EnableTxIndex},
+		{"no index", func(*Blockchain) {}, func(*Blockchain) {}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bc, wallet := newTestBlockchain(t)
+			test.index(bc)
+			mineBlocks(bc, wallet, 1)
+			genesis, _ := bc.GetBlockByHeight(0)
+			fork, _ := bc.GetBlockByHeight(1)
+			funds := genesis.Transactions[0]
+
+			// The spend of the main chain is disconnected by a longer branch spending the output again
+			spend := spendTX(bc, wallet, funds.ID, []int{0}, 10)
+			bc.AddBlock(newTestBlock(bc, wallet, spend))
+
+			sideSpend := spendTX(bc, wallet, funds.ID, []int{0}, 9)
+			side2, err := NewBlock([]*Transaction{newTestCoinbase(bc, wallet)}, fork.Hash, 2, bc.Engine())
+			logPanicErr(err)
+			bc.AddBlock(side2)
+			side3, err := NewBlock([]*Transaction{newTestCoinbase(bc, wallet), sideSpend}, side2.Hash, 3, bc.Engine())
+			logPanicErr(err)
+			bc.AddBlock(side3)
+			if bytes.Compare(bc.tipHash(), side3.Hash) != 0 {
+				t.Fatal("Longer branch is not the tip")
+			}
+
+			test.build(bc)
+
+			lookups := []struct {
+				txID  []byte
+				block []byte
+			}{
+				{funds.ID, genesis.Hash},
+				{fork.Transactions[0].ID, fork.Hash},
+				{sideSpend.ID, side3.Hash},
+				{spend.ID, nil},
+				{[]byte("unknown"), nil},
+			}
+
+			for _, lookup := range lookups {
+				tx, block, err := bc.GetTransaction(lookup.txID)
+				if lookup.block == nil {
+					if err == nil {
+						t.Errorf("Transaction %x is found in block %x", lookup.txID, block.Hash)
+					}
+					continue
+				}
+
+				if err != nil {
+					t.Errorf("Transaction %x: %s", lookup.txID, err)
+					continue
+				}
+				if bytes.Compare(tx.ID, lookup.txID) != 0 || bytes.Compare(block.Hash, lookup.block) != 0 {
+					t.Errorf("Transaction %x is found as %x in block %x, expected block %x", lookup.txID, tx.ID, block.Hash, lookup.block)
+				}
+			}
+		})
+	}
+}
@@ -31,6 +31,19 @@ func GobEncode(data interface{}) []byte {
 	return buff.Bytes()
 }
 
+// GobDecode decodes data encoded with GobEncode into the value
+func GobDecode(data []byte, value interface{}) error {
+	dec := gob.NewDecoder(bytes.NewReader(data))
+
+	return dec.Decode(value)
+}
+
+// writeVarBytes writes the data prefixed with its length, for fixed binary encodings
+func writeVarBytes(buff *bytes.Buffer, data []byte) {
+	binary.Write(buff, binary.BigEndian, uint32(len(data)))
+	buff.Write(data)
+}
+
 func logPanicErr(err error) {
 	if err != nil {
 		log.Panic(err)
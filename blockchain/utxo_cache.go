@@ -0,0 +1,360 @@
+package blockchain
+
+import (
+	"fmt"
+	"sync"
+)
+
+// DefaultUTXOCacheSize is the default memory budget of the UTXO cache in bytes
+const DefaultUTXOCacheSize = 32 << 20
+
+// utxoCacheEntryOverhead approximates the memory a cached entry takes besides its key and script
+const utxoCacheEntryOverhead = 96
+
+// UTXOCacheStats are the counters of a UTXO cache
+type UTXOCacheStats struct {
+	Hits    int
+	Misses  int
+	Entries int
+	Dirty   int
+	Size    int
+	Flushes int
+}
+
+// utxoCacheEntry is a cached output. Spent entries are kept until they are flushed.
+type utxoCacheEntry struct {
+	entry UTXOEntry
+	spent bool
+	dirty bool
+}
+
+func (e *utxoCacheEntry) size(key string) int {
+	return len(key) + len(e.entry.PubKeyHash) + utxoCacheEntryOverhead
+}
+
+// UTXOCache is a write-back cache in front of the UTXO bucket. Changes of connected
+// blocks stay in memory and are flushed at block boundaries, once the cache grows over
+// its budget, and when the blockchain is closed. The best block marker is only moved
+// by flushes, so a node stopping with unflushed changes repairs its chainstate on start.
+type UTXOCache struct {
+	mu      sync.Mutex
+	entries map[string]*utxoCacheEntry
+	size    int
+	budget  int
+
+	// best is the block the cached chainstate is at, state is the UTXO set state there
+	best  []byte
+	state *utxoSetState
+
+	stats UTXOCacheStats
+}
+
+// NewUTXOCache returns an empty cache with the memory budget in bytes
+func NewUTXOCache(budget int) *UTXOCache {
+	return &UTXOCache{
+		entries: make(map[string]*utxoCacheEntry),
+		budget:  budget,
+	}
+}
+
+// Stats returns the cache counters
+func (c *UTXOCache) Stats() UTXOCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Entries = len(c.entries)
+	stats.Size = c.size
+	stats.Dirty = 0
+	for _, e := range c.entries {
+		if e.dirty {
+			stats.Dirty++
+		}
+	}
+
+	return stats
+}
+
+// String returns the cache counters in a line
+func (s UTXOCacheStats) String() string {
+	return fmt.Sprintf("UTXO cache: %d entries, %d dirty, %d bytes, %d hits, %d misses, %d flushes",
+		s.Entries, s.Dirty, s.Size, s.Hits, s.Misses, s.Flushes)
+}
+
+// set stores the entry under the key, c.mu must be held
+func (c *UTXOCache) set(key string, e *utxoCacheEntry) {
+	if old := c.entries[key]; old != nil {
+		c.size -= old.size(key)
+	}
+
+	c.entries[key] = e
+	c.size += e.size(key)
+}
+
+// reset drops every entry, including unflushed ones
+func (c *UTXOCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[string]*utxoCacheEntry)
+	c.size = 0
+	c.best = nil
+	c.state = nil
+}
+
+// commit merges the changes of a view into the cache and moves it to the block
+func (c *UTXOCache) commit(view *cachedUTXOStore, best []byte, state *utxoSetState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, e := range view.changes {
+		c.set(key, e)
+	}
+	c.best = best
+	c.state = state
+}
+
+// setState returns a copy of the UTXO set state of the unflushed blocks, nil when there are none
+func (c *UTXOCache) setState() *utxoSetState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state == nil {
+		return nil
+	}
+
+	return c.state.clone()
+}
+
+// flushNeeded returns whether the cache is over its budget
+func (c *UTXOCache) flushNeeded() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.size > c.budget
+}
+
+// write writes the dirty entries, the best block marker and the UTXO set state in the store transaction.
+// The entries stay dirty until flushed is called once the transaction commits.
+func (c *UTXOCache) write(tx StoreTx) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.best == nil {
+		return nil
+	}
+
+	utxos := chainstate(tx)
+	for key, e := range c.entries {
+		if !e.dirty {
+			continue
+		}
+
+		txID, vout := splitOutpointKey([]byte(key))
+
+		var err error
+		if e.spent {
+			err = utxos.Delete(txID, vout)
+		} else {
+			err = utxos.Put(txID, vout, e.entry)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	info := tx.Bucket(chainstateInfoBucketName)
+	err := info.Put(bestBlockKey, c.best)
+	if err != nil {
+		return err
+	}
+
+	return info.Put(utxoSetStateKey, c.state.Serialize())
+}
+
+// flushed marks the written entries clean and drops the spent ones, then drops every
+// entry when the cache is over its budget
+func (c *UTXOCache) flushed() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.best == nil {
+		return
+	}
+
+	for key, e := range c.entries {
+		if e.spent {
+			c.size -= e.size(key)
+			delete(c.entries, key)
+		} else {
+			e.dirty = false
+		}
+	}
+
+	if c.size > c.budget {
+		c.entries = make(map[string]*utxoCacheEntry)
+		c.size = 0
+	}
+	c.stats.Flushes++
+}
+
+// cachedUTXOStore reads through the cache to the UTXO bucket of a store transaction.
+// Writes collect in changes, which are merged into the cache once the block connects.
+type cachedUTXOStore struct {
+	cache    *UTXOCache
+	base     UTXOStore
+	writable bool
+	changes  map[string]*utxoCacheEntry
+}
+
+func newCachedUTXOStore(cache *UTXOCache, tx StoreTx, writable bool) *cachedUTXOStore {
+	return &cachedUTXOStore{
+		cache:    cache,
+		base:     chainstate(tx),
+		writable: writable,
+		changes:  make(map[string]*utxoCacheEntry),
+	}
+}
+
+func (s *cachedUTXOStore) Get(txID []byte, vout int) (UTXOEntry, bool) {
+	key := string(outpointKey(txID, vout))
+	if e := s.changes[key]; e != nil {
+		return e.entry, !e.spent
+	}
+
+	s.cache.mu.Lock()
+	defer s.cache.mu.Unlock()
+
+	if e := s.cache.entries[key]; e != nil {
+		s.cache.stats.Hits++
+		return e.entry, !e.spent
+	}
+
+	s.cache.stats.Misses++
+	entry, ok := s.base.Get(txID, vout)
+
+	// Only writers see the latest bucket, readers may run on an older snapshot
+	if ok && s.writable {
+		s.cache.set(key, &utxoCacheEntry{entry: entry})
+	}
+
+	return entry, ok
+}
+
+func (s *cachedUTXOStore) Put(txID []byte, vout int, entry UTXOEntry) error {
+	if !s.writable {
+		return ErrStoreNotWritable
+	}
+
+	s.changes[string(outpointKey(txID, vout))] = &utxoCacheEntry{entry: entry, dirty: true}
+	return nil
+}
+
+func (s *cachedUTXOStore) Delete(txID []byte, vout int) error {
+	if !s.writable {
+		return ErrStoreNotWritable
+	}
+
+	s.changes[string(outpointKey(txID, vout))] = &utxoCacheEntry{spent: true, dirty: true}
+	return nil
+}
+
+// ForEach merges the bucket with the cache and the pending changes
+func (s *cachedUTXOStore) ForEach(fn func(txID []byte, vout int, entry UTXOEntry) error) error {
+	s.cache.mu.Lock()
+	defer s.cache.mu.Unlock()
+
+	lookup := func(key string) *utxoCacheEntry {
+		if e := s.changes[key]; e != nil {
+			return e
+		}
+		return s.cache.entries[key]
+	}
+
+	err := s.base.ForEach(func(txID []byte, vout int, entry UTXOEntry) error {
+		if e := lookup(string(outpointKey(txID, vout))); e != nil {
+			if e.spent {
+				return nil
+			}
+			entry = e.entry
+		}
+		return fn(txID, vout, entry)
+	})
+	if err != nil {
+		return err
+	}
+
+	// Outputs not flushed to the bucket yet
+	for _, entries := range []map[string]*utxoCacheEntry{s.changes, s.cache.entries} {
+		for key, e := range entries {
+			txID, vout := splitOutpointKey([]byte(key))
+			if e.spent || lookup(key) != e {
+				continue
+			}
+			if _, ok := s.base.Get(txID, vout); ok {
+				continue
+			}
+
+			err = fn(txID, vout, e.entry)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// utxoStore returns the UTXO store of the store transaction, through the cache when it is enabled
+func (bc *Blockchain) utxoStore(tx StoreTx, writable bool) UTXOStore {
+	if bc.utxoCache == nil {
+		return chainstate(tx)
+	}
+
+	return newCachedUTXOStore(bc.utxoCache, tx, writable)
+}
+
+// SetUTXOCacheSize sets the memory budget of the UTXO cache in bytes, 0 disables the cache
+func (bc *Blockchain) SetUTXOCacheSize(size int) {
+	bc.FlushUTXOCache()
+
+	if size <= 0 {
+		bc.utxoCache = nil
+		return
+	}
+
+	bc.utxoCache = NewUTXOCache(size)
+}
+
+// FlushUTXOCache writes the unflushed changes of the UTXO cache
+func (bc *Blockchain) FlushUTXOCache() {
+	if bc.utxoCache == nil {
+		return
+	}
+
+	bc.chainLock.Lock()
+	defer bc.chainLock.Unlock()
+
+	err := bc.flushUTXOCache(bc.utxoCache)
+	logPanicErr(err)
+}
+
+// flushUTXOCache writes the unflushed changes of the cache, bc.chainLock must be held
+func (bc *Blockchain) flushUTXOCache(cache *UTXOCache) error {
+	err := bc.store.Update(cache.write)
+	if err != nil {
+		return err
+	}
+	cache.flushed()
+
+	return nil
+}
+
+// UTXOCacheStats returns the counters of the UTXO cache
+func (bc *Blockchain) UTXOCacheStats() UTXOCacheStats {
+	if bc.utxoCache == nil {
+		return UTXOCacheStats{}
+	}
+
+	return bc.utxoCache.Stats()
+}
@@ -0,0 +1,145 @@
+package blockchain
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestUTXOCache(t *testing.T) {
+	txID := []byte("transaction")
+	entry := func(vout int) UTXOEntry { return UTXOEntry{Value: 10 + vout, PubKeyHash: []byte{byte(vout)}} }
+
+	tests := []struct {
+		name   string
+		budget int
+		put    []int
+		spend  []int
+		commit bool
+		flush  string
+
+		cached  []int
+		stored  []int
+		dirty   int
+		entries int
+	}{
+		{"put", DefaultUTXOCacheSize, []int{2}, nil, true, "", []int{0, 1, 2}, []int{0, 1}, 1, 2},
+		{"spend", DefaultUTXOCacheSize, nil, []int{0}, true, "", []int{1}, []int{0, 1}, 1, 2},
+		{"spend new output", DefaultUTXOCacheSize, []int{2}, []int{2}, true, "", []int{0, 1}, []int{0, 1}, 1, 2},
+		{"uncommitted", DefaultUTXOCacheSize, []int{2}, []int{0}, false, "", []int{0, 1}, []int{0, 1}, 0, 1},
+		{"flush put", DefaultUTXOCacheSize, []int{2}, nil, true, "commit", []int{0, 1, 2}, []int{0, 1, 2}, 0, 2},
+		{"flush spend", DefaultUTXOCacheSize, nil, []int{0}, true, "commit", []int{1}, []int{1}, 0, 1},
+		{"failed flush", DefaultUTXOCacheSize, []int{2}, []int{0}, true, "rollback", []int{1, 2}, []int{0, 1}, 2, 3},
+		{"flush over budget", 1, []int{2}, []int{0}, true, "commit", []int{1, 2}, []int{1, 2}, 0, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := NewMemoryStore()
+			cache := NewUTXOCache(test.budget)
+
+			err := store.Update(func(tx StoreTx) error {
+				err := createChainstate(tx)
+				if err != nil {
+					return err
+				}
+
+				for _, vout := range []int{0, 1} {
+					err = chainstate(tx).Put(txID, vout, entry(vout))
+					if err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			logPanicErr(err)
+
+			// Reading an output caches it
+			err = store.Update(func(tx StoreTx) error {
+				view := newCachedUTXOStore(cache, tx, true)
+				view.Get(txID, 1)
+
+				for _, vout := range test.put {
+					err := view.Put(txID, vout, entry(vout))
+					if err != nil {
+						return err
+					}
+				}
+				for _, vout := range test.spend {
+					err := view.Delete(txID, vout)
+					if err != nil {
+						return err
+					}
+				}
+
+				if test.commit {
+					cache.commit(view, []byte("best"), newUTXOSetState())
+				}
+				return nil
+			})
+			logPanicErr(err)
+
+			switch test.flush {
+			case "commit":
+				err = store.Update(cache.write)
+				logPanicErr(err)
+				cache.flushed()
+			case "rollback":
+				err = store.Update(func(tx StoreTx) error {
+					err := cache.write(tx)
+					if err != nil {
+						return err
+					}
+					return errors.New("failed after the write")
+				})
+				if err == nil {
+					t.Fatal("Flush did not fail")
+				}
+			}
+
+			var cached, stored []int
+			err = store.View(func(tx StoreTx) error {
+				view := newCachedUTXOStore(cache, tx, false)
+				listed := make(map[int]bool)
+				err := view.ForEach(func(k []byte, vout int, e UTXOEntry) error {
+					if !reflect.DeepEqual(e, entry(vout)) {
+						t.Errorf("Output %d is %v", vout, e)
+					}
+					cached = append(cached, vout)
+					listed[vout] = true
+					return nil
+				})
+				if err != nil {
+					return err
+				}
+
+				for vout := 0; vout <= 2; vout++ {
+					if _, ok := view.Get(txID, vout); ok != listed[vout] {
+						t.Errorf("Output %d is unspent: %t, listed: %t", vout, ok, listed[vout])
+					}
+				}
+
+				return chainstate(tx).ForEach(func(k []byte, vout int, e UTXOEntry) error {
+					stored = append(stored, vout)
+					return nil
+				})
+			})
+			logPanicErr(err)
+
+			sort.Ints(cached)
+			sort.Ints(stored)
+			if !reflect.DeepEqual(cached, test.cached) {
+				t.Errorf("Cached outputs %v, expected %v", cached, test.cached)
+			}
+			if !reflect.DeepEqual(stored, test.stored) {
+				t.Errorf("Stored outputs %v, expected %v", stored, test.stored)
+			}
+
+			stats := cache.Stats()
+			if stats.Dirty != test.dirty || stats.Entries != test.entries {
+				t.Errorf("Cache has %d entries, %d dirty, expected %d, %d dirty", stats.Entries, stats.Dirty, test.entries, test.dirty)
+			}
+		})
+	}
+}
@@ -1,13 +1,16 @@
 package blockchain
 
 import (
+	"bytes"
 	"encoding/hex"
+	"fmt"
 	"log"
-
-	"github.com/boltdb/bolt"
 )
 
-const utxoSetBucket = "chainstate"
+const utxoSetBucket = "utxo"
+
+// reindexProgressInterval is the number of blocks between Reindex progress reports
+const reindexProgressInterval = 1000
 
 var utxoBucketName = []byte(utxoSetBucket)
 
@@ -16,52 +19,126 @@ type UTXOSet struct {
 	Blockchain *Blockchain
 }
 
-// Reindex rebuilds the UTXOSet
+// Reindex rebuilds the UTXOSet and the undo data by replaying the main chain.
+// Blocks update the UTXO set incrementally as they are connected, so this is
+// only needed to recover a damaged chainstate.
 func (us UTXOSet) Reindex() {
+	bc := us.Blockchain
+	if bc.PrunedHeight() >= 0 {
+		log.Panic("ERROR: The chain is pruned, the UTXO set can not be rebuilt")
+	}
+	total := bc.GetBestHeight() + 1
+
+	bc.chainLock.Lock()
+	defer bc.chainLock.Unlock()
+
+	// Unflushed changes are replaced by the replay
+	if bc.utxoCache != nil {
+		bc.utxoCache.reset()
+	}
+
+	err := bc.store.Update(func(tx StoreTx) error {
+		// Delete and create bucket content again
+		err := createChainstate(tx)
+		if err != nil {
+			return err
+		}
 
-	// Delete and create bucket content again
+		utxos := &hashedUTXOStore{chainstate(tx), newUTXOSetState()}
+		b := tx.Bucket(blocksBucketName)
+		c := tx.Bucket(heightIndexBucketName).Cursor()
+		for k, hash := c.First(); k != nil; k, hash = c.Next() {
+			block, err := DeserializeBlock(b.Get(hash))
+			if err != nil {
+				return err
+			}
 
-	err := us.Blockchain.DB.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket(utxoBucketName)
+			err = connectBlockUTXOs(tx, utxos, block)
+			if err != nil {
+				return err
+			}
 
-		if b != nil {
-			tx.DeleteBucket(utxoBucketName)
+			if (block.Height+1)%reindexProgressInterval == 0 || block.Height+1 == total {
+				fmt.Printf("Reindexed %d of %d blocks\n", block.Height+1, total)
+			}
 		}
 
-		_, err := tx.CreateBucket(utxoBucketName)
+		info := tx.Bucket(chainstateInfoBucketName)
+		err = info.Put(utxoSetStateKey, utxos.state.Serialize())
+		if err != nil {
+			return err
+		}
 
-		return err
+		return info.Put(bestBlockKey, bc.tipHash())
 	})
 
 	if err != nil {
 		log.Panic(err)
 	}
+}
+
+// catchUp connects the main chain blocks after the block the chainstate is at, repairing
+// a chainstate left behind by unflushed changes without replaying the whole chain.
+// It returns false when the chainstate is not at a main chain block.
+func (us UTXOSet) catchUp(best []byte) bool {
+	bc := us.Blockchain
+	caughtUp := false
 
-	// Get unspent transactions from blockchain in format of map[string]Outputs,
-	// where the key is the transaction id, value is transaction outputs
-	utxo := us.Blockchain.FindUTXO()
+	err := bc.store.Update(func(tx StoreTx) error {
+		b := tx.Bucket(blocksBucketName)
+		heights := tx.Bucket(heightIndexBucketName)
 
-	// Store the utxo set in db bucket, using put
-	err = us.Blockchain.DB.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket(utxoBucketName)
+		bestData := b.Get(best)
+		if bestData == nil {
+			return nil
+		}
+		bestBlock, err := DeserializeBlock(bestData)
+		if err != nil {
+			return err
+		}
+		if bytes.Compare(heights.Get(heightKey(bestBlock.Height)), best) != 0 {
+			return nil
+		}
+
+		state, err := storedUTXOSetState(tx)
+		if err != nil || state == nil {
+			return err
+		}
 
-		for txID, outs := range utxo {
-			key, err := hex.DecodeString(txID)
+		utxos := &hashedUTXOStore{chainstate(tx), state}
+		tip, err := DeserializeBlock(b.Get(bc.tipHash()))
+		if err != nil {
+			return err
+		}
+		for height := bestBlock.Height + 1; height <= tip.Height; height++ {
+			block, err := DeserializeBlock(b.Get(heights.Get(heightKey(height))))
 			if err != nil {
-				log.Panic(err)
+				return err
+			}
+			if block.IsPruned() {
+				return fmt.Errorf("Block %x is pruned", block.Hash)
 			}
 
-			err = b.Put(key, outs.Serialize())
+			err = connectBlockUTXOs(tx, utxos, block)
 			if err != nil {
-				log.Panic(err)
+				return err
 			}
 		}
-		return nil
-	})
 
+		caughtUp = true
+		info := tx.Bucket(chainstateInfoBucketName)
+		err = info.Put(utxoSetStateKey, state.Serialize())
+		if err != nil {
+			return err
+		}
+
+		return info.Put(bestBlockKey, bc.tipHash())
+	})
 	if err != nil {
 		log.Panic(err)
 	}
+
+	return caughtUp
 }
 
 // FindSpendableOutputs finds and returns unspent outputs to reference in inputs.
@@ -69,23 +146,18 @@ func (us UTXOSet) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[
 	var unspentOutputs = make(map[string][]int)
 	accumulated := 0
 
-	// Get the unspent outputs from db by bucket
-	err := us.Blockchain.DB.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket(utxoBucketName)
-		c := b.Cursor()
-
-		for k, v := c.First(); k != nil; k, v = c.Next() {
+	// Get the unspent outputs from the chainstate
+	err := us.Blockchain.store.View(func(tx StoreTx) error {
+		return us.Blockchain.utxoStore(tx, false).ForEach(func(k []byte, vout int, entry UTXOEntry) error {
 			txID := hex.EncodeToString(k)
-			outs := DeserializeOutputs(v)
 
-			for outID, out := range outs.Outputs {
-				if out.IsLockedWithKey(pubKeyHash) && accumulated < amount {
-					accumulated += out.Value
-					unspentOutputs[txID] = append(unspentOutputs[txID], outID)
-				}
+			out := entry.Output()
+			if out.IsLockedWithKey(pubKeyHash) && accumulated < amount {
+				accumulated += entry.Value
+				unspentOutputs[txID] = append(unspentOutputs[txID], vout)
 			}
-		}
-		return nil
+			return nil
+		})
 	})
 
 	if err != nil {
@@ -99,21 +171,14 @@ func (us UTXOSet) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[
 func (us UTXOSet) FindUTXO(pubKeyHash []byte) []TXOutput {
 	var utxo []TXOutput
 
-	err := us.Blockchain.DB.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket(utxoBucketName)
-		c := b.Cursor()
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			outs := DeserializeOutputs(v)
-
-			for _, out := range outs.Outputs {
-				if out.IsLockedWithKey(pubKeyHash) {
-					utxo = append(utxo, out)
-				}
+	err := us.Blockchain.store.View(func(tx StoreTx) error {
+		return us.Blockchain.utxoStore(tx, false).ForEach(func(k []byte, vout int, entry UTXOEntry) error {
+			out := entry.Output()
+			if out.IsLockedWithKey(pubKeyHash) {
+				utxo = append(utxo, out)
 			}
-		}
-
-		return nil
-
+			return nil
+		})
 	})
 
 	if err != nil {
@@ -123,68 +188,52 @@ func (us UTXOSet) FindUTXO(pubKeyHash []byte) []TXOutput {
 	return utxo
 }
 
-// Update updates the UTXO set with transactions from the Block
-// The Block is considered to be the tip of a blockchain
-func (us UTXOSet) Update(block *Block) {
-
-	err := us.Blockchain.DB.Update(func(tx *bolt.Tx) error {
-		var err error
-		b := tx.Bucket(utxoBucketName)
-
-		for _, tx := range block.Transactions {
-			// If it is not coin base transction, get outputs of every transaction in the block
-			// Delete unspent outputs if they are spent and store new unspent outputs
-			if !tx.IsCoinbase() {
-				for _, in := range tx.Vin {
-					updatedOuts := TXOutputs{}
-
-					outBytes := b.Get(in.TxID)
-					outs := DeserializeOutputs(outBytes)
-
-					for outID, out := range outs.Outputs {
-						if outID != in.Vout {
-							updatedOuts.Outputs = append(updatedOuts.Outputs, out)
-						}
-					}
-
-					if len(updatedOuts.Outputs) == 0 {
-						err = b.Delete(in.TxID)
-					} else {
-						err = b.Put(in.TxID, updatedOuts.Serialize())
-					}
-
-				}
-			}
+// GetUTXO returns the unspent output at the outpoint, false if it is spent or does not exist
+func (us UTXOSet) GetUTXO(txID []byte, vout int) (UTXOEntry, bool) {
+	var entry UTXOEntry
+	found := false
+
+	err := us.Blockchain.store.View(func(tx StoreTx) error {
+		entry, found = us.Blockchain.utxoStore(tx, false).Get(txID, vout)
+		return nil
+	})
 
-			// If it is coin base transction, just store the unspent output
-			updatedOuts := TXOutputs{}
+	if err != nil {
+		log.Panic(err)
+	}
 
-			for _, out := range tx.Vout {
-				updatedOuts.Outputs = append(updatedOuts.Outputs, out)
-			}
+	return entry, found
+}
 
-			err = b.Put(tx.ID, updatedOuts.Serialize())
-		}
+// Confirmations returns the number of blocks on top of and including the block of the entry
+func (us UTXOSet) Confirmations(entry UTXOEntry) int {
+	return us.Blockchain.GetBestHeight() - entry.Height + 1
+}
 
-		return err
+// CountTransactions returns the number of transactions with unspent outputs in the UTXO set
+func (us UTXOSet) CountTransactions() int {
+	txs := make(map[string]bool)
+	err := us.Blockchain.store.View(func(tx StoreTx) error {
+		return us.Blockchain.utxoStore(tx, false).ForEach(func(k []byte, vout int, entry UTXOEntry) error {
+			txs[string(k)] = true
+			return nil
+		})
 	})
 
 	if err != nil {
 		log.Panic(err)
 	}
+	return len(txs)
 }
 
-// CountTransactions returns the number of transactions in the UTXO set
-func (us UTXOSet) CountTransactions() int {
+// CountOutputs returns the number of unspent outputs in the UTXO set
+func (us UTXOSet) CountOutputs() int {
 	count := 0
-	err := us.Blockchain.DB.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket(utxoBucketName)
-		c := b.Cursor()
-
-		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+	err := us.Blockchain.store.View(func(tx StoreTx) error {
+		return us.Blockchain.utxoStore(tx, false).ForEach(func(k []byte, vout int, entry UTXOEntry) error {
 			count++
-		}
-		return nil
+			return nil
+		})
 	})
 
 	if err != nil {
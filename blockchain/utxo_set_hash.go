@@ -0,0 +1,242 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strings"
+)
+
+// utxoCommitmentPrefix starts the coinbase data committing to the UTXO set hash
+const utxoCommitmentPrefix = "utxo:"
+
+// utxoSetStateKey holds the UTXO set state in the chainstate info bucket, next to the best block marker
+var utxoSetStateKey = []byte("state")
+
+// utxoSetState is the rolling hash of the UTXO set with its number of outputs and total amount
+type utxoSetState struct {
+	hash    *MuHash
+	outputs int
+	amount  int
+}
+
+type utxoSetStateData struct {
+	Hash    []byte
+	Outputs int
+	Amount  int
+}
+
+func newUTXOSetState() *utxoSetState {
+	return &utxoSetState{hash: NewMuHash()}
+}
+
+// utxoHashElement encodes an unspent output for hashing. A fixed binary encoding is
+// used since gob encodings depend on the types encoded before in the process.
+func utxoHashElement(txID []byte, vout int, entry UTXOEntry) []byte {
+	var buff bytes.Buffer
+
+	buff.Write(txID)
+	binary.Write(&buff, binary.BigEndian, uint32(vout))
+	binary.Write(&buff, binary.BigEndian, uint64(entry.Value))
+	binary.Write(&buff, binary.BigEndian, uint32(entry.Height))
+	if entry.Coinbase {
+		buff.WriteByte(1)
+	} else {
+		buff.WriteByte(0)
+	}
+	writeVarBytes(&buff, entry.PubKeyHash)
+
+	return buff.Bytes()
+}
+
+func (s *utxoSetState) add(txID []byte, vout int, entry UTXOEntry) {
+	s.hash.Add(utxoHashElement(txID, vout, entry))
+	s.outputs++
+	s.amount += entry.Value
+}
+
+func (s *utxoSetState) remove(txID []byte, vout int, entry UTXOEntry) {
+	s.hash.Remove(utxoHashElement(txID, vout, entry))
+	s.outputs--
+	s.amount -= entry.Value
+}
+
+func (s *utxoSetState) clone() *utxoSetState {
+	return &utxoSetState{s.hash.Clone(), s.outputs, s.amount}
+}
+
+// Serialize serializes the UTXO set state
+func (s *utxoSetState) Serialize() []byte {
+	return GobEncode(utxoSetStateData{s.hash.Serialize(), s.outputs, s.amount})
+}
+
+func deserializeUTXOSetState(data []byte) (*utxoSetState, error) {
+	var stateData utxoSetStateData
+
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&stateData)
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := DeserializeMuHash(stateData.Hash)
+	if err != nil {
+		return nil, err
+	}
+
+	return &utxoSetState{hash, stateData.Outputs, stateData.Amount}, nil
+}
+
+// hashedUTXOStore keeps a UTXO set state in step with the writes to a UTXO store
+type hashedUTXOStore struct {
+	UTXOStore
+	state *utxoSetState
+}
+
+func (s *hashedUTXOStore) Put(txID []byte, vout int, entry UTXOEntry) error {
+	old, replaced := s.UTXOStore.Get(txID, vout)
+
+	err := s.UTXOStore.Put(txID, vout, entry)
+	if err != nil {
+		return err
+	}
+
+	if replaced {
+		s.state.remove(txID, vout, old)
+	}
+	s.state.add(txID, vout, entry)
+
+	return nil
+}
+
+func (s *hashedUTXOStore) Delete(txID []byte, vout int) error {
+	old, found := s.UTXOStore.Get(txID, vout)
+
+	err := s.UTXOStore.Delete(txID, vout)
+	if err != nil {
+		return err
+	}
+
+	if found {
+		s.state.remove(txID, vout, old)
+	}
+
+	return nil
+}
+
+// storedUTXOSetState returns the UTXO set state of the chainstate bucket, nil when it is missing
+func storedUTXOSetState(tx StoreTx) (*utxoSetState, error) {
+	data := tx.Bucket(chainstateInfoBucketName).Get(utxoSetStateKey)
+	if data == nil {
+		return nil, nil
+	}
+
+	return deserializeUTXOSetState(data)
+}
+
+// utxoSetState returns a copy of the UTXO set state at the tip, taken from the cache when it
+// holds unflushed blocks. A chainstate without a state is empty.
+func (bc *Blockchain) utxoSetState(tx StoreTx) (*utxoSetState, error) {
+	if bc.utxoCache != nil {
+		if state := bc.utxoCache.setState(); state != nil {
+			return state, nil
+		}
+	}
+
+	state, err := storedUTXOSetState(tx)
+	if err != nil || state == nil {
+		return newUTXOSetState(), err
+	}
+
+	return state, nil
+}
+
+// UTXOSetInfo describes the UTXO set at the tip
+type UTXOSetInfo struct {
+	Height    int
+	BestBlock []byte
+	Outputs   int
+	Amount    int
+	Hash      []byte
+}
+
+// GetUTXOSetInfo returns the size, total amount and rolling hash of the UTXO set. The state
+// and the tip are read together, so that a block connected meanwhile is not half counted.
+func (bc *Blockchain) GetUTXOSetInfo() UTXOSetInfo {
+	var info UTXOSetInfo
+
+	bc.chainLock.Lock()
+	defer bc.chainLock.Unlock()
+
+	err := bc.store.View(func(tx StoreTx) error {
+		state, err := bc.utxoSetState(tx)
+		if err != nil {
+			return err
+		}
+
+		tip, err := DeserializeBlock(tx.Bucket(blocksBucketName).Get(bc.tipHash()))
+		if err != nil {
+			return err
+		}
+
+		info = UTXOSetInfo{
+			Height:    tip.Height,
+			BestBlock: tip.Hash,
+			Outputs:   state.outputs,
+			Amount:    state.amount,
+			Hash:      state.hash.Digest(),
+		}
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return info
+}
+
+// utxoCommitment returns the coinbase data prefix committing to the UTXO set at the tip,
+// empty when the network does not commit to it
+func (bc *Blockchain) utxoCommitment() string {
+	return bc.utxoCommitmentTo(bc.GetUTXOSetInfo().Hash)
+}
+
+// utxoCommitmentTo returns the coinbase data prefix committing to the UTXO set hash
+func (bc *Blockchain) utxoCommitmentTo(hash []byte) string {
+	if !bc.Params.UTXOCommitment {
+		return ""
+	}
+
+	return utxoCommitmentPrefix + hex.EncodeToString(hash)
+}
+
+// commitUTXOSet prefixes the coinbase data of the transactions with the UTXO commitment
+func (bc *Blockchain) commitUTXOSet(transactions []*Transaction) {
+	commitment := bc.utxoCommitment()
+	if commitment == "" {
+		return
+	}
+
+	for _, tx := range transactions {
+		if tx.IsCoinbase() && !strings.HasPrefix(string(tx.Vin[0].PubKey), commitment) {
+			tx.Vin[0].PubKey = append([]byte(commitment), tx.Vin[0].PubKey...)
+			tx.ID = tx.Hash()
+		}
+	}
+}
+
+// verifyUTXOCommitment checks that the coinbase of a block commits to the UTXO set state before it
+func (bc *Blockchain) verifyUTXOCommitment(coinbase *Transaction, state *utxoSetState) error {
+	commitment := bc.utxoCommitmentTo(state.hash.Digest())
+	if commitment == "" {
+		return nil
+	}
+
+	if !strings.HasPrefix(string(coinbase.Vin[0].PubKey), commitment) {
+		return errors.New("Coinbase does not commit to the UTXO set")
+	}
+
+	return nil
+}
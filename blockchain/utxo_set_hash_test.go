@@ -0,0 +1,136 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+type testOutput struct {
+	txID  []byte
+	vout  int
+	entry UTXOEntry
+}
+
+var testOutputs = []testOutput{
+	{bytes.Repeat([]byte{1}, 32), 0, UTXOEntry{10, bytes.Repeat([]byte{0xa}, 20), 0, true}},
+	{bytes.Repeat([]byte{2}, 32), 1, UTXOEntry{7, bytes.Repeat([]byte{0xb}, 20), 3, false}},
+	{bytes.Repeat([]byte{3}, 32), 2, UTXOEntry{0, nil, 12, false}},
+}
+
+func TestUTXOHashElement(t *testing.T) {
+	expected := "0101010101010101010101010101010101010101010101010101010101010101" +
+		"00000000" + "000000000000000a" + "00000000" + "01" +
+		"00000014" + "0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a"
+
+	out := testOutputs[0]
+	element := hex.EncodeToString(utxoHashElement(out.txID, out.vout, out.entry))
+	if element != expected {
+		t.Errorf("Element is %s, expected %s", element, expected)
+	}
+}
+
+func TestUTXOSetStateOrder(t *testing.T) {
+	// The digest of the test outputs, fixed so encodings can not drift between processes
+	const expected = "e14d679ec187c5b29598d7f43fb59cbdb83066a389995e4dc6b197856232cf87"
+
+	tests := []struct {
+		name  string
+		order []int
+	}{
+		{"in order", []int{0, 1, 2}},
+		{"reversed", []int{2, 1, 0}},
+		{"mixed", []int{1, 2, 0}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// Encoding other types with gob first must not change the digest
+			GobEncode(Block{})
+			GobEncode(Transaction{})
+
+			state := newUTXOSetState()
+			for _, i := range test.order {
+				out := testOutputs[i]
+				state.add(out.txID, out.vout, out.entry)
+			}
+
+			digest := hex.EncodeToString(state.hash.Digest())
+			if digest != expected {
+				t.Errorf("Digest is %s, expected %s", digest, expected)
+			}
+			if state.outputs != 3 || state.amount != 17 {
+				t.Errorf("State has %d outputs of %d, expected 3 of 17", state.outputs, state.amount)
+			}
+		})
+	}
+}
+
+func TestUTXOSetStateRemove(t *testing.T) {
+	state := newUTXOSetState()
+	for _, out := range testOutputs {
+		state.add(out.txID, out.vout, out.entry)
+	}
+	state.remove(testOutputs[1].txID, testOutputs[1].vout, testOutputs[1].entry)
+
+	expected := newUTXOSetState()
+	expected.add(testOutputs[2].txID, testOutputs[2].vout, testOutputs[2].entry)
+	expected.add(testOutputs[0].txID, testOutputs[0].vout, testOutputs[0].entry)
+
+	if !bytes.Equal(state.hash.Digest(), expected.hash.Digest()) {
+		t.Error("Removing an output gives a different digest than not adding it")
+	}
+
+	restored, err := deserializeUTXOSetState(state.Serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(restored.hash.Digest(), state.hash.Digest()) || restored.outputs != 2 || restored.amount != 10 {
+		t.Error("Deserialized state differs")
+	}
+}
+
+func TestUTXOSetHashReindex(t *testing.T) {
+	bc, wallet := newTestBlockchain(t)
+	mineBlocks(bc, wallet, 10)
+
+	info := bc.GetUTXOSetInfo()
+	if info.Outputs != 11 || info.Amount != 110 {
+		t.Fatalf("UTXO set has %d outputs of %d, expected 11 of 110", info.Outputs, info.Amount)
+	}
+
+	bc.FlushUTXOCache()
+	UTXOSet{bc}.Reindex()
+
+	if !bytes.Equal(bc.GetUTXOSetInfo().Hash, info.Hash) {
+		t.Error("Reindexing changes the UTXO set hash")
+	}
+}
+
+// TestUTXOSetInfoWhileMining reads the UTXO set info while blocks are connected, run with
+// -race. Every block adds a coinbase output, so the outputs follow the height.
+func TestUTXOSetInfoWhileMining(t *testing.T) {
+	bc, wallet := newTestBlockchain(t)
+
+	mined := make(chan struct{})
+	go func() {
+		defer close(mined)
+		mineBlocks(bc, wallet, 50)
+	}()
+
+	for {
+		info := bc.GetUTXOSetInfo()
+		if info.Outputs != info.Height+1 {
+			t.Fatalf("%d outputs at height %d", info.Outputs, info.Height)
+		}
+		if hash, _ := bc.GetBlockHash(info.Height); !bytes.Equal(hash, info.BestBlock) {
+			t.Fatalf("Best block %x is not at height %d", info.BestBlock, info.Height)
+		}
+
+		select {
+		case <-mined:
+			return
+		default:
+		}
+	}
+}
@@ -0,0 +1,382 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+)
+
+const (
+	// snapshotFetchInterval is how often the background verification checks for a requested block
+	snapshotFetchInterval = 100 * time.Millisecond
+
+	// snapshotFetchRetry is the number of checks after which a missing block is requested again
+	snapshotFetchRetry = 50
+)
+
+// snapshotKey holds the header of the UTXO snapshot the node was loaded from in the
+// blocks bucket, until the history below the snapshot is verified
+var snapshotKey = []byte("s")
+
+// UTXOSnapshotHeader starts a UTXO snapshot. It is followed by the headers of the main
+// chain from the genesis block to the snapshot block, then by the unspent outputs.
+type UTXOSnapshotHeader struct {
+	Network     string
+	BlockHash   []byte
+	Height      int
+	Outputs     int
+	ContentHash []byte
+}
+
+type snapshotOutput struct {
+	TxID  []byte
+	Vout  int
+	Entry UTXOEntry
+}
+
+// utxoContentHash hashes the outputs of the store in key order, encoded as for the
+// UTXO set hash, and returns the hash with the number of outputs
+func utxoContentHash(utxos UTXOStore) ([]byte, int, error) {
+	hasher := sha256.New()
+	count := 0
+
+	err := utxos.ForEach(func(txID []byte, vout int, entry UTXOEntry) error {
+		hasher.Write(utxoHashElement(txID, vout, entry))
+		count++
+
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return hasher.Sum(nil), count, nil
+}
+
+// DumpUTXOSnapshot writes the chainstate at the tip, with the main chain headers it builds on
+func (bc *Blockchain) DumpUTXOSnapshot(w io.Writer) (UTXOSnapshotHeader, error) {
+	var header UTXOSnapshotHeader
+
+	// Validator sets are replayed from the governance transactions of every block
+	if bc.Params.Consensus == ConsensusPoA {
+		return header, errors.New("UTXO snapshots need proof of work")
+	}
+
+	// The bucket is written in key order, which the content hash depends on
+	bc.FlushUTXOCache()
+
+	err := bc.store.View(func(tx StoreTx) error {
+		b := tx.Bucket(blocksBucketName)
+		heights := tx.Bucket(heightIndexBucketName)
+		utxos := chainstate(tx)
+
+		tip, err := DeserializeBlock(b.Get(bc.tipHash()))
+		if err != nil {
+			return err
+		}
+
+		contentHash, count, err := utxoContentHash(utxos)
+		if err != nil {
+			return err
+		}
+
+		header = UTXOSnapshotHeader{bc.Params.Name, tip.Hash, tip.Height, count, contentHash}
+
+		enc := gob.NewEncoder(w)
+		err = enc.Encode(header)
+		if err != nil {
+			return err
+		}
+
+		for height := 0; height <= tip.Height; height++ {
+			block, err := DeserializeBlock(b.Get(heights.Get(heightKey(height))))
+			if err != nil {
+				return err
+			}
+
+			err = enc.Encode(block.header())
+			if err != nil {
+				return err
+			}
+		}
+
+		return utxos.ForEach(func(txID []byte, vout int, entry UTXOEntry) error {
+			return enc.Encode(snapshotOutput{txID, vout, entry})
+		})
+	})
+
+	return header, err
+}
+
+// LoadUTXOSnapshot creates a blockchain in the empty store from a UTXO snapshot the chain
+// params trust. The blocks below the snapshot are kept as headers, like on a pruned node,
+// so the node validates new blocks from the snapshot tip on.
+func LoadUTXOSnapshot(store ChainStore, r io.Reader, params *ChainParams) (*Blockchain, error) {
+	var header UTXOSnapshotHeader
+
+	dec := gob.NewDecoder(r)
+	err := dec.Decode(&header)
+	if err != nil {
+		return nil, err
+	}
+
+	if header.Network != params.Name {
+		return nil, fmt.Errorf("Snapshot is of network %s", header.Network)
+	}
+	if params.Consensus == ConsensusPoA {
+		return nil, errors.New("UTXO snapshots need proof of work")
+	}
+	if !params.assumeUTXO(header.Height, header.BlockHash, header.ContentHash) {
+		return nil, fmt.Errorf("Snapshot of block %x with content hash %x is not in the chain params", header.BlockHash, header.ContentHash)
+	}
+
+	err = store.Update(func(tx StoreTx) error {
+		err := writeMetadata(tx, params)
+		if err != nil {
+			return err
+		}
+
+		heights, err := tx.CreateBucket(heightIndexBucketName)
+		if err != nil {
+			return err
+		}
+
+		err = createChainstate(tx)
+		if err != nil {
+			return err
+		}
+
+		b, err := tx.CreateBucket(blocksBucketName)
+		if err != nil {
+			return err
+		}
+
+		var prev *Block
+		for height := 0; height <= header.Height; height++ {
+			var block Block
+			err = dec.Decode(&block)
+			if err != nil {
+				return err
+			}
+
+			if block.Height != height {
+				return fmt.Errorf("Snapshot header %x has height %d, expected %d", block.Hash, block.Height, height)
+			}
+			if prev == nil && params.GenesisBlock != "" && bytes.Compare(block.Hash, params.genesis("").Hash) != 0 {
+				return errors.New("Snapshot does not start at the genesis block")
+			}
+			if prev != nil && bytes.Compare(block.PrevBlockHash, prev.Hash) != 0 {
+				return fmt.Errorf("Snapshot header %x does not link to its parent", block.Hash)
+			}
+
+			err = b.Put(block.Hash, block.header().Serialize())
+			if err != nil {
+				return err
+			}
+
+			err = heights.Put(heightKey(height), block.Hash)
+			if err != nil {
+				return err
+			}
+
+			prev = &block
+		}
+
+		if prev == nil || bytes.Compare(prev.Hash, header.BlockHash) != 0 {
+			return errors.New("Snapshot headers do not end at the snapshot block")
+		}
+
+		utxos := &hashedUTXOStore{chainstate(tx), newUTXOSetState()}
+		for i := 0; i < header.Outputs; i++ {
+			var out snapshotOutput
+			err = dec.Decode(&out)
+			if err != nil {
+				return err
+			}
+
+			err = utxos.Put(out.TxID, out.Vout, out.Entry)
+			if err != nil {
+				return err
+			}
+		}
+
+		contentHash, _, err := utxoContentHash(utxos)
+		if err != nil {
+			return err
+		}
+		if bytes.Compare(contentHash, header.ContentHash) != 0 {
+			return fmt.Errorf("Snapshot content hash is %x, expected %x", contentHash, header.ContentHash)
+		}
+
+		info := tx.Bucket(chainstateInfoBucketName)
+		err = info.Put(utxoSetStateKey, utxos.state.Serialize())
+		if err != nil {
+			return err
+		}
+
+		err = info.Put(bestBlockKey, header.BlockHash)
+		if err != nil {
+			return err
+		}
+
+		err = b.Put(prunedHeightKey, heightKey(header.Height))
+		if err != nil {
+			return err
+		}
+
+		err = b.Put(snapshotKey, GobEncode(header))
+		if err != nil {
+			return err
+		}
+
+		return b.Put(lastHashKey, header.BlockHash)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return NewBlockchainWithStore(store, params), nil
+}
+
+// UTXOSnapshot returns the header of the snapshot the node was loaded from,
+// false when there is none or its history is verified
+func (bc *Blockchain) UTXOSnapshot() (UTXOSnapshotHeader, bool) {
+	var header UTXOSnapshotHeader
+	found := false
+
+	err := bc.store.View(func(tx StoreTx) error {
+		data := tx.Bucket(blocksBucketName).Get(snapshotKey)
+		if data == nil {
+			return nil
+		}
+
+		found = true
+		return gob.NewDecoder(bytes.NewReader(data)).Decode(&header)
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return header, found
+}
+
+// storeSnapshotBody stores the body of a block below the snapshot when it arrives
+// while the node verifies the snapshot history
+func (bc *Blockchain) storeSnapshotBody(b StoreBucket, stored, block *Block) error {
+	if b.Get(snapshotKey) == nil || !stored.IsPruned() || block.IsPruned() {
+		return nil
+	}
+
+	// The proof of work commits to the transactions, the height is taken from the header
+	err := bc.Engine().VerifySeal(block)
+	if err != nil {
+		return err
+	}
+	body := *block
+	body.Height = stored.Height
+
+	return b.Put(body.Hash, body.Serialize())
+}
+
+// VerifyUTXOSnapshot replays the blocks below the snapshot the node was loaded from and
+// checks that they produce the snapshot's UTXO set. Missing block bodies are requested
+// with fetch until they arrive. Once verified the snapshot is forgotten, and a node that
+// does not prune keeps the bodies and serves the history again.
+func (bc *Blockchain) VerifyUTXOSnapshot(fetch func(hash []byte)) error {
+	header, ok := bc.UTXOSnapshot()
+	if !ok {
+		return nil
+	}
+
+	replay := NewMemoryStore()
+	err := replay.Update(createChainstate)
+	if err != nil {
+		return err
+	}
+
+	for height := 0; height <= header.Height; height++ {
+		block, err := bc.snapshotBlock(height, fetch)
+		if err != nil {
+			return err
+		}
+
+		err = replay.Update(func(tx StoreTx) error {
+			_, err := applyBlockUTXOs(chainstate(tx), block)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		if (height+1)%reindexProgressInterval == 0 || height == header.Height {
+			fmt.Printf("Verified %d of %d snapshot blocks\n", height+1, header.Height+1)
+		}
+	}
+
+	var contentHash []byte
+	err = replay.View(func(tx StoreTx) error {
+		contentHash, _, err = utxoContentHash(chainstate(tx))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if bytes.Compare(contentHash, header.ContentHash) != 0 {
+		return fmt.Errorf("UTXO snapshot of block %x does not match the chain history", header.BlockHash)
+	}
+
+	return bc.store.Update(func(tx StoreTx) error {
+		b := tx.Bucket(blocksBucketName)
+		heights := tx.Bucket(heightIndexBucketName)
+
+		err := b.Delete(snapshotKey)
+		if err != nil {
+			return err
+		}
+
+		// Pruning nodes drop the bodies again
+		if bc.pruneTarget > 0 {
+			for height := 0; height <= header.Height; height++ {
+				block, err := DeserializeBlock(b.Get(heights.Get(heightKey(height))))
+				if err != nil {
+					return err
+				}
+
+				err = b.Put(block.Hash, block.header().Serialize())
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		if prunedHeight(b) == header.Height {
+			return b.Delete(prunedHeightKey)
+		}
+		return nil
+	})
+}
+
+// snapshotBlock returns the main chain block at the height, waiting for its body when it is missing
+func (bc *Blockchain) snapshotBlock(height int, fetch func(hash []byte)) (*Block, error) {
+	for i := 0; ; i++ {
+		block, err := bc.GetBlockByHeight(height)
+		if err != nil {
+			return nil, err
+		}
+		if !block.IsPruned() {
+			return &block, nil
+		}
+
+		if i%snapshotFetchRetry == 0 {
+			fetch(block.Hash)
+		}
+		time.Sleep(snapshotFetchInterval)
+	}
+}
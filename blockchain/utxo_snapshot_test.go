@@ -0,0 +1,165 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"testing"
+)
+
+// decodeSnapshot splits a UTXO snapshot into its header, block headers and outputs
+func decodeSnapshot(t *testing.T, data []byte) (UTXOSnapshotHeader, []Block, []snapshotOutput) {
+	t.Helper()
+
+	var header UTXOSnapshotHeader
+	dec := gob.NewDecoder(bytes.NewReader(data))
+	if err := dec.Decode(&header); err != nil {
+		t.Fatal(err)
+	}
+
+	blocks := make([]Block, header.Height+1)
+	for i := range blocks {
+		if err := dec.Decode(&blocks[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	outputs := make([]snapshotOutput, header.Outputs)
+	for i := range outputs {
+		if err := dec.Decode(&outputs[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return header, blocks, outputs
+}
+
+func encodeSnapshot(t *testing.T, header UTXOSnapshotHeader, blocks []Block, outputs []snapshotOutput) []byte {
+	t.Helper()
+
+	var buff bytes.Buffer
+	enc := gob.NewEncoder(&buff)
+	if err := enc.Encode(header); err != nil {
+		t.Fatal(err)
+	}
+	for _, block := range blocks {
+		if err := enc.Encode(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, out := range outputs {
+		if err := enc.Encode(out); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return buff.Bytes()
+}
+
+func trustSnapshot(params ChainParams, header UTXOSnapshotHeader) *ChainParams {
+	params.AssumeUTXO = []UTXOSnapshotParams{{
+		Height:      header.Height,
+		BlockHash:   hex.EncodeToString(header.BlockHash),
+		ContentHash: hex.EncodeToString(header.ContentHash),
+	}}
+
+	return &params
+}
+
+func TestUTXOSnapshotRoundTrip(t *testing.T) {
+	bc, wallet := newTestBlockchain(t)
+	mineBlocks(bc, wallet, 20)
+
+	var buff bytes.Buffer
+	header, err := bc.DumpUTXOSnapshot(&buff)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Hashing the set again in the same process must give the dumped content hash
+	var contentHash []byte
+	err = bc.store.View(func(tx StoreTx) error {
+		contentHash, _, err = utxoContentHash(chainstate(tx))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(contentHash, header.ContentHash) {
+		t.Fatalf("Content hash is %x, dumped %x", contentHash, header.ContentHash)
+	}
+
+	loaded, err := LoadUTXOSnapshot(NewMemoryStore(), bytes.NewReader(buff.Bytes()), trustSnapshot(*bc.Params, header))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if loaded.GetBestHeight() != 20 {
+		t.Errorf("Loaded chain is at height %d, expected 20", loaded.GetBestHeight())
+	}
+	if !bytes.Equal(loaded.GetUTXOSetInfo().Hash, bc.GetUTXOSetInfo().Hash) {
+		t.Error("Loaded UTXO set hash differs")
+	}
+
+	// New blocks extend the loaded chain, then the history below the snapshot is verified
+	next, err := bc.MineBlock([]*Transaction{newTestCoinbase(bc, wallet)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded.AddBlock(next)
+	if loaded.GetBestHeight() != 21 {
+		t.Fatalf("Loaded chain is at height %d, expected 21", loaded.GetBestHeight())
+	}
+
+	err = loaded.VerifyUTXOSnapshot(func(hash []byte) {
+		block, err := bc.GetBlock(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		loaded.AddBlock(&block)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := loaded.UTXOSnapshot(); ok {
+		t.Error("Snapshot is kept after its history is verified")
+	}
+}
+
+func TestLoadUTXOSnapshotRejects(t *testing.T) {
+	bc, wallet := newTestBlockchain(t)
+	mineBlocks(bc, wallet, 5)
+
+	var buff bytes.Buffer
+	header, err := bc.DumpUTXOSnapshot(&buff)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		params *ChainParams
+		change func(*UTXOSnapshotHeader, []snapshotOutput)
+	}{
+		{"untrusted", bc.Params, func(*UTXOSnapshotHeader, []snapshotOutput) {}},
+		{"other network", trustSnapshot(*bc.Params, header), func(h *UTXOSnapshotHeader, _ []snapshotOutput) {
+			h.Network = TestNetParams.Name
+		}},
+		{"changed output", trustSnapshot(*bc.Params, header), func(_ *UTXOSnapshotHeader, outputs []snapshotOutput) {
+			outputs[0].Entry.Value++
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			snapshotHeader, blocks, outputs := decodeSnapshot(t, buff.Bytes())
+			test.change(&snapshotHeader, outputs)
+			data := encodeSnapshot(t, snapshotHeader, blocks, outputs)
+
+			_, err := LoadUTXOSnapshot(NewMemoryStore(), bytes.NewReader(data), test.params)
+			if err == nil {
+				t.Error("Snapshot is loaded")
+			}
+		})
+	}
+}
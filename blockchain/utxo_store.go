@@ -0,0 +1,113 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"log"
+)
+
+// UTXOEntry is an unspent output with the height of its block and whether it is a coinbase output
+type UTXOEntry struct {
+	Value      int
+	PubKeyHash []byte
+	Height     int
+	Coinbase   bool
+}
+
+// Output returns the transaction output of the entry
+func (e UTXOEntry) Output() TXOutput {
+	return TXOutput{e.Value, e.PubKeyHash}
+}
+
+// Serialize serializes the UTXO entry
+func (e UTXOEntry) Serialize() []byte {
+	var buff bytes.Buffer
+
+	encoder := gob.NewEncoder(&buff)
+	err := encoder.Encode(e)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return buff.Bytes()
+}
+
+// DeserializeUTXOEntry deserializes a UTXO entry
+func DeserializeUTXOEntry(data []byte) UTXOEntry {
+	var entry UTXOEntry
+
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	err := decoder.Decode(&entry)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return entry
+}
+
+// outpointKey keys an output by transaction ID and output index
+func outpointKey(txID []byte, vout int) []byte {
+	key := make([]byte, len(txID)+4)
+	copy(key, txID)
+	binary.BigEndian.PutUint32(key[len(txID):], uint32(vout))
+
+	return key
+}
+
+// splitOutpointKey returns the transaction ID and output index of an outpoint key
+func splitOutpointKey(key []byte) ([]byte, int) {
+	n := len(key) - 4
+	return key[:n], int(binary.BigEndian.Uint32(key[n:]))
+}
+
+// UTXOStore holds the unspent outputs of the chainstate by outpoint
+type UTXOStore interface {
+	// Get returns the unspent output, false if it is spent or does not exist
+	Get(txID []byte, vout int) (UTXOEntry, bool)
+	Put(txID []byte, vout int, entry UTXOEntry) error
+	Delete(txID []byte, vout int) error
+
+	// ForEach calls fn for every unspent output
+	ForEach(fn func(txID []byte, vout int, entry UTXOEntry) error) error
+}
+
+// bucketUTXOStore keeps the unspent outputs in the UTXO bucket of a store transaction
+type bucketUTXOStore struct {
+	bucket StoreBucket
+}
+
+// chainstate returns the UTXO store of the store transaction
+func chainstate(tx StoreTx) UTXOStore {
+	return bucketUTXOStore{tx.Bucket(utxoBucketName)}
+}
+
+func (s bucketUTXOStore) Get(txID []byte, vout int) (UTXOEntry, bool) {
+	data := s.bucket.Get(outpointKey(txID, vout))
+	if data == nil {
+		return UTXOEntry{}, false
+	}
+
+	return DeserializeUTXOEntry(data), true
+}
+
+func (s bucketUTXOStore) Put(txID []byte, vout int, entry UTXOEntry) error {
+	return s.bucket.Put(outpointKey(txID, vout), entry.Serialize())
+}
+
+func (s bucketUTXOStore) Delete(txID []byte, vout int) error {
+	return s.bucket.Delete(outpointKey(txID, vout))
+}
+
+func (s bucketUTXOStore) ForEach(fn func(txID []byte, vout int, entry UTXOEntry) error) error {
+	c := s.bucket.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		txID, vout := splitOutpointKey(k)
+		err := fn(txID, vout, DeserializeUTXOEntry(v))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
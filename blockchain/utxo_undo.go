@@ -0,0 +1,190 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"log"
+)
+
+const (
+	undoBucket           = "undo"
+	chainstateInfoBucket = "chainstateinfo"
+)
+
+var (
+	undoBucketName           = []byte(undoBucket)
+	chainstateInfoBucketName = []byte(chainstateInfoBucket)
+
+	// bestBlockKey holds the hash of the block the chainstate is at
+	bestBlockKey = []byte("best")
+)
+
+// utxoUndo holds the outputs a block spent, in the order its inputs spent them
+type utxoUndo struct {
+	Spent []spentOutput
+}
+
+type spentOutput struct {
+	TxID  []byte
+	Vout  int
+	Entry UTXOEntry
+}
+
+// Serialize serializes the undo data
+func (u utxoUndo) Serialize() []byte {
+	return GobEncode(u)
+}
+
+func deserializeUTXOUndo(data []byte) utxoUndo {
+	var undo utxoUndo
+
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	err := decoder.Decode(&undo)
+	logPanicErr(err)
+
+	return undo
+}
+
+// applyBlockUTXOs spends the outputs the block's inputs reference and adds its new outputs.
+// It returns the undo data reverting the change.
+func applyBlockUTXOs(utxos UTXOStore, block *Block) (utxoUndo, error) {
+	var undo utxoUndo
+
+	for _, tx := range block.Transactions {
+		// Governance transactions hold no value
+		if tx.IsGovernance() {
+			continue
+		}
+
+		if !tx.IsCoinbase() {
+			for _, in := range tx.Vin {
+				entry, ok := utxos.Get(in.TxID, in.Vout)
+				if !ok {
+					return undo, fmt.Errorf("Transaction %x spends missing output %x:%d", tx.ID, in.TxID, in.Vout)
+				}
+
+				err := utxos.Delete(in.TxID, in.Vout)
+				if err != nil {
+					return undo, err
+				}
+				undo.Spent = append(undo.Spent, spentOutput{in.TxID, in.Vout, entry})
+			}
+		}
+
+		for i, out := range tx.Vout {
+			entry := UTXOEntry{out.Value, out.PubKeyHash, block.Height, tx.IsCoinbase()}
+			err := utxos.Put(tx.ID, i, entry)
+			if err != nil {
+				return undo, err
+			}
+		}
+	}
+
+	return undo, nil
+}
+
+// revertBlockUTXOs removes the outputs of the block and restores the outputs it spent.
+// Transactions are reverted last to first, so outputs spent within the block are handled.
+func revertBlockUTXOs(utxos UTXOStore, block *Block, undo utxoUndo) error {
+	next := len(undo.Spent)
+
+	for i := len(block.Transactions) - 1; i >= 0; i-- {
+		tx := block.Transactions[i]
+		if tx.IsGovernance() {
+			continue
+		}
+
+		for vout := range tx.Vout {
+			err := utxos.Delete(tx.ID, vout)
+			if err != nil {
+				return err
+			}
+		}
+
+		if tx.IsCoinbase() {
+			continue
+		}
+
+		for j := len(tx.Vin) - 1; j >= 0; j-- {
+			next--
+			if next < 0 {
+				return fmt.Errorf("Undo data of block %x is incomplete", block.Hash)
+			}
+
+			spent := undo.Spent[next]
+			err := utxos.Put(spent.TxID, spent.Vout, spent.Entry)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// connectBlockUTXOs updates the unspent outputs for a block joining the main chain and stores its undo data
+func connectBlockUTXOs(tx StoreTx, utxos UTXOStore, block *Block) error {
+	undo, err := applyBlockUTXOs(utxos, block)
+	if err != nil {
+		return err
+	}
+
+	return tx.Bucket(undoBucketName).Put(block.Hash, undo.Serialize())
+}
+
+// disconnectBlockUTXOs reverts the unspent outputs of a block leaving the main chain
+func disconnectBlockUTXOs(tx StoreTx, utxos UTXOStore, block *Block) error {
+	undoBucket := tx.Bucket(undoBucketName)
+	undoData := undoBucket.Get(block.Hash)
+	if undoData == nil {
+		return fmt.Errorf("Block %x has no undo data", block.Hash)
+	}
+
+	err := revertBlockUTXOs(utxos, block, deserializeUTXOUndo(undoData))
+	if err != nil {
+		return err
+	}
+
+	return undoBucket.Delete(block.Hash)
+}
+
+// createChainstate creates empty chainstate, undo and chainstate info buckets,
+// replacing existing ones
+func createChainstate(tx StoreTx) error {
+	for _, name := range [][]byte{utxoBucketName, undoBucketName, chainstateInfoBucketName} {
+		if tx.Bucket(name) != nil {
+			err := tx.DeleteBucket(name)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err := tx.CreateBucket(name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// chainstateBestBlock returns the block the chainstate is at, nil if it is missing
+func (bc *Blockchain) chainstateBestBlock() []byte {
+	var best []byte
+
+	err := bc.store.View(func(tx StoreTx) error {
+		info := tx.Bucket(chainstateInfoBucketName)
+		if info == nil || tx.Bucket(utxoBucketName) == nil || tx.Bucket(undoBucketName) == nil {
+			return nil
+		}
+
+		best = append([]byte{}, info.Get(bestBlockKey)...)
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return best
+}
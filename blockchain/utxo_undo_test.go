@@ -0,0 +1,103 @@
+package blockchain
+
+import (
+	"reflect"
+	"testing"
+)
+
+// utxoContents returns the entries of the UTXO store by outpoint
+func utxoContents(utxos UTXOStore) map[string]UTXOEntry {
+	contents := make(map[string]UTXOEntry)
+	err := utxos.ForEach(func(txID []byte, vout int, entry UTXOEntry) error {
+		contents[string(outpointKey(txID, vout))] = entry
+		return nil
+	})
+	logPanicErr(err)
+
+	return contents
+}
+
+func TestBlockUTXOUndo(t *testing.T) {
+	params := RegTestParams
+	wallet := NewWallet()
+	address := string(wallet.GetAddress(&params))
+
+	funding := NewCoinbaseTX(address, "", 50)
+	spend := func(txID []byte, vout int, values ...int) *Transaction {
+		tx := &Transaction{Vin: []TXInput{{txID, vout, wallet.PublicKey, nil}}}
+		for _, value := range values {
+			tx.Vout = append(tx.Vout, *NewTXOutput(value, address))
+		}
+		tx.ID = tx.Hash()
+		return tx
+	}
+	first := spend(funding.ID, 0, 20, 30)
+
+	tests := []struct {
+		name  string
+		txs   []*Transaction
+		spent int
+		fails bool
+	}{
+		{"coinbase", nil, 0, false},
+		{"spend", []*Transaction{first}, 1, false},
+		{"spend in the block", []*Transaction{first, spend(first.ID, 1, 30)}, 2, false},
+		{"spend twice in the block", []*Transaction{first, spend(funding.ID, 0, 50)}, 0, true},
+		{"spend missing output", []*Transaction{spend([]byte("missing"), 0, 1)}, 0, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := NewMemoryStore()
+			err := store.Update(func(tx StoreTx) error {
+				err := createChainstate(tx)
+				if err != nil {
+					return err
+				}
+				utxos := chainstate(tx)
+
+				// The funding output is in the set before the block
+				_, err = applyBlockUTXOs(utxos, &Block{Transactions: []*Transaction{funding}})
+				if err != nil {
+					return err
+				}
+				before := utxoContents(utxos)
+
+				txs := append([]*Transaction{NewCoinbaseTX(address, "", 50)}, test.txs...)
+				block := &Block{Height: 1, Transactions: txs}
+
+				undo, err := applyBlockUTXOs(utxos, block)
+				if (err != nil) != test.fails {
+					t.Fatalf("Error %v, expected failure: %t", err, test.fails)
+				}
+				if test.fails {
+					return nil
+				}
+				if len(undo.Spent) != test.spent {
+					t.Errorf("Undo data has %d spent outputs, expected %d", len(undo.Spent), test.spent)
+				}
+
+				for _, tx := range txs {
+					for _, in := range tx.Vin {
+						if _, ok := utxos.Get(in.TxID, in.Vout); ok && !tx.IsCoinbase() {
+							t.Errorf("Output %x:%d is unspent", in.TxID, in.Vout)
+						}
+					}
+				}
+
+				err = revertBlockUTXOs(utxos, block, deserializeUTXOUndo(undo.Serialize()))
+				if err != nil {
+					return err
+				}
+				if after := utxoContents(utxos); !reflect.DeepEqual(after, before) {
+					t.Errorf("Reverted set has %d outputs, expected %d", len(after), len(before))
+				}
+
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -13,10 +13,7 @@ import (
 	"golang.org/x/crypto/ripemd160"
 )
 
-const (
-	verzion            = byte(0x00)
-	addressChecksumLen = 4
-)
+const addressChecksumLen = 4
 
 // Wallet stores private and public keys
 type Wallet struct {
@@ -31,10 +28,10 @@ func NewWallet() *Wallet {
 	return &wallet
 }
 
-// GetAddress returns wallet address
-func (w Wallet) GetAddress() []byte {
+// GetAddress returns wallet address on the network
+func (w Wallet) GetAddress(params *ChainParams) []byte {
 	pubKeyHash := HashPubKey(w.PublicKey)
-	versionPaylod := append([]byte{verzion}, pubKeyHash...)
+	versionPaylod := append([]byte{params.AddressVersion}, pubKeyHash...)
 	checksum := checksum(versionPaylod)
 
 	fullPayload := append(versionPaylod, checksum...)
@@ -62,13 +59,21 @@ func GetPubKeyHash(address []byte) []byte {
 	return fullPayload[1 : len(fullPayload)-addressChecksumLen]
 }
 
-// ValidateAddress check if address if valid
-func ValidateAddress(address string) bool {
+// ValidateAddress check if address if valid on the network
+func ValidateAddress(address string, params *ChainParams) bool {
 	fullPayload := base58.Decode([]byte(address))
+	if len(fullPayload) <= addressChecksumLen {
+		return false
+	}
+
 	expectChecksum := fullPayload[len(fullPayload)-addressChecksumLen:]
 	version := fullPayload[0]
 	pubKeyHash := fullPayload[1 : len(fullPayload)-addressChecksumLen]
 
+	if version != params.AddressVersion {
+		return false
+	}
+
 	gotChecksum := checksum(append([]byte{version}, pubKeyHash...))
 	return bytes.Compare(expectChecksum, gotChecksum) == 0
 }
@@ -89,7 +94,7 @@ func newKeyPair() (ecdsa.PrivateKey, []byte) {
 	if err != nil {
 		log.Panic(err)
 	}
-	pubKey := append(private.PublicKey.X.Bytes(), private.PublicKey.Y.Bytes()...)
+	pubKey := padCurvePoints(curve, private.PublicKey.X, private.PublicKey.Y)
 
 	return *private, pubKey
 }
@@ -4,22 +4,21 @@ import (
 	"bytes"
 	"crypto/elliptic"
 	"encoding/gob"
-	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 )
 
-const walletFile = "wallet_%s.dat"
-
 // Wallets stores a collection of wallets
 type Wallets struct {
 	Wallets map[string]*Wallet
+
+	params *ChainParams
 }
 
 // NewWallets creates wallets and load wallet information to it if wallet file exist
-func NewWallets(nodeID string) (*Wallets, error) {
-	ws := Wallets{}
+func NewWallets(nodeID string, params *ChainParams) (*Wallets, error) {
+	ws := Wallets{params: params}
 	ws.Wallets = make(map[string]*Wallet)
 	err := ws.LoadFromFile(nodeID)
 	return &ws, err
@@ -28,7 +27,7 @@ func NewWallets(nodeID string) (*Wallets, error) {
 // CreateWallet creates a new wallet and save to it to wallet file
 func (ws *Wallets) CreateWallet() string {
 	wallet := NewWallet()
-	address := string(wallet.GetAddress())
+	address := string(wallet.GetAddress(ws.params))
 
 	ws.Wallets[address] = wallet
 	return address
@@ -50,7 +49,7 @@ func (ws *Wallets) GetWallet(address string) Wallet {
 
 // LoadFromFile loads wallets from wallet file if file exists
 func (ws *Wallets) LoadFromFile(nodeID string) error {
-	walletFile := fmt.Sprintf(walletFile, nodeID)
+	walletFile := ws.params.walletFile(nodeID)
 	if _, err := os.Stat(walletFile); os.IsNotExist(err) {
 		return err
 	}
@@ -75,7 +74,7 @@ func (ws *Wallets) LoadFromFile(nodeID string) error {
 
 // SaveToFile saves wallets to wallet file
 func (ws Wallets) SaveToFile(nodeID string) {
-	walletFile := fmt.Sprintf(walletFile, nodeID)
+	walletFile := ws.params.walletFile(nodeID)
 
 	var content bytes.Buffer
 